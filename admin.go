@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// serverShutdownTimeout bounds how long in-flight HTTP requests may delay shutdown
+const serverShutdownTimeout = 5 * time.Second
+
+// AdminStatus is the response body of GET /status
+type AdminStatus struct {
+	App          string `json:"app,omitempty"`
+	Repo         string `json:"repo"`
+	ActiveSlot   string `json:"active_slot"`
+	Version      string `json:"version"`
+	BlueVersion  string `json:"blue_version"`
+	GreenVersion string `json:"green_version"`
+	Paused       bool   `json:"paused"`
+	Frozen       bool   `json:"frozen"`
+	FreezeReason string `json:"freeze_reason,omitempty"`
+	StagedTag    string `json:"staged_tag,omitempty"`
+	Busy         bool   `json:"busy"`
+	Phase        string `json:"phase"`
+	DryRun       bool   `json:"dry_run"`
+}
+
+// adminError is the response body of a failed request
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// startAdminServer starts the admin API if it is configured. Operations run
+// with ctx, so they outlive the request that started them but stop on shutdown.
+func (dm *Daemon) startAdminServer(ctx context.Context) (*http.Server, error) {
+	cfg := dm.config.Admin
+	if cfg.Listen == "" {
+		return nil, nil
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("admin.token is required when admin.listen is set")
+	}
+
+	listener, err := listenAddress(cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{
+		Handler:           dm.adminHandler(ctx),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			dm.logger.Error("Admin API stopped", "error", err)
+		}
+	}()
+	dm.logger.Info("Admin API listening", "address", cfg.Listen)
+	return srv, nil
+}
+
+// listenAddress listens on a TCP address or, with a unix: prefix, on a unix
+// socket that only the deployer's user can connect to
+func listenAddress(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// Remove a socket left behind by an earlier run, but never anything else
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// adminHandler routes the admin API endpoints behind bearer token
+// authentication. With several apps, requests name theirs with ?app=.
+func (dm *Daemon) adminHandler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", adminMethod(http.MethodGet, dm.handleStatus))
+	mux.HandleFunc("/history", adminMethod(http.MethodGet, dm.forApp(func(d *Deployer) http.HandlerFunc {
+		return d.handleHistory
+	})))
+	mux.HandleFunc("/deploy", adminMethod(http.MethodPost, dm.forApp(func(d *Deployer) http.HandlerFunc {
+		return d.adminOperation(func(r *http.Request) error {
+			tag := r.URL.Query().Get("tag")
+			if tag == "" {
+				return errMissingTag
+			}
+			return d.DeployTag(ctx, tag)
+		})
+	})))
+	mux.HandleFunc("/rollback", adminMethod(http.MethodPost, dm.forApp(func(d *Deployer) http.HandlerFunc {
+		return d.adminOperation(func(*http.Request) error {
+			return d.Rollback(ctx)
+		})
+	})))
+	mux.HandleFunc("/pause", adminMethod(http.MethodPost, dm.forApp(func(d *Deployer) http.HandlerFunc {
+		return d.adminOperation(func(*http.Request) error {
+			return d.SetPaused(true)
+		})
+	})))
+	mux.HandleFunc("/resume", adminMethod(http.MethodPost, dm.forApp(func(d *Deployer) http.HandlerFunc {
+		return d.adminOperation(func(*http.Request) error {
+			return d.SetPaused(false)
+		})
+	})))
+	mux.HandleFunc("/check", adminMethod(http.MethodPost, dm.forApp(func(d *Deployer) http.HandlerFunc {
+		return d.adminOperation(func(*http.Request) error {
+			return d.checkAndDeploy(ctx)
+		})
+	})))
+	return adminAuth(dm.config.Admin.Token, mux)
+}
+
+// errMissingTag is returned by POST /deploy without a tag parameter
+var errMissingTag = errors.New("tag parameter is required")
+
+// adminAuth rejects requests without the bearer token
+func adminAuth(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, adminError{Error: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminMethod restricts a handler to one HTTP method
+func adminMethod(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, adminError{Error: "method not allowed"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// forApp serves a request with the handler of the app named by the app
+// query parameter
+func (dm *Daemon) forApp(handler func(d *Deployer) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := dm.app(r.URL.Query().Get("app"))
+		if err != nil {
+			code := http.StatusNotFound
+			if errors.Is(err, errAppRequired) {
+				code = http.StatusBadRequest
+			}
+			writeJSON(w, code, adminError{Error: err.Error()})
+			return
+		}
+		handler(d)(w, r)
+	}
+}
+
+// adminOperation runs fn unless another check, deploy or rollback is in
+// progress, and responds with the resulting status
+func (d *Deployer) adminOperation(fn func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !d.opMu.TryLock() {
+			writeJSON(w, http.StatusConflict, adminError{Error: "another operation is in progress"})
+			return
+		}
+		finished := d.beginOperation()
+		err := fn(r)
+		finished()
+		if err == nil {
+			d.setStatus("%s", d.idleStatus())
+		} else if !errors.Is(err, errMissingTag) {
+			d.setStatus("Last operation failed: %v", err)
+		}
+		d.writeMetricsTextfile()
+		d.opMu.Unlock()
+
+		if err != nil {
+			code := http.StatusInternalServerError
+			var held *LockHeldError
+			switch {
+			case errors.Is(err, errMissingTag):
+				code = http.StatusBadRequest
+			case errors.As(err, &held):
+				code = http.StatusConflict
+			}
+			d.logger.Error("Admin request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+			writeJSON(w, code, adminError{Error: err.Error()})
+			return
+		}
+		d.handleStatus(w, r)
+	}
+}
+
+// handleStatus serves GET /status: one app's status, or with several apps
+// and no app parameter, a list of every app's status
+func (dm *Daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("app") != "" || len(dm.apps) == 1 {
+		dm.forApp(func(d *Deployer) http.HandlerFunc { return d.handleStatus })(w, r)
+		return
+	}
+	statuses := make([]AdminStatus, 0, len(dm.apps))
+	for _, d := range dm.apps {
+		status, err := d.status()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+			return
+		}
+		statuses = append(statuses, status)
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleStatus serves the app's status
+func (d *Deployer) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	status, err := d.status()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// status reports the app's status from the saved state, so it never waits
+// for an operation in progress
+func (d *Deployer) status() (AdminStatus, error) {
+	config := d.currentConfig()
+	state, err := LoadState(config.StateFile)
+	if err != nil {
+		return AdminStatus{}, err
+	}
+
+	// The staged release is only known between operations
+	var staged string
+	busy := !d.opMu.TryLock()
+	if !busy {
+		staged = d.stagedTag
+		d.opMu.Unlock()
+	}
+	frozen, reason := readFreeze(config.FreezeFile)
+
+	version := state.BlueVersion
+	if state.ActiveSlot == "green" {
+		version = state.GreenVersion
+	}
+	return AdminStatus{
+		App:          config.Name,
+		Repo:         config.Repo,
+		ActiveSlot:   state.ActiveSlot,
+		Version:      version,
+		BlueVersion:  state.BlueVersion,
+		GreenVersion: state.GreenVersion,
+		Paused:       state.Paused,
+		Frozen:       frozen,
+		FreezeReason: reason,
+		StagedTag:    staged,
+		Busy:         busy,
+		Phase:        d.currentPhase(),
+		DryRun:       d.dryRun,
+	}, nil
+}
+
+// handleHistory serves GET /history, newest entry first
+func (d *Deployer) handleHistory(w http.ResponseWriter, _ *http.Request) {
+	state, err := LoadState(d.currentConfig().StateFile)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+		return
+	}
+	history := make([]HistoryEntry, 0, len(state.History))
+	for i := len(state.History) - 1; i >= 0; i-- {
+		history = append(history, state.History[i])
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newAdminTestServer serves the admin API of a deployer backed by a test
+// release server publishing v1.0.0
+func newAdminTestServer(t *testing.T, state *DeploymentState) (*Deployer, *httptest.Server) {
+	t.Helper()
+	releases := newTestReleaseServer(t, "v1.0.0", map[string]string{"app.txt": "v1"})
+	config := setupSlots(t, state)
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.Admin = AdminConfig{Listen: "127.0.0.1:0", Token: "secret"}
+	d := newTestDeployer(t, config, releases)
+
+	admin := httptest.NewServer(newTestDaemon(d).adminHandler(context.Background()))
+	t.Cleanup(admin.Close)
+	return d, admin
+}
+
+// adminRequest sends an authenticated admin request and decodes the JSON response into out
+func adminRequest(t *testing.T, admin *httptest.Server, method, path string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, admin.URL+path, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := admin.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Failed to decode %s %s response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	_, admin := newAdminTestServer(t, &DeploymentState{ActiveSlot: "blue"})
+
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		req, _ := http.NewRequest(http.MethodGet, admin.URL+"/status", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := admin.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected 401, got %d", auth, resp.StatusCode)
+		}
+	}
+}
+
+func TestAdminStatus(t *testing.T) {
+	_, admin := newAdminTestServer(t, &DeploymentState{ActiveSlot: "green", BlueVersion: "v0.9.0", GreenVersion: "v0.9.1"})
+
+	var status AdminStatus
+	if code := adminRequest(t, admin, http.MethodGet, "/status", &status); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if status.ActiveSlot != "green" || status.Version != "v0.9.1" || status.BlueVersion != "v0.9.0" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if status.Busy || status.Paused {
+		t.Errorf("Expected idle, unpaused deployer, got %+v", status)
+	}
+
+	if code := adminRequest(t, admin, http.MethodPost, "/status", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST /status, got %d", code)
+	}
+}
+
+func TestAdminDeployRollbackAndHistory(t *testing.T) {
+	_, admin := newAdminTestServer(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v0.9.0"})
+
+	if code := adminRequest(t, admin, http.MethodPost, "/deploy", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without tag, got %d", code)
+	}
+
+	var status AdminStatus
+	if code := adminRequest(t, admin, http.MethodPost, "/deploy?tag=v1.0.0", &status); code != http.StatusOK {
+		t.Fatalf("Deploy failed with %d", code)
+	}
+	if status.ActiveSlot != "green" || status.Version != "v1.0.0" {
+		t.Errorf("Expected v1.0.0 live in green slot, got %+v", status)
+	}
+
+	if code := adminRequest(t, admin, http.MethodPost, "/rollback", &status); code != http.StatusOK {
+		t.Fatalf("Rollback failed with %d", code)
+	}
+	if status.ActiveSlot != "blue" || status.Version != "v0.9.0" {
+		t.Errorf("Expected rollback to v0.9.0 in blue slot, got %+v", status)
+	}
+
+	var history []HistoryEntry
+	if code := adminRequest(t, admin, http.MethodGet, "/history", &history); code != http.StatusOK {
+		t.Fatalf("History failed with %d", code)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 history entries, got %+v", history)
+	}
+	if history[0].Action != "rollback" || history[0].Tag != "v0.9.0" || !history[0].Success {
+		t.Errorf("Unexpected newest entry %+v", history[0])
+	}
+	if history[1].Action != "deploy" || history[1].Tag != "v1.0.0" || history[1].PreviousTag != "v0.9.0" {
+		t.Errorf("Unexpected oldest entry %+v", history[1])
+	}
+}
+
+func TestAdminPauseSkipsChecks(t *testing.T) {
+	d, admin := newAdminTestServer(t, &DeploymentState{ActiveSlot: "blue"})
+
+	var status AdminStatus
+	if code := adminRequest(t, admin, http.MethodPost, "/pause", &status); code != http.StatusOK || !status.Paused {
+		t.Fatalf("Pause failed with %d: %+v", code, status)
+	}
+	if code := adminRequest(t, admin, http.MethodPost, "/check", &status); code != http.StatusOK {
+		t.Fatalf("Check failed with %d", code)
+	}
+	if status.Version != "" {
+		t.Errorf("Paused deployer should not deploy, got %+v", status)
+	}
+
+	state, err := LoadState(d.config.StateFile)
+	if err != nil || !state.Paused {
+		t.Fatalf("Expected paused state to be saved, got %+v (%v)", state, err)
+	}
+
+	if code := adminRequest(t, admin, http.MethodPost, "/resume", nil); code != http.StatusOK {
+		t.Fatalf("Resume failed with %d", code)
+	}
+	if code := adminRequest(t, admin, http.MethodPost, "/check", &status); code != http.StatusOK {
+		t.Fatalf("Check failed with %d", code)
+	}
+	if status.Paused || status.Version != "v1.0.0" {
+		t.Errorf("Expected check after resume to deploy v1.0.0, got %+v", status)
+	}
+}
+
+func TestAdminRejectsConcurrentOperations(t *testing.T) {
+	d, admin := newAdminTestServer(t, &DeploymentState{ActiveSlot: "blue"})
+
+	d.opMu.Lock()
+	defer d.opMu.Unlock()
+
+	if code := adminRequest(t, admin, http.MethodPost, "/check", nil); code != http.StatusConflict {
+		t.Errorf("Expected 409 while another operation runs, got %d", code)
+	}
+	var status AdminStatus
+	if code := adminRequest(t, admin, http.MethodGet, "/status", &status); code != http.StatusOK || !status.Busy {
+		t.Errorf("Expected status to report busy, got %d %+v", code, status)
+	}
+}
+
+func TestAdminServerRequiresConfiguredToken(t *testing.T) {
+	d, _ := newAdminTestServer(t, &DeploymentState{ActiveSlot: "blue"})
+	d.config.Admin.Token = ""
+
+	if _, err := newTestDaemon(d).startAdminServer(context.Background()); err == nil {
+		t.Fatal("Expected admin API without a token to be refused")
+	}
+}
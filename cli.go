@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// selectApps returns the configuration of the named app, or of every app
+// if name is empty
+func (c *Config) selectApps(name string) ([]*Config, error) {
+	configs := c.AppConfigs()
+	if name == "" {
+		return configs, nil
+	}
+	for _, appConfig := range configs {
+		if appConfig.Name == name {
+			return []*Config{appConfig}, nil
+		}
+	}
+	if len(c.Apps) == 0 {
+		return nil, fmt.Errorf("unknown app %q: no apps are configured", name)
+	}
+	return nil, fmt.Errorf("unknown app %q", name)
+}
+
+// onlyApp narrows the apps in c to the named one, if name is set
+func (c *Config) onlyApp(name string) error {
+	if name == "" {
+		return nil
+	}
+	configs, err := c.selectApps(name)
+	if err != nil {
+		return err
+	}
+	c.Apps = []AppConfig{configs[0].AppConfig}
+	return nil
+}
+
+// statusCommand prints the deployed versions of every app, or of the one
+// named with --app, from their state files
+func statusCommand(config *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	app := fs.String("app", "", "Only show this app")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	configs, err := config.selectApps(*app)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for i, appConfig := range configs {
+		state, err := LoadState(appConfig.StateFile)
+		if err != nil {
+			return fmt.Errorf("failed to load state of %s: %w", appConfig.Repo, err)
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if appConfig.Name != "" {
+			fmt.Fprintf(w, "App:\t%s\n", appConfig.Name)
+		}
+		version := state.BlueVersion
+		if state.ActiveSlot == "green" {
+			version = state.GreenVersion
+		}
+		fmt.Fprintf(w, "Repo:\t%s\n", appConfig.Repo)
+		fmt.Fprintf(w, "Active slot:\t%s\n", state.ActiveSlot)
+		fmt.Fprintf(w, "Version:\t%s\n", orNone(version))
+		fmt.Fprintf(w, "Blue:\t%s\n", orNone(state.BlueVersion))
+		fmt.Fprintf(w, "Green:\t%s\n", orNone(state.GreenVersion))
+		fmt.Fprintf(w, "Paused:\t%t\n", state.Paused)
+		if frozen, reason := readFreeze(appConfig.FreezeFile); frozen {
+			fmt.Fprintf(w, "Frozen:\t%s\n", orNone(reason))
+		}
+		if rollout := appConfig.Rollout; len(rollout.Stages) > 0 || rollout.ScheduleAsset != "" {
+			if hostID, err := rolloutHostID(rollout.HostID); err == nil {
+				fmt.Fprintf(w, "Rollout bucket:\t%d of %d\n", hostBucket(hostID), rolloutBuckets)
+			}
+		}
+		if window := appConfig.DeployWindow; len(window.Allow)+len(window.BlackoutDates) > 0 {
+			fmt.Fprintf(w, "Deploy window:\t%s\n", describeWindow(window, time.Now()))
+		}
+		if n := len(state.History); n > 0 {
+			last := state.History[n-1]
+			outcome := "succeeded"
+			if !last.Success {
+				outcome = "failed: " + last.Error
+			}
+			fmt.Fprintf(w, "Last %s:\t%s at %s, %s\n", last.Action, last.Tag, last.Time.Local().Format(time.DateTime), outcome)
+		}
+	}
+	return w.Flush()
+}
+
+// describeWindow says whether the deploy window is open at now, and if not
+// when it opens
+func describeWindow(cfg DeployWindowConfig, now time.Time) string {
+	window, err := newDeployWindow(cfg)
+	switch {
+	case err != nil:
+		return err.Error()
+	case window.open(now):
+		return "open"
+	}
+	if opens, ok := window.nextOpen(now); ok {
+		return "closed until " + opens.Format(time.DateTime+" MST")
+	}
+	return "closed"
+}
+
+// freezeCommand creates the freeze file of every app, or the one named
+// with --app, for command "freeze", and removes it for "unfreeze". The
+// remaining arguments to freeze are recorded as the reason.
+func freezeCommand(config *Config, command string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	app := fs.String("app", "", "Only "+command+" this app")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	configs, err := config.selectApps(*app)
+	if err != nil {
+		return err
+	}
+	var reason []byte
+	if fs.NArg() > 0 {
+		reason = []byte(strings.Join(fs.Args(), " ") + "\n")
+	}
+
+	for _, appConfig := range configs {
+		name := appConfig.Name
+		if name == "" {
+			name = appConfig.Repo
+		}
+		if command == "unfreeze" {
+			if err := os.Remove(appConfig.FreezeFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			fmt.Fprintf(out, "Unfroze %s\n", name)
+			continue
+		}
+		if err := os.WriteFile(appConfig.FreezeFile, reason, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Froze %s until %s is removed\n", name, appConfig.FreezeFile)
+	}
+	return nil
+}
+
+// planCommand prints what deploying the latest release, or the one given
+// with --tag, would change for every app, or the one named with --app
+func planCommand(ctx context.Context, config *Config, args []string, out io.Writer, logger *slog.Logger) error {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	app := fs.String("app", "", "Only plan this app")
+	tag := fs.String("tag", "", "Plan this release instead of the latest")
+	asJSON := fs.Bool("json", false, "Print the plans as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	configs, err := config.selectApps(*app)
+	if err != nil {
+		return err
+	}
+	if *tag != "" && len(configs) > 1 {
+		return errors.New("--tag needs --app when several apps are configured")
+	}
+
+	github := NewGitHubClient(config.GitHubToken, logger)
+	plans := make([]*DeployPlan, 0, len(configs))
+	for _, appConfig := range configs {
+		// A dry-run deployer never saves state or starts anything
+		d, err := newDeployer(appConfig, logger, github, nil, true)
+		if err != nil {
+			return fmt.Errorf("failed to plan %s: %w", appConfig.Repo, err)
+		}
+		plan, err := d.Plan(ctx, *tag)
+		if err != nil {
+			return fmt.Errorf("failed to plan %s: %w", appConfig.Repo, err)
+		}
+		plans = append(plans, plan)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plans)
+	}
+	for i, plan := range plans {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		plan.Write(out)
+	}
+	return nil
+}
+
+// deployCommand deploys the release tag given with --tag straight away, as
+// POST /deploy on the admin API does: the deploy window, min_release_age
+// and rollout are skipped, but a freeze is not
+func deployCommand(ctx context.Context, config *Config, args []string, out io.Writer, github *GitHubClient, logger *slog.Logger, dryRun bool) error {
+	fs := flag.NewFlagSet("deploy", flag.ContinueOnError)
+	app := fs.String("app", "", "Deploy this app")
+	tag := fs.String("tag", "", "Release to deploy")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *tag == "" {
+		return errors.New("--tag is required")
+	}
+	configs, err := config.selectApps(*app)
+	if err != nil {
+		return err
+	}
+	if len(configs) > 1 {
+		return errors.New("--app is required when several apps are configured")
+	}
+	appConfig := configs[0]
+	if appConfig.Supervise.Enabled {
+		// The running deployer owns the supervised process
+		return errors.New("supervise is enabled, deploy through the admin API of the running deployer")
+	}
+
+	notifier, err := NewNotifier(config.Notifications, logger)
+	if err != nil {
+		return fmt.Errorf("invalid notification settings: %w", err)
+	}
+	defer notifier.Close()
+	d, err := newDeployer(appConfig, logger, github, notifier, dryRun)
+	if err != nil {
+		return err
+	}
+	if err := d.DeployTag(ctx, *tag); err != nil {
+		return err
+	}
+	if !dryRun {
+		fmt.Fprintf(out, "%s is deployed in the %s slot\n", d.getCurrentVersion(), d.state.ActiveSlot)
+	}
+	return nil
+}
+
+// errInvalidConfig is returned by validateConfigCommand once it has reported
+// the problems with a config file
+var errInvalidConfig = errors.New("invalid configuration")
+
+// validateConfigCommand checks the config files named in args, or the one
+// given with -config, and reports every problem found in each
+func validateConfigCommand(configPath string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{configPath}
+	}
+
+	// Overrides that name no setting are ignored rather than rejected
+	for _, name := range unknownEnvOverrides(os.Environ()) {
+		fmt.Fprintf(out, "warning: ignoring %s: %v\n", name, errUnknownSetting)
+	}
+
+	valid := true
+	for _, path := range paths {
+		_, err := LoadConfig(path)
+		if err == nil {
+			fmt.Fprintf(out, "%s: OK\n", path)
+			continue
+		}
+		valid = false
+		problems := []error{err}
+		var joined interface{ Unwrap() []error }
+		if errors.As(err, &joined) {
+			problems = joined.Unwrap()
+		}
+		for _, problem := range problems {
+			fmt.Fprintf(out, "%s: %v\n", path, problem)
+		}
+	}
+	if !valid {
+		return errInvalidConfig
+	}
+	return nil
+}
+
+// orNone shows an empty version as "none"
+func orNone(version string) string {
+	if version == "" {
+		return "none"
+	}
+	return version
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultPath is used when the deployer itself has no PATH to pass on
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// baseEnvAllow lists the variables every command inherits. Everything else
+// must be allowed explicitly with env_allow.
+var baseEnvAllow = []string{"PATH", "LANG", "LC_ALL", "TZ"}
+
+// secretEnvNames lists variables that carry deployer secrets and are only
+// passed to commands that set inherit_secrets, besides the DEPLOYER_
+// variables that set secret settings
+var secretEnvNames = []string{"GITHUB_TOKEN"}
+
+// merge returns e with unset fields taken from defaults. Env maps are merged
+// with e's entries taking precedence.
+func (e ExecConfig) merge(defaults ExecConfig) ExecConfig {
+	merged := e
+	if merged.User == "" {
+		merged.User = defaults.User
+	}
+	if merged.Group == "" {
+		merged.Group = defaults.Group
+	}
+	if len(defaults.Env) > 0 {
+		env := maps.Clone(defaults.Env)
+		maps.Copy(env, e.Env)
+		merged.Env = env
+	}
+	if len(merged.EnvAllow) == 0 {
+		merged.EnvAllow = defaults.EnvAllow
+	}
+	if merged.Umask == "" {
+		merged.Umask = defaults.Umask
+	}
+	if merged.WorkingDir == "" {
+		merged.WorkingDir = defaults.WorkingDir
+	}
+	merged.InheritSecrets = e.InheritSecrets || defaults.InheritSecrets
+	return merged
+}
+
+// envAllowed reports whether name matches an allow-list entry. Entries
+// ending in * match by prefix.
+func envAllowed(name string, allow []string) bool {
+	for _, pattern := range allow {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUmask parses an octal umask such as "027"
+func parseUmask(umask string) (uint64, error) {
+	mask, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || mask > 0o777 {
+		return 0, fmt.Errorf("invalid umask %q", umask)
+	}
+	return mask, nil
+}
+
+// commandEnv builds the environment for a command: the base and allowed
+// variables from the deployer's own environment with secrets removed, then
+// the configured env additions, then extra.
+func (d *Deployer) commandEnv(ec ExecConfig, account *user.User, extra []string) []string {
+	config := d.currentConfig()
+	token := config.GitHubToken
+
+	// Secrets are recognized by name, and by value whatever holds them
+	names := slices.Clone(secretEnvNames)
+	values := make(map[string]bool)
+	for _, secret := range config.secrets() {
+		names = append(names, secret.envName())
+	}
+	for _, value := range config.secretValues() {
+		values[value] = true
+	}
+	isSecret := func(name, value string) bool {
+		return envAllowed(name, names) || values[value]
+	}
+
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !envAllowed(name, baseEnvAllow) && !envAllowed(name, ec.EnvAllow) {
+			continue
+		}
+		if isSecret(name, value) && !ec.InheritSecrets {
+			continue
+		}
+		env[name] = value
+	}
+	if env["PATH"] == "" {
+		env["PATH"] = defaultPath
+	}
+
+	if account != nil {
+		env["HOME"] = account.HomeDir
+		env["USER"] = account.Username
+		env["LOGNAME"] = account.Username
+	} else {
+		for _, name := range []string{"HOME", "USER", "LOGNAME"} {
+			if value, ok := os.LookupEnv(name); ok {
+				env[name] = value
+			}
+		}
+	}
+
+	if ec.InheritSecrets && token != "" {
+		env["GITHUB_TOKEN"] = token
+	}
+	maps.Copy(env, ec.Env)
+	for _, kv := range extra {
+		name, value, _ := strings.Cut(kv, "=")
+		env[name] = value
+	}
+
+	result := make([]string, 0, len(env))
+	for name, value := range env {
+		result = append(result, name+"="+value)
+	}
+	return result
+}
+
+// buildCommand prepares `sh -c command` with the identity, umask, working
+// directory and environment described by ec. Relative working directories
+// are resolved against dir.
+func (d *Deployer) buildCommand(ctx context.Context, command string, ec ExecConfig, dir string, extraEnv []string) (*exec.Cmd, error) {
+	script := command
+	if ec.Umask != "" {
+		mask, err := parseUmask(ec.Umask)
+		if err != nil {
+			return nil, err
+		}
+		script = fmt.Sprintf("umask %04o\n%s", mask, command)
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", script)
+
+	switch {
+	case ec.WorkingDir == "":
+		cmd.Dir = dir
+	case filepath.IsAbs(ec.WorkingDir):
+		cmd.Dir = ec.WorkingDir
+	default:
+		cmd.Dir = filepath.Join(dir, ec.WorkingDir)
+	}
+
+	var account *user.User
+	if ec.User != "" || ec.Group != "" {
+		var err error
+		account, err = setCommandCredential(cmd, ec.User, ec.Group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cmd.Env = d.commandEnv(ec, account, extraEnv)
+	return cmd, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// envMap converts a KEY=value list into a map
+func envMap(env []string) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		m[name] = value
+	}
+	return m
+}
+
+func TestCommandEnvFiltersSecrets(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "ghp_secret")
+	t.Setenv("DEPLOY_API_KEY", "ghp_secret")
+	t.Setenv("UNRELATED_VAR", "x")
+	t.Setenv("POETRY_HOME", "/opt/poetry")
+
+	deployer := &Deployer{config: &Config{GitHubToken: "ghp_secret"}}
+	ec := ExecConfig{
+		EnvAllow: []string{"POETRY_*", "GITHUB_TOKEN", "DEPLOY_API_KEY"},
+		Env:      map[string]string{"APP_ENV": "production"},
+	}
+
+	env := envMap(deployer.commandEnv(ec, nil, []string{"DEPLOY_TAG=v1.0.0"}))
+
+	if _, ok := env["GITHUB_TOKEN"]; ok {
+		t.Error("GITHUB_TOKEN must not be inherited without inherit_secrets")
+	}
+	if _, ok := env["DEPLOY_API_KEY"]; ok {
+		t.Error("Variables holding the GitHub token must not be inherited without inherit_secrets")
+	}
+	if _, ok := env["UNRELATED_VAR"]; ok {
+		t.Error("Variables not in env_allow must not be inherited")
+	}
+	if env["POETRY_HOME"] != "/opt/poetry" {
+		t.Errorf("Expected POETRY_HOME via wildcard allow-list, got %q", env["POETRY_HOME"])
+	}
+	if env["PATH"] == "" {
+		t.Error("Expected PATH to be set")
+	}
+	if env["APP_ENV"] != "production" || env["DEPLOY_TAG"] != "v1.0.0" {
+		t.Errorf("Expected explicit and deployment variables, got %v", env)
+	}
+
+	ec.InheritSecrets = true
+	env = envMap(deployer.commandEnv(ec, nil, nil))
+	if env["GITHUB_TOKEN"] != "ghp_secret" {
+		t.Error("Expected GITHUB_TOKEN with inherit_secrets")
+	}
+}
+
+func TestCommandEnvFiltersEverySecretWithBroadAllowList(t *testing.T) {
+	t.Setenv("DEPLOYER_ADMIN_TOKEN", "admin-secret")
+	t.Setenv("DEPLOYER_GITHUB_WEBHOOK_SECRET", "hook-secret")
+	t.Setenv("DEPLOYER_NOTIFICATIONS_EMAIL_PASSWORD", "smtp-secret")
+	t.Setenv("DEPLOYER_LOGGING_LEVEL", "debug")
+	t.Setenv("APP_DB_PASSWORD", "smtp-secret")
+	t.Setenv("APP_SLACK_URL", "https://hooks.slack.com/services/T0/B0/xyz")
+	t.Setenv("APP_HOOK_AUTH", "Bearer hook-token")
+	t.Setenv("APP_MODE", "blue")
+
+	config := &Config{}
+	config.Admin.Token = "admin-secret"
+	config.GitHubWebhook.Secret = "hook-secret"
+	config.Notifications.Email.Password = "smtp-secret"
+	config.Notifications.Webhooks = []WebhookConfig{
+		{URL: "https://hooks.slack.com/services/T0/B0/xyz", Format: "slack"},
+		{URL: "https://example.com/hook", Headers: map[string]string{"Authorization": "Bearer hook-token"}},
+	}
+	deployer := &Deployer{config: config}
+
+	for _, allow := range [][]string{{"DEPLOYER_*", "APP_*"}, {"*"}} {
+		env := envMap(deployer.commandEnv(ExecConfig{EnvAllow: allow}, nil, nil))
+		for _, name := range []string{"DEPLOYER_ADMIN_TOKEN", "DEPLOYER_GITHUB_WEBHOOK_SECRET", "DEPLOYER_NOTIFICATIONS_EMAIL_PASSWORD", "APP_DB_PASSWORD", "APP_SLACK_URL", "APP_HOOK_AUTH"} {
+			if _, ok := env[name]; ok {
+				t.Errorf("env_allow %v: %s must not be inherited without inherit_secrets", allow, name)
+			}
+		}
+		if env["DEPLOYER_LOGGING_LEVEL"] != "debug" || env["APP_MODE"] != "blue" {
+			t.Errorf("env_allow %v: expected other allowed variables, got %v", allow, env)
+		}
+	}
+}
+
+func TestExecConfigMerge(t *testing.T) {
+	defaults := ExecConfig{
+		User:     "app",
+		Umask:    "0027",
+		Env:      map[string]string{"A": "default", "B": "default"},
+		EnvAllow: []string{"POETRY_*"},
+	}
+	hook := ExecConfig{User: "deploy", Env: map[string]string{"B": "hook"}}
+
+	merged := hook.merge(defaults)
+	if merged.User != "deploy" || merged.Umask != "0027" {
+		t.Errorf("Unexpected merged identity: %+v", merged)
+	}
+	if merged.Env["A"] != "default" || merged.Env["B"] != "hook" {
+		t.Errorf("Unexpected merged env: %v", merged.Env)
+	}
+	if len(merged.EnvAllow) != 1 {
+		t.Errorf("Expected env_allow from defaults, got %v", merged.EnvAllow)
+	}
+}
+
+func TestBuildCommandUmaskAndWorkingDir(t *testing.T) {
+	slotDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(slotDir, "app"), 0o755); err != nil {
+		t.Fatalf("Failed to create working dir: %v", err)
+	}
+
+	deployer := &Deployer{config: &Config{}}
+	ec := ExecConfig{Umask: "027", WorkingDir: "app"}
+	cmd, err := deployer.buildCommand(context.Background(), "umask; pwd", ec, slotDir, nil)
+	if err != nil {
+		t.Fatalf("Failed to build command: %v", err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	lines := strings.Fields(string(out))
+	if len(lines) != 2 || lines[0] != "0027" {
+		t.Errorf("Expected umask 0027, got %q", out)
+	}
+	if resolved, _ := filepath.EvalSymlinks(filepath.Join(slotDir, "app")); len(lines) == 2 && lines[1] != resolved {
+		t.Errorf("Expected working dir %s, got %s", resolved, lines[1])
+	}
+
+	if _, err := deployer.buildCommand(context.Background(), "true", ExecConfig{Umask: "999"}, slotDir, nil); err == nil {
+		t.Error("Expected error for invalid umask")
+	}
+}
+
+func TestBuildCommandAsUser(t *testing.T) {
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody user on this system")
+	}
+
+	deployer := &Deployer{config: &Config{}}
+	cmd, err := deployer.buildCommand(context.Background(), "id -u; echo $HOME", ExecConfig{User: "nobody"}, "/", nil)
+	if os.Getuid() != 0 {
+		if err == nil {
+			t.Error("Expected error switching user without root")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Failed to build command: %v", err)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 || fields[0] != nobody.Uid || fields[1] != nobody.HomeDir {
+		t.Errorf("Expected uid %s and home %s, got %q", nobody.Uid, nobody.HomeDir, out)
+	}
+}
@@ -1,39 +1,213 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 
 	"gopkg.in/yaml.v3"
 )
 
-// Config represents the application configuration
+// Config represents the application configuration. A single application
+// is configured at the top level; several are listed under apps, and share
+// the remaining settings.
 type Config struct {
-	Repo               string        `yaml:"repo"`
-	AssetSuffix        string        `yaml:"asset_suffix"`
-	CheckIntervalSecs  int           `yaml:"check_interval_seconds"`
-	InstallDir         string        `yaml:"install_dir"`
-	CurrentSymlink     string        `yaml:"current_symlink"`
-	RunCommand         string        `yaml:"run_command"`
-	PostDeployScript   string        `yaml:"post_deploy_script"`
-	StateFile          string        `yaml:"state_file"`
-	GitHubToken        string        `yaml:"github_token,omitempty"`
-	HealthCheckURL     string        `yaml:"health_check_url,omitempty"`
-	HealthCheckTimeout int           `yaml:"health_check_timeout"`
-	VerifyChecksums    bool          `yaml:"verify_checksums"`
-	Logging            LoggingConfig `yaml:"logging"`
+	AppConfig         `yaml:",inline"`
+	Apps              []AppConfig         `yaml:"apps,omitempty"`
+	CheckIntervalSecs int                 `yaml:"check_interval_seconds"`
+	GitHubToken       string              `yaml:"github_token,omitempty"`
+	GitHubTokenFile   string              `yaml:"github_token_file,omitempty"`
+	Admin             AdminConfig         `yaml:"admin"`
+	GitHubWebhook     GitHubWebhookConfig `yaml:"github_webhook"`
+	Metrics           MetricsConfig       `yaml:"metrics"`
+	Notifications     NotificationsConfig `yaml:"notifications"`
+	Logging           LoggingConfig       `yaml:"logging"`
+	WatchConfig       bool                `yaml:"watch_config"` // reload when the config file changes, as on SIGHUP
+}
+
+// AppConfig describes one deployed application: where its releases come
+// from and how they are installed
+type AppConfig struct {
+	Name               string             `yaml:"name,omitempty"` // required for each entry in apps
+	Repo               string             `yaml:"repo"`
+	AssetSuffix        string             `yaml:"asset_suffix"`
+	InstallDir         string             `yaml:"install_dir"`
+	CurrentSymlink     string             `yaml:"current_symlink"`
+	RunCommand         string             `yaml:"run_command"`
+	PostDeployScript   string             `yaml:"post_deploy_script"`
+	StateFile          string             `yaml:"state_file"`
+	HealthCheckURL     string             `yaml:"health_check_url,omitempty"`
+	HealthCheckTimeout int                `yaml:"health_check_timeout"`
+	VerifyChecksums    bool               `yaml:"verify_checksums"`
+	SharedDir          string             `yaml:"shared_dir,omitempty"`
+	SharedPaths        []SharedPath       `yaml:"shared_paths,omitempty"`
+	Hooks              HooksConfig        `yaml:"hooks"`
+	HookDefaults       ExecConfig         `yaml:"hook_defaults"`
+	Supervise          SuperviseConfig    `yaml:"supervise"`
+	Restart            RestartConfig      `yaml:"restart"`
+	DeployWindow       DeployWindowConfig `yaml:"deploy_window"`
+	FreezeFile         string             `yaml:"freeze_file"`     // while it exists, automatic deployments only stage releases
+	MinReleaseAge      string             `yaml:"min_release_age"` // e.g. "24h"; younger releases are not deployed automatically
+	Rollout            RolloutConfig      `yaml:"rollout"`
+}
+
+// SharedPath is a persistent file or directory from shared_dir that is
+// linked or copied into each new slot
+type SharedPath struct {
+	Path     string `yaml:"path"`
+	Type     string `yaml:"type,omitempty"` // "file" or "dir"; a trailing slash on path implies "dir"
+	Mode     string `yaml:"mode,omitempty"` // "symlink" (default) or "copy"
+	Required bool   `yaml:"required,omitempty"`
+}
+
+// HooksConfig holds the commands run at each deployment lifecycle phase
+type HooksConfig struct {
+	PreDownload  HookConfig `yaml:"pre_download"`
+	PostExtract  HookConfig `yaml:"post_extract"`
+	Install      HookConfig `yaml:"install"` // its command defaults to run_command
+	PreSwitch    HookConfig `yaml:"pre_switch"`
+	PostSwitch   HookConfig `yaml:"post_switch"`
+	PostDeploy   HookConfig `yaml:"post_deploy"` // its command defaults to post_deploy_script
+	OnFailure    HookConfig `yaml:"on_failure"`
+	PostRollback HookConfig `yaml:"post_rollback"`
+}
+
+// HookConfig describes a single lifecycle hook
+type HookConfig struct {
+	Command     string `yaml:"command"`
+	TimeoutSecs int    `yaml:"timeout_seconds"`
+	ExecConfig  `yaml:",inline"`
+}
+
+// SuperviseConfig describes the application gh-deployer runs from the
+// current symlink when supervise mode is enabled
+type SuperviseConfig struct {
+	Enabled               bool   `yaml:"enabled"`
+	Command               string `yaml:"command"`
+	StopTimeoutSecs       int    `yaml:"stop_timeout_seconds"`
+	RestartBackoffSecs    int    `yaml:"restart_backoff_seconds"`
+	MaxRestartBackoffSecs int    `yaml:"max_restart_backoff_seconds"`
+	ExecConfig            `yaml:",inline"`
+}
+
+// DeployWindowConfig limits when automatic deployments may switch slots.
+// Outside the window, new releases are staged and switched to once it opens.
+type DeployWindowConfig struct {
+	Timezone      string   `yaml:"timezone"`       // IANA name; the local timezone if empty
+	Allow         []string `yaml:"allow"`          // cron expressions of allowed minutes; any time if empty
+	BlackoutDates []string `yaml:"blackout_dates"` // dates or inclusive date ranges like 2024-12-24..2024-12-26
+}
+
+// RolloutConfig spreads automatic deployments of each release over the
+// fleet: every host falls in a stable bucket from 0 to 99, and deploys once
+// the release is as old as the first stage covering its bucket requires
+type RolloutConfig struct {
+	Stages        []RolloutStage `yaml:"stages"`
+	ScheduleAsset string         `yaml:"schedule_asset"` // release asset whose stages replace these for that release
+	HostID        string         `yaml:"host_id"`        // the machine ID or hostname if empty
+}
+
+// RolloutStage lets the first percent of buckets deploy a release once it
+// has been published for after
+type RolloutStage struct {
+	Percent int    `yaml:"percent"`
+	After   string `yaml:"after"` // e.g. "6h"
+}
+
+// RestartConfig describes services restarted after every slot switch
+type RestartConfig struct {
+	Mode              string   `yaml:"mode"`    // "" (none) or "systemd"
+	Backend           string   `yaml:"backend"` // "auto" (default), "dbus" or "systemctl"
+	Units             []string `yaml:"units"`
+	ReloadUnits       []string `yaml:"reload_units"`
+	TimeoutSecs       int      `yaml:"timeout_seconds"`
+	RollbackOnFailure bool     `yaml:"rollback_on_failure"`
+	Systemctl         string   `yaml:"systemctl"`
+}
+
+// AdminConfig describes the local admin HTTP API
+type AdminConfig struct {
+	Listen    string `yaml:"listen"`     // "host:port" or "unix:/path/to.sock"; empty disables the API
+	Token     string `yaml:"token"`      // bearer token required on every request
+	TokenFile string `yaml:"token_file"` // file holding token
+}
+
+// GitHubWebhookConfig describes the receiver for GitHub release webhooks
+type GitHubWebhookConfig struct {
+	Listen     string   `yaml:"listen"`      // "host:port" or "unix:/path"; empty disables the receiver
+	Path       string   `yaml:"path"`        // default "/webhook"
+	Secret     string   `yaml:"secret"`      // the webhook secret configured on GitHub
+	SecretFile string   `yaml:"secret_file"` // file holding secret
+	Actions    []string `yaml:"actions"`     // release actions that trigger a check; default published and released
+}
+
+// MetricsConfig describes where Prometheus metrics are published
+type MetricsConfig struct {
+	Listen   string `yaml:"listen"`   // "host:port" or "unix:/path" serving /metrics; empty disables it
+	Textfile string `yaml:"textfile"` // node_exporter textfile collector path, e.g. /var/lib/node_exporter/gh_deployer.prom
+}
+
+// NotificationsConfig describes where deployment notifications are sent
+type NotificationsConfig struct {
+	Events        []string          `yaml:"events"`             // events to send; default all
+	Templates     map[string]string `yaml:"templates"`          // message template per event
+	Attempts      int               `yaml:"attempts"`           // tries per notification, default 3
+	RateLimitSecs int               `yaml:"rate_limit_seconds"` // repeat window per event and tag, default 3600; negative disables
+	Webhooks      []WebhookConfig   `yaml:"webhooks"`
+	Email         EmailConfig       `yaml:"email"`
+}
+
+// WebhookConfig describes a webhook notification destination
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Format  string            `yaml:"format"` // "json" (default), "slack" or "teams"
+	Events  []string          `yaml:"events"` // overrides notifications.events for this webhook
+	Headers map[string]string `yaml:"headers"`
+}
+
+// EmailConfig describes email notifications sent through an SMTP relay
+type EmailConfig struct {
+	Host         string   `yaml:"host"` // empty disables email
+	Port         int      `yaml:"port"` // default 587, or 465 with tls: "tls"
+	TLS          string   `yaml:"tls"`  // "starttls" (default), "tls" or "none"
+	Username     string   `yaml:"username"`
+	Password     string   `yaml:"password"`
+	PasswordFile string   `yaml:"password_file"` // file holding password
+	From         string   `yaml:"from"`
+	To           []string `yaml:"to"`
+	Subject      string   `yaml:"subject"` // template; see defaultEmailSubject
+	Body         string   `yaml:"body"`    // template; see defaultEmailBody
+	Events       []string `yaml:"events"`  // overrides notifications.events for email
+}
+
+// ExecConfig controls the identity and environment a command runs with.
+// Unset fields fall back to hook_defaults.
+type ExecConfig struct {
+	User           string            `yaml:"user,omitempty"`
+	Group          string            `yaml:"group,omitempty"`
+	Env            map[string]string `yaml:"env,omitempty"`
+	EnvAllow       []string          `yaml:"env_allow,omitempty"`
+	Umask          string            `yaml:"umask,omitempty"`
+	WorkingDir     string            `yaml:"working_dir,omitempty"`
+	InheritSecrets bool              `yaml:"inherit_secrets,omitempty"`
 }
 
 // LoggingConfig represents logging configuration
 type LoggingConfig struct {
-	Level      string `yaml:"level"`
+	Level      string `yaml:"level"`  // debug, info, warn or error
+	Format     string `yaml:"format"` // text (default) or json
 	File       string `yaml:"file"`
-	MaxSize    string `yaml:"max_size"`
-	MaxBackups int    `yaml:"max_backups"`
-	MaxAge     int    `yaml:"max_age"`
+	MaxSize    string `yaml:"max_size"`    // rotate once the file reaches this size, e.g. "100MB"
+	MaxBackups int    `yaml:"max_backups"` // rotated files to keep; 0 keeps all
+	MaxAge     int    `yaml:"max_age"`     // days to keep rotated files; 0 keeps them forever
+	Compress   bool   `yaml:"compress"`    // gzip rotated files
 }
 
-// LoadConfig loads configuration from the specified file
+// LoadConfig loads configuration from the specified file. ${VAR} references
+// in values are expanded from the environment, DEPLOYER_ variables override
+// settings, and *_file settings are read from their files.
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -42,45 +216,117 @@ func LoadConfig(path string) (*Config, error) {
 
 	config := &Config{
 		// Set defaults
-		CheckIntervalSecs:  300,
-		HealthCheckTimeout: 30,
+		CheckIntervalSecs: 300,
+		AppConfig: AppConfig{
+			HealthCheckTimeout: 30,
+		},
 		Logging: LoggingConfig{
 			Level: "info",
 		},
 	}
 
-	if err := yaml.Unmarshal(data, config); err != nil {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	if err := expandEnvNode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to expand config file: %w", err)
+	}
+
+	// Collect every problem rather than stopping at the first, so they can
+	// all be fixed in one go
+	var problems []error
+	if doc.Kind != 0 {
+		problems = append(problems, checkKnownFields(&doc, reflect.TypeOf(config), "")...)
+		var typeErr *yaml.TypeError
+		if err := doc.Decode(config); errors.As(err, &typeErr) {
+			for _, msg := range typeErr.Errors {
+				problems = append(problems, errors.New(msg))
+			}
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
 
 	// Override with environment variables if present
+	if err := applyEnvOverrides(config, os.Environ()); err != nil {
+		problems = append(problems, fmt.Errorf("invalid environment override: %w", err))
+	}
+	if err := config.loadSecretFiles(filepath.Dir(path)); err != nil {
+		problems = append(problems, err)
+	}
 	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
 		config.GitHubToken = token
 	}
 	if os.Getenv("VERIFY_CHECKSUMS") == "true" {
 		config.VerifyChecksums = true
+		for i := range config.Apps {
+			config.Apps[i].VerifyChecksums = true
+		}
+	}
+
+	config.setDefaults()
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return config, nil
+}
+
+// setDefaults fills in the settings that default to values derived from others
+func (c *Config) setDefaults() {
+	if len(c.Apps) == 0 {
+		c.AppConfig.setDefaults()
+	}
+	for i := range c.Apps {
+		c.Apps[i].setDefaults()
 	}
+}
 
-	// Validate required fields and set intelligent defaults
-	if config.StateFile == "" {
+// setDefaults fills in the app's derived defaults
+func (a *AppConfig) setDefaults() {
+	if a.HealthCheckTimeout == 0 {
+		a.HealthCheckTimeout = 30
+	}
+	if a.FreezeFile == "" && a.InstallDir != "" {
+		a.FreezeFile = filepath.Join(a.InstallDir, "freeze")
+	}
+	if a.StateFile == "" {
 		// Default to state.yaml in the install directory
-		if config.InstallDir != "" {
-			config.StateFile = config.InstallDir + "/state.yaml"
+		if a.InstallDir != "" {
+			a.StateFile = a.InstallDir + "/state.yaml"
 		} else {
-			config.StateFile = "./state.yaml"
+			a.StateFile = "./state.yaml"
 		}
 	}
+}
 
-	// Validate required configuration
-	if config.Repo == "" {
-		return nil, fmt.Errorf("repo is required in configuration")
-	}
-	if config.InstallDir == "" {
-		return nil, fmt.Errorf("install_dir is required in configuration")
+// validAppName reports whether name can identify an app on the command
+// line, in the admin API and in metric labels
+func validAppName(name string) bool {
+	if name == "" {
+		return false
 	}
-	if config.CurrentSymlink == "" {
-		return nil, fmt.Errorf("current_symlink is required in configuration")
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
+		}
 	}
+	return true
+}
 
-	return config, nil
+// AppConfigs returns one configuration per application, combining the
+// app's own settings with the shared ones
+func (c *Config) AppConfigs() []*Config {
+	if len(c.Apps) == 0 {
+		return []*Config{c}
+	}
+	configs := make([]*Config, 0, len(c.Apps))
+	for _, app := range c.Apps {
+		appConfig := *c
+		appConfig.AppConfig = app
+		appConfig.Apps = nil
+		configs = append(configs, &appConfig)
+	}
+	return configs
 }
@@ -122,3 +122,59 @@ current_symlink: "/tmp/current"
 		t.Errorf("Expected GitHub token from environment, got '%s'", config.GitHubToken)
 	}
 }
+
+func TestLoadConfigApps(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "apps.yaml")
+	configContent := `check_interval_seconds: 120
+apps:
+  - name: web
+    repo: "test/web"
+    install_dir: "/srv/web"
+    current_symlink: "/srv/web/current"
+  - name: worker
+    repo: "test/worker"
+    install_dir: "/srv/worker"
+    current_symlink: "/srv/worker/current"
+    health_check_timeout: 5
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0o644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	apps := config.AppConfigs()
+	if len(apps) != 2 {
+		t.Fatalf("Expected 2 apps, got %d", len(apps))
+	}
+	if apps[0].Name != "web" || apps[0].StateFile != "/srv/web/state.yaml" || apps[0].HealthCheckTimeout != 30 {
+		t.Errorf("Unexpected web app config: %+v", apps[0].AppConfig)
+	}
+	if apps[1].HealthCheckTimeout != 5 || apps[1].CheckIntervalSecs != 120 {
+		t.Errorf("Expected worker to keep its own and the shared settings, got %+v", apps[1])
+	}
+}
+
+func TestLoadConfigRejectsInvalidApps(t *testing.T) {
+	app := func(name, dir string) string {
+		return "  - name: " + name + "\n    repo: test/" + name + "\n    install_dir: " + dir + "\n    current_symlink: " + dir + "/current\n"
+	}
+	for name, content := range map[string]string{
+		"missing name":       "apps:\n" + app(`""`, "/srv/a"),
+		"invalid name":       "apps:\n" + app("my app", "/srv/a"),
+		"duplicate name":     "apps:\n" + app("a", "/srv/a") + app("a", "/srv/b"),
+		"shared install_dir": "apps:\n" + app("a", "/srv/a") + app("b", "/srv/a/"),
+		"missing repo":       "apps:\n  - name: a\n    install_dir: /srv/a\n    current_symlink: /srv/a/current\n",
+		"top-level app":      "repo: test/a\napps:\n" + app("b", "/srv/b"),
+	} {
+		configPath := filepath.Join(t.TempDir(), "apps.yaml")
+		if err := os.WriteFile(configPath, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+		if _, err := LoadConfig(configPath); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
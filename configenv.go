@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envOverridePrefix starts the environment variables that override settings
+const envOverridePrefix = "DEPLOYER_"
+
+// envReference matches ${VAR} and ${VAR:-default}, and their $${ escapes
+var envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+// errUnknownSetting is returned for an override that names no setting
+var errUnknownSetting = errors.New("unknown setting")
+
+// expandEnv replaces ${VAR} in s with the value of the environment variable
+// VAR, and ${VAR:-default} with default if VAR is unset or empty. A
+// reference to an unset variable without a default is an error, so that a
+// missing secret is not silently replaced by nothing. $${ stands for a
+// literal ${, e.g. for shell variables in hook commands.
+func expandEnv(s string) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		m := envReference.FindStringSubmatch(ref)
+		if value := os.Getenv(m[1]); value != "" {
+			return value
+		}
+		if def, ok := strings.CutPrefix(m[2], ":-"); ok {
+			return def
+		}
+		if _, set := os.LookupEnv(m[1]); !set && err == nil {
+			err = fmt.Errorf("environment variable %s is not set (write $${%s} for a literal reference)", m[1], m[1])
+		}
+		return ""
+	})
+	return expanded, err
+}
+
+// expandEnvNode expands environment references in every value in a parsed
+// config. Keys are left alone.
+func expandEnvNode(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		value, err := expandEnv(n.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		if value != n.Value {
+			n.Value = value
+			// Let a plain value such as ${INTERVAL:-300} resolve to the
+			// type of what it expanded to
+			if n.Style == 0 {
+				n.Tag = ""
+			}
+		}
+		return nil
+	}
+	for i, child := range n.Content {
+		if n.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if err := expandEnvNode(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnvOverrides sets the settings named by DEPLOYER_ variables in
+// environ. The rest of the variable's name is the setting's path, upper-cased
+// and joined with underscores: DEPLOYER_LOGGING_LEVEL sets logging.level and
+// DEPLOYER_APPS_0_REPO the repo of the first app. Values other than strings
+// are parsed as YAML, e.g. DEPLOYER_NOTIFICATIONS_EVENTS='[rollback]'.
+// Variables that name no setting are ignored, so that one meant for
+// something else cannot stop the deployer; see unknownEnvOverrides.
+func applyEnvOverrides(config *Config, environ []string) error {
+	environ = slices.Clone(environ)
+	slices.Sort(environ)
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		path, ok := strings.CutPrefix(name, envOverridePrefix)
+		if !ok {
+			continue
+		}
+		err := setEnvOverride(reflect.ValueOf(config).Elem(), path, value)
+		if err != nil && !errors.Is(err, errUnknownSetting) {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// unknownEnvOverrides returns the DEPLOYER_ variables in environ that name
+// no setting, which applyEnvOverrides ignores
+func unknownEnvOverrides(environ []string) []string {
+	var unknown []string
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		path, ok := strings.CutPrefix(name, envOverridePrefix)
+		if !ok {
+			continue
+		}
+		if err := setEnvOverride(reflect.ValueOf(&Config{}).Elem(), path, value); errors.Is(err, errUnknownSetting) {
+			unknown = append(unknown, name)
+		}
+	}
+	slices.Sort(unknown)
+	return unknown
+}
+
+// setEnvOverride sets the setting at path within v to value
+func setEnvOverride(v reflect.Value, path, value string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if !field.IsExported() || key == "-" {
+				continue
+			}
+			var err error
+			if slices.Contains(strings.Split(opts, ","), "inline") {
+				err = setEnvOverride(v.Field(i), path, value)
+			} else if key = strings.ToUpper(key); path == key {
+				err = setEnvValue(v.Field(i), value)
+			} else if rest, ok := strings.CutPrefix(path, key+"_"); ok {
+				err = setEnvOverride(v.Field(i), rest, value)
+			} else {
+				continue
+			}
+			// A longer key may share this one's prefix, e.g. GITHUB_TOKEN_FILE
+			if !errors.Is(err, errUnknownSetting) {
+				return err
+			}
+		}
+	case reflect.Slice:
+		index, rest, _ := strings.Cut(path, "_")
+		i, err := strconv.Atoi(index)
+		if err != nil || rest == "" || v.Type().Elem().Kind() != reflect.Struct {
+			return errUnknownSetting
+		}
+		if i < 0 || i >= v.Len() {
+			return fmt.Errorf("index %d is out of range, %d entries are configured", i, v.Len())
+		}
+		return setEnvOverride(v.Index(i), rest, value)
+	}
+	return errUnknownSetting
+}
+
+// setEnvValue sets a single setting from an environment variable
+func setEnvValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.String {
+		v.SetString(value)
+		return nil
+	}
+	if err := yaml.Unmarshal([]byte(value), v.Addr().Interface()); err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	return nil
+}
+
+// configSecret is a secret setting and the setting naming a file to read
+// it from
+type configSecret struct {
+	name  string
+	value *string
+	file  string
+}
+
+// secrets lists the secret settings
+func (c *Config) secrets() []configSecret {
+	return []configSecret{
+		{"github_token", &c.GitHubToken, c.GitHubTokenFile},
+		{"admin.token", &c.Admin.Token, c.Admin.TokenFile},
+		{"github_webhook.secret", &c.GitHubWebhook.Secret, c.GitHubWebhook.SecretFile},
+		{"notifications.email.password", &c.Notifications.Email.Password, c.Notifications.Email.PasswordFile},
+	}
+}
+
+// secretValues returns the values of the secret settings and of the other
+// settings that carry credentials: webhook URLs, which for Slack and Teams
+// are the credential, and webhook header values
+func (c *Config) secretValues() []string {
+	var values []string
+	for _, secret := range c.secrets() {
+		values = append(values, *secret.value)
+	}
+	for _, hook := range c.Notifications.Webhooks {
+		values = append(values, hook.URL)
+		for _, value := range hook.Headers {
+			values = append(values, value)
+		}
+	}
+	return slices.DeleteFunc(values, func(v string) bool { return v == "" })
+}
+
+// envName returns the DEPLOYER_ variable that overrides the secret
+func (s configSecret) envName() string {
+	return envOverridePrefix + strings.ToUpper(strings.ReplaceAll(s.name, ".", "_"))
+}
+
+// loadSecretFiles reads the secrets configured with *_file settings
+func (c *Config) loadSecretFiles(configDir string) error {
+	for _, secret := range c.secrets() {
+		if secret.file == "" {
+			continue
+		}
+		if *secret.value != "" {
+			return fmt.Errorf("set only one of %s and %s_file", secret.name, secret.name)
+		}
+		value, err := readSecretFile(secret.file, configDir)
+		if err != nil {
+			return fmt.Errorf("%s_file: %w", secret.name, err)
+		}
+		*secret.value = value
+	}
+	return nil
+}
+
+// readSecretFile reads a secret from path. A relative path is looked up in
+// $CREDENTIALS_DIRECTORY when systemd passes credentials to the service,
+// and in configDir otherwise. Trailing newlines are dropped.
+func readSecretFile(path, configDir string) (string, error) {
+	if !filepath.IsAbs(path) {
+		dir := configDir
+		if credentials := os.Getenv("CREDENTIALS_DIRECTORY"); credentials != "" {
+			dir = credentials
+		}
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
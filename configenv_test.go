@@ -0,0 +1,213 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes content to a config file in a new directory
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	return path
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("DEPLOY_HOST", "pi-01")
+	t.Setenv("EMPTY", "")
+
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"/srv/${DEPLOY_HOST}/current", "/srv/pi-01/current"},
+		{"${UNSET_VAR:-fallback}", "fallback"},
+		{"${EMPTY:-fallback}", "fallback"},
+		{"${EMPTY}", ""},
+		{"${DEPLOY_HOST:-fallback}", "pi-01"},
+		{"${UNSET_VAR:-}", ""},
+		{"echo $${DEPLOY_TAG} $DEPLOY_SLOT", "echo ${DEPLOY_TAG} $DEPLOY_SLOT"},
+		{"price: $5 {x}", "price: $5 {x}"},
+	} {
+		got, err := expandEnv(tc.in)
+		if err != nil {
+			t.Errorf("expandEnv(%q) failed: %v", tc.in, err)
+		} else if got != tc.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	if _, err := expandEnv("token ${UNSET_VAR}"); err == nil || !strings.Contains(err.Error(), "UNSET_VAR") {
+		t.Errorf("Expected an error naming the unset variable, got %v", err)
+	}
+}
+
+func TestLoadConfigExpandsEnv(t *testing.T) {
+	t.Setenv("APP_NAME", "myapp")
+	t.Setenv("INTERVAL", "45")
+	path := writeConfig(t, `repo: "acme/${APP_NAME}"
+asset_suffix: .tar.gz
+install_dir: /opt/${APP_NAME}/deployments
+current_symlink: /opt/${APP_NAME}/current
+check_interval_seconds: ${INTERVAL}
+health_check_timeout: ${HEALTH_TIMEOUT:-10}
+verify_checksums: ${VERIFY:-true}
+hooks:
+  post_switch:
+    command: 'echo "$${DEPLOY_TAG}"'
+`)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.Repo != "acme/myapp" || config.InstallDir != "/opt/myapp/deployments" {
+		t.Errorf("Expected expanded paths, got repo %q and install_dir %q", config.Repo, config.InstallDir)
+	}
+	if config.CheckIntervalSecs != 45 || config.HealthCheckTimeout != 10 || !config.VerifyChecksums {
+		t.Errorf("Expected expanded numbers and booleans, got %d, %d and %t", config.CheckIntervalSecs, config.HealthCheckTimeout, config.VerifyChecksums)
+	}
+	if config.Hooks.PostSwitch.Command != `echo "${DEPLOY_TAG}"` {
+		t.Errorf("Expected the escaped reference to be kept, got %q", config.Hooks.PostSwitch.Command)
+	}
+
+	path = writeConfig(t, "repo: test/repo\ninstall_dir: /tmp/test\ncurrent_symlink: /tmp/current\ngithub_token: ${MISSING_TOKEN}\n")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("Expected an error locating the unset variable, got %v", err)
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	config := &Config{
+		Apps: []AppConfig{{Name: "web"}, {Name: "worker"}},
+	}
+	environ := []string{
+		"PATH=/usr/bin",
+		"DEPLOYER_CHECK_INTERVAL_SECONDS=90",
+		"DEPLOYER_GITHUB_TOKEN=token",
+		"DEPLOYER_GITHUB_TOKEN_FILE=token-file",
+		"DEPLOYER_LOGGING_MAX_SIZE=10MB",
+		"DEPLOYER_ADMIN_LISTEN=unix:/run/deployer.sock",
+		"DEPLOYER_HEALTH_CHECK_URL=http://localhost/health",
+		"DEPLOYER_HOOKS_PRE_SWITCH_COMMAND=./smoke-test.sh",
+		"DEPLOYER_HOOKS_PRE_SWITCH_USER=app",
+		"DEPLOYER_APPS_1_REPO=acme/worker",
+		"DEPLOYER_NOTIFICATIONS_EVENTS=[rollback, deploy_failure]",
+		"DEPLOYER_SUPERVISE_ENABLED=true",
+	}
+	if err := applyEnvOverrides(config, environ); err != nil {
+		t.Fatalf("applyEnvOverrides failed: %v", err)
+	}
+
+	if config.CheckIntervalSecs != 90 || config.GitHubToken != "token" || config.GitHubTokenFile != "token-file" {
+		t.Errorf("Unexpected top-level settings: %d, %q, %q", config.CheckIntervalSecs, config.GitHubToken, config.GitHubTokenFile)
+	}
+	if config.Logging.MaxSize != "10MB" || config.Admin.Listen != "unix:/run/deployer.sock" {
+		t.Errorf("Unexpected nested settings: %+v, %+v", config.Logging, config.Admin)
+	}
+	if config.HealthCheckURL != "http://localhost/health" || !config.Supervise.Enabled {
+		t.Errorf("Expected inline app settings to be overridden, got %+v", config.AppConfig)
+	}
+	if hook := config.Hooks.PreSwitch; hook.Command != "./smoke-test.sh" || hook.User != "app" {
+		t.Errorf("Unexpected hook: %+v", hook)
+	}
+	if config.Apps[1].Repo != "acme/worker" || config.Apps[0].Repo != "" {
+		t.Errorf("Expected only the second app's repo to be set, got %+v", config.Apps)
+	}
+	if !slices.Equal(config.Notifications.Events, []string{"rollback", "deploy_failure"}) {
+		t.Errorf("Unexpected events: %v", config.Notifications.Events)
+	}
+
+	// A variable that names no setting is ignored, but can be listed
+	unknown := []string{"DEPLOYER_CHECK_INTERVAL_SECONDS=90", "DEPLOYER_CHECK_INTERVAL_SECOND=60", "DEPLOYER_HOME=/opt/deployer"}
+	if err := applyEnvOverrides(config, unknown); err != nil || config.CheckIntervalSecs != 90 {
+		t.Errorf("Expected unknown settings to be ignored, got %v and interval %d", err, config.CheckIntervalSecs)
+	}
+	if got := unknownEnvOverrides(unknown); !slices.Equal(got, []string{"DEPLOYER_CHECK_INTERVAL_SECOND", "DEPLOYER_HOME"}) {
+		t.Errorf("Unexpected unknown overrides: %v", got)
+	}
+
+	for _, bad := range []string{
+		"DEPLOYER_CHECK_INTERVAL_SECONDS=often",
+		"DEPLOYER_APPS_2_REPO=acme/other",
+		"DEPLOYER_LOGGING=debug",
+	} {
+		if err := applyEnvOverrides(&Config{}, []string{bad}); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestLoadConfigEnvOverridesFile(t *testing.T) {
+	t.Setenv("DEPLOYER_LOGGING_LEVEL", "debug")
+	t.Setenv("DEPLOYER_REPO", "acme/override")
+	path := writeConfig(t, "repo: test/repo\ninstall_dir: /tmp/test\ncurrent_symlink: /tmp/current\nlogging:\n  level: warn\n")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.Logging.Level != "debug" || config.Repo != "acme/override" {
+		t.Errorf("Expected environment overrides to win, got level %q and repo %q", config.Logging.Level, config.Repo)
+	}
+}
+
+func TestLoadConfigReadsSecretFiles(t *testing.T) {
+	credentials := t.TempDir()
+	t.Setenv("CREDENTIALS_DIRECTORY", credentials)
+	t.Setenv("GITHUB_TOKEN", "")
+	for name, content := range map[string]string{"github-token": "ghp_secret\n", "webhook-secret": "hook-secret"} {
+		if err := os.WriteFile(filepath.Join(credentials, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	password := filepath.Join(t.TempDir(), "smtp-password")
+	if err := os.WriteFile(password, []byte("hunter2\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfig(t, `repo: test/repo
+install_dir: /tmp/test
+current_symlink: /tmp/current
+github_token_file: github-token
+github_webhook:
+  secret_file: webhook-secret
+notifications:
+  email:
+    password_file: `+password+`
+`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.GitHubToken != "ghp_secret" || config.GitHubWebhook.Secret != "hook-secret" || config.Notifications.Email.Password != "hunter2" {
+		t.Errorf("Unexpected secrets: %q, %q, %q", config.GitHubToken, config.GitHubWebhook.Secret, config.Notifications.Email.Password)
+	}
+
+	path = writeConfig(t, "repo: test/repo\ninstall_dir: /tmp/test\ncurrent_symlink: /tmp/current\nadmin:\n  token: inline\n  token_file: github-token\n")
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("Expected an error when both admin.token and admin.token_file are set")
+	}
+
+	path = writeConfig(t, "repo: test/repo\ninstall_dir: /tmp/test\ncurrent_symlink: /tmp/current\ngithub_token_file: missing\n")
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("Expected an error for a missing secret file")
+	}
+}
+
+func TestReadSecretFileRelativeToConfig(t *testing.T) {
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("from-config-dir\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := readSecretFile("token", dir)
+	if err != nil || secret != "from-config-dir" {
+		t.Errorf("Expected the secret next to the config, got %q, %v", secret, err)
+	}
+}
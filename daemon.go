@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Daemon runs the deployers of every configured app in one process. The
+// apps share the GitHub client and its rate limit, the notifier, the admin,
+// metrics and webhook endpoints and systemd notifications, but each checks
+// and deploys on its own, so one app's failures don't hold up the others.
+type Daemon struct {
+	config   *Config
+	logger   *slog.Logger
+	apps     []*Deployer
+	github   *GitHubClient
+	notifier *Notifier
+	sdNotify *sdNotifier
+
+	// textfileMu orders writes of the metrics textfile
+	textfileMu sync.Mutex
+
+	// reloadMu serializes config reloads and guards notifier, which they replace
+	reloadMu sync.Mutex
+}
+
+// errAppRequired is returned when several apps are configured and a
+// request does not say which one it is for
+var errAppRequired = errors.New("several apps are configured, name one with app")
+
+// NewDaemon creates the deployers for every app in config. An app that
+// cannot be set up is logged and left out, so the others still run; it is
+// an error only if no app can be set up.
+func NewDaemon(config *Config, logger *slog.Logger, dryRun bool) (*Daemon, error) {
+	github := NewGitHubClient(config.GitHubToken, logger)
+	notifier, err := NewNotifier(config.Notifications, logger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification settings: %w", err)
+	}
+
+	var apps []*Deployer
+	var errs []error
+	for _, appConfig := range config.AppConfigs() {
+		d, err := newDeployer(appConfig, logger, github, notifier, dryRun)
+		if err != nil {
+			if appConfig.Name == "" {
+				return nil, err
+			}
+			logger.Error("Failed to set up app, leaving it out", "app", appConfig.Name, "error", err)
+			errs = append(errs, fmt.Errorf("app %s: %w", appConfig.Name, err))
+			continue
+		}
+		apps = append(apps, d)
+	}
+	if len(apps) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return newDaemon(config, logger, apps), nil
+}
+
+// newDaemon runs apps, which must share one GitHub client and notifier
+func newDaemon(config *Config, logger *slog.Logger, apps []*Deployer) *Daemon {
+	dm := &Daemon{
+		config:   config,
+		logger:   logger,
+		apps:     apps,
+		github:   apps[0].github,
+		notifier: apps[0].notifier,
+		sdNotify: newSDNotifier(),
+	}
+	for _, d := range apps {
+		d.sdNotify = dm.sdNotify
+		d.metricsChanged = dm.writeMetricsTextfile
+	}
+	return dm
+}
+
+// app returns the deployer for the named app. The name may be left out
+// when only one app is configured.
+func (dm *Daemon) app(name string) (*Deployer, error) {
+	if name == "" {
+		if len(dm.apps) == 1 {
+			return dm.apps[0], nil
+		}
+		return nil, errAppRequired
+	}
+	for _, d := range dm.apps {
+		if d.currentConfig().Name == name {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown app %q", name)
+}
+
+// Run starts every app's polling loop and the shared endpoints, and stops
+// them when ctx is cancelled
+func (dm *Daemon) Run(ctx context.Context) error {
+	// Pet the systemd watchdog unless a check has wedged
+	var watchdog <-chan time.Time
+	interval := dm.sdNotify.WatchdogInterval()
+	if interval > 0 {
+		watchdogTicker := time.NewTicker(interval)
+		defer watchdogTicker.Stop()
+		watchdog = watchdogTicker.C
+	}
+
+	// In supervise mode the deployer runs the applications itself
+	for _, d := range dm.apps {
+		d.startSupervisor()
+		defer d.supervisor.Stop()
+	}
+
+	// Give notifications about the last deployments a chance to go out
+	defer func() {
+		dm.reloadMu.Lock()
+		defer dm.reloadMu.Unlock()
+		dm.notifier.Close()
+	}()
+
+	// Config and state are loaded, so start-up is complete
+	if err := dm.sdNotify.Ready(dm.idleStatus()); err != nil {
+		dm.logger.Warn("Failed to notify systemd", "error", err)
+	}
+
+	metricsServer, err := dm.startMetricsServer()
+	if err != nil {
+		return fmt.Errorf("failed to start metrics server: %w", err)
+	}
+	defer dm.stopServer(metricsServer)
+	dm.writeMetricsTextfile()
+
+	// The admin API starts last: from here on, state is only touched under opMu
+	adminServer, err := dm.startAdminServer(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start admin API: %w", err)
+	}
+	defer dm.stopServer(adminServer)
+
+	// Webhook deliveries trigger checks early; polling remains the fallback
+	webhookServer, err := dm.startWebhookServer()
+	if err != nil {
+		return fmt.Errorf("failed to start GitHub webhook receiver: %w", err)
+	}
+	defer dm.stopServer(webhookServer)
+
+	var wg sync.WaitGroup
+	for _, d := range dm.apps {
+		wg.Add(1)
+		go func(d *Deployer) {
+			defer wg.Done()
+			d.run(ctx)
+		}(d)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			dm.logger.Info("Shutting down deployer")
+			_ = dm.sdNotify.Stopping()
+			wg.Wait()
+			return nil
+		case <-watchdog:
+			if !dm.stalled(interval) {
+				_ = dm.sdNotify.Watchdog()
+			}
+		}
+	}
+}
+
+// stalled reports whether any app's check has been running for longer than limit
+func (dm *Daemon) stalled(limit time.Duration) bool {
+	for _, d := range dm.apps {
+		if d.stalledFor() > limit {
+			return true
+		}
+	}
+	return false
+}
+
+// idleStatus describes every app between checks
+func (dm *Daemon) idleStatus() string {
+	if len(dm.apps) == 1 && dm.apps[0].currentConfig().Name == "" {
+		return dm.apps[0].idleStatus()
+	}
+	statuses := make([]string, 0, len(dm.apps))
+	for _, d := range dm.apps {
+		statuses = append(statuses, d.currentConfig().Name+": "+d.idleStatus())
+	}
+	return strings.Join(statuses, "; ")
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestApps configures two apps, web and worker, each with its own slots
+// and state
+func newTestApps(t *testing.T) *Config {
+	t.Helper()
+	config := &Config{CheckIntervalSecs: 3600}
+	for _, name := range []string{"web", "worker"} {
+		app := setupSlots(t, &DeploymentState{ActiveSlot: "blue"}).AppConfig
+		app.Name = name
+		app.Repo = "test/" + name
+		app.AssetSuffix = ".tar.gz"
+		config.Apps = append(config.Apps, app)
+	}
+	return config
+}
+
+// newTestAppsDaemon creates a daemon for config whose shared GitHub client
+// talks to server
+func newTestAppsDaemon(t *testing.T, config *Config, server *httptest.Server) *Daemon {
+	t.Helper()
+	dm, err := NewDaemon(config, slog.New(slog.NewTextHandler(os.Stdout, nil)), false)
+	if err != nil {
+		t.Fatalf("NewDaemon failed: %v", err)
+	}
+	dm.github.client.Transport = &mockTransport{server: server}
+	return dm
+}
+
+func TestDaemonRunsAppsIndependently(t *testing.T) {
+	server := newTestReleaseServer(t, "v1.0.0", map[string]string{"app.txt": "v1"})
+	config := newTestApps(t)
+	// The worker's releases have no matching asset, so every check fails
+	config.Apps[1].AssetSuffix = ".zip"
+	dm := newTestAppsDaemon(t, config, server)
+
+	if len(dm.apps) != 2 || dm.apps[0].github != dm.apps[1].github {
+		t.Fatalf("Expected 2 apps sharing one GitHub client")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- dm.Run(ctx) }()
+
+	web, worker := dm.apps[0], dm.apps[1]
+	deployed := func() bool {
+		state, err := LoadState(web.config.StateFile)
+		return err == nil && state.GreenVersion == "v1.0.0"
+	}
+	if !waitFor(t, 5*time.Second, deployed) {
+		t.Error("Expected web to be deployed despite worker failing")
+	}
+	if !waitFor(t, 5*time.Second, func() bool { return strings.HasPrefix(worker.currentPhase(), "Last check failed") }) {
+		t.Errorf("Expected worker's check to fail, phase is %q", worker.currentPhase())
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := dm.writeMetrics(&b); err != nil {
+		t.Fatalf("writeMetrics failed: %v", err)
+	}
+	for _, want := range []string{
+		`deployer_deployments_total{app="web",outcome="success"} 1`,
+		`deployer_active_version_info{app="web",tag="v1.0.0",slot="green"} 1`,
+		`deployer_checks_total{app="worker"} 1`,
+		`deployer_deployments_total{app="worker",outcome="success"} 0`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("Metrics missing %q:\n%s", want, b.String())
+		}
+	}
+	if strings.Count(b.String(), "# TYPE deployer_checks_total counter") != 1 {
+		t.Errorf("Expected each metric family to be described once:\n%s", b.String())
+	}
+}
+
+func TestAppsWaitForSharedRateLimitReset(t *testing.T) {
+	var requests atomic.Int32
+	reset := time.Now().Add(time.Hour).Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		http.Error(w, "API rate limit exceeded", http.StatusForbidden)
+	}))
+	defer server.Close()
+	dm := newTestAppsDaemon(t, newTestApps(t), server)
+	web, worker := dm.apps[0], dm.apps[1]
+
+	if err := web.checkAndDeploy(context.Background()); !errors.Is(err, errRateLimited) {
+		t.Fatalf("Expected the first check to be rate limited, got %v", err)
+	}
+	// Neither app calls the API again until the limit resets
+	for _, d := range []*Deployer{worker, web} {
+		if err := d.checkAndDeploy(context.Background()); err != nil {
+			t.Errorf("Expected %s to skip its check, got %v", d.config.Name, err)
+		}
+	}
+	if err := worker.DeployTag(context.Background(), "v1.0.0"); !errors.Is(err, errRateLimited) {
+		t.Errorf("Expected a manual deployment to be refused until the reset, got %v", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected 1 API request before the reset, got %d", got)
+	}
+	if until, limited := dm.github.RateLimitedUntil(); !limited || until.Unix() != reset {
+		t.Errorf("Expected to be rate limited until %d, got %v %v", reset, until, limited)
+	}
+}
+
+func TestNewDaemonLeavesOutBrokenApps(t *testing.T) {
+	config := newTestApps(t)
+	if err := os.WriteFile(config.Apps[0].StateFile, []byte("{not yaml"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	dm, err := NewDaemon(config, logger, false)
+	if err != nil {
+		t.Fatalf("Expected the healthy app to run, got %v", err)
+	}
+	if len(dm.apps) != 1 || dm.apps[0].config.Name != "worker" {
+		t.Errorf("Expected only worker to be set up, got %d apps", len(dm.apps))
+	}
+
+	if err := os.WriteFile(config.Apps[1].StateFile, []byte("{not yaml"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDaemon(config, logger, false); err == nil {
+		t.Error("Expected an error when no app can be set up")
+	}
+}
+
+func TestAdminTargetsApps(t *testing.T) {
+	server := newTestReleaseServer(t, "v1.0.0", map[string]string{"app.txt": "v1"})
+	config := newTestApps(t)
+	config.Admin = AdminConfig{Token: "secret"}
+	dm := newTestAppsDaemon(t, config, server)
+	admin := httptest.NewServer(dm.adminHandler(context.Background()))
+	defer admin.Close()
+
+	if code := adminRequest(t, admin, http.MethodPost, "/check", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without an app, got %d", code)
+	}
+	if code := adminRequest(t, admin, http.MethodPost, "/check?app=db", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown app, got %d", code)
+	}
+
+	var status AdminStatus
+	if code := adminRequest(t, admin, http.MethodPost, "/check?app=worker", &status); code != http.StatusOK {
+		t.Fatalf("Expected check of worker to succeed, got %d", code)
+	}
+	if status.App != "worker" || status.Version != "v1.0.0" {
+		t.Errorf("Unexpected worker status %+v", status)
+	}
+
+	var statuses []AdminStatus
+	if code := adminRequest(t, admin, http.MethodGet, "/status", &statuses); code != http.StatusOK {
+		t.Fatalf("Expected status of every app, got %d", code)
+	}
+	if len(statuses) != 2 || statuses[0].App != "web" || statuses[0].Version != "" || statuses[1].Version != "v1.0.0" {
+		t.Errorf("Expected only worker to be deployed, got %+v", statuses)
+	}
+}
+
+func TestWebhookTriggersMatchingApps(t *testing.T) {
+	server := newTestReleaseServer(t, "v1.0.0", map[string]string{"app.txt": "v1"})
+	config := newTestApps(t)
+	config.GitHubWebhook = GitHubWebhookConfig{Secret: "hook-secret"}
+	dm := newTestAppsDaemon(t, config, server)
+	hooks := httptest.NewServer(dm.webhookHandler())
+	defer hooks.Close()
+
+	if code := sendWebhook(t, hooks, "release", "hook-secret", releasePayload("published", "test/worker")); code != http.StatusAccepted {
+		t.Errorf("Expected 202, got %d", code)
+	}
+	if triggered(dm.apps[0]) || !triggered(dm.apps[1]) {
+		t.Error("Expected only worker to be triggered")
+	}
+}
+
+func TestStatusCommand(t *testing.T) {
+	config := newTestApps(t)
+	state := &DeploymentState{ActiveSlot: "green", BlueVersion: "v1.0.0", GreenVersion: "v1.1.0"}
+	state.AddHistory(HistoryEntry{Time: time.Now(), Action: "deploy", Tag: "v1.1.0", Slot: "green", Success: true})
+	if err := state.SaveState(config.Apps[1].StateFile); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := statusCommand(config, []string{"--app", "worker"}, &out); err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	for _, want := range []string{"App:          worker", "Repo:         test/worker", "Version:      v1.1.0", "Blue:         v1.0.0", "Last deploy:  v1.1.0 at "} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in output:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "web") {
+		t.Errorf("Expected only worker in output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := statusCommand(config, nil, &out); err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "App:          web") || !strings.Contains(out.String(), "Version:      none") {
+		t.Errorf("Expected every app in output:\n%s", out.String())
+	}
+
+	if err := statusCommand(config, []string{"--app", "db"}, &out); err == nil {
+		t.Error("Expected an error for an unknown app")
+	}
+}
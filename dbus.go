@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements the small subset of the D-Bus wire protocol needed to
+// ask systemd to restart units: EXTERNAL authentication, method calls with
+// string arguments, and replies carrying strings, object paths or string
+// variants. It avoids pulling a full D-Bus library into the binary.
+
+const defaultSystemBusAddress = "unix:path=/run/dbus/system_bus_socket"
+
+// D-Bus message types
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+)
+
+// D-Bus header field codes
+const (
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSignature   = 8
+)
+
+// dbusMessage is a decoded D-Bus message. Body holds the decoded arguments,
+// which are strings for the s, o and g types and for string variants.
+type dbusMessage struct {
+	Type        byte
+	Serial      uint32
+	ReplySerial uint32
+	Path        string
+	Interface   string
+	Member      string
+	Destination string
+	ErrorName   string
+	Signature   string
+	Body        []string
+
+	// bodyErr is set when the body uses types this decoder does not support
+	bodyErr error
+}
+
+// DBusError is an error reply from a D-Bus peer
+type DBusError struct {
+	Name    string
+	Message string
+}
+
+func (e *DBusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s: %s", e.Name, e.Message)
+	}
+	return e.Name
+}
+
+// dbusConn is a connection to a message bus
+type dbusConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex
+	serial uint32
+}
+
+// dialSystemBus connects and authenticates to the system bus
+func dialSystemBus(ctx context.Context) (*dbusConn, error) {
+	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if address == "" {
+		address = defaultSystemBusAddress
+	}
+	return dialBus(ctx, address)
+}
+
+// dialBus connects to a unix:path= bus address, authenticates with the
+// EXTERNAL mechanism and registers with Hello
+func dialBus(ctx context.Context, address string) (*dbusConn, error) {
+	path, ok := strings.CutPrefix(address, "unix:path=")
+	if !ok {
+		return nil, fmt.Errorf("unsupported D-Bus address %q", address)
+	}
+	if i := strings.IndexByte(path, ','); i >= 0 {
+		path = path[:i]
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to D-Bus: %w", err)
+	}
+	c := &dbusConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := io.WriteString(conn, "\x00AUTH EXTERNAL "+uid+"\r\n"); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("D-Bus authentication failed: %w", err)
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "OK") {
+		_ = conn.Close()
+		return nil, fmt.Errorf("D-Bus authentication rejected: %q", strings.TrimSpace(line))
+	}
+	if _, err := io.WriteString(conn, "BEGIN\r\n"); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("D-Bus authentication failed: %w", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	if _, err := c.Call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello"); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("D-Bus Hello failed: %w", err)
+	}
+	return c, nil
+}
+
+// Close closes the connection
+func (c *dbusConn) Close() error {
+	return c.conn.Close()
+}
+
+// Call invokes a method whose arguments are all strings and waits for its
+// reply, skipping unrelated messages such as signals
+func (c *dbusConn) Call(ctx context.Context, dest, path, iface, member string, args ...string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.serial++
+	msg := &dbusMessage{
+		Type:        dbusMethodCall,
+		Serial:      c.serial,
+		Path:        path,
+		Interface:   iface,
+		Member:      member,
+		Destination: dest,
+		Signature:   strings.Repeat("s", len(args)),
+		Body:        args,
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.conn.SetDeadline(deadline)
+		defer func() { _ = c.conn.SetDeadline(time.Time{}) }()
+	}
+	if _, err := c.conn.Write(encodeDBusMessage(msg)); err != nil {
+		return nil, err
+	}
+
+	for {
+		reply, err := readDBusMessage(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if reply.ReplySerial != msg.Serial {
+			continue
+		}
+		if reply.bodyErr != nil {
+			return nil, reply.bodyErr
+		}
+		if reply.Type == dbusError {
+			e := &DBusError{Name: reply.ErrorName}
+			if len(reply.Body) > 0 {
+				e.Message = reply.Body[0]
+			}
+			return nil, e
+		}
+		return reply.Body, nil
+	}
+}
+
+// dbusEncoder writes little-endian D-Bus values with the required alignment
+type dbusEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *dbusEncoder) align(n int) {
+	for e.buf.Len()%n != 0 {
+		e.buf.WriteByte(0)
+	}
+}
+
+func (e *dbusEncoder) uint32(v uint32) {
+	e.align(4)
+	_ = binary.Write(&e.buf, binary.LittleEndian, v)
+}
+
+func (e *dbusEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf.WriteString(s)
+	e.buf.WriteByte(0)
+}
+
+func (e *dbusEncoder) signature(s string) {
+	e.buf.WriteByte(byte(len(s)))
+	e.buf.WriteString(s)
+	e.buf.WriteByte(0)
+}
+
+// field writes a header field struct with a variant value
+func (e *dbusEncoder) field(code byte, sig string, value string) {
+	e.align(8)
+	e.buf.WriteByte(code)
+	e.signature(sig)
+	switch sig {
+	case "g":
+		e.signature(value)
+	default:
+		e.string(value)
+	}
+}
+
+// encodeDBusMessage serialises a message whose body is a list of strings,
+// object paths, signatures or string variants as described by Signature
+func encodeDBusMessage(m *dbusMessage) []byte {
+	var body dbusEncoder
+	for i, c := range m.Signature {
+		switch c {
+		case 'g':
+			body.signature(m.Body[i])
+		case 'v':
+			body.signature("s")
+			body.string(m.Body[i])
+		default:
+			body.string(m.Body[i])
+		}
+	}
+
+	var fields dbusEncoder
+	// Field offsets are relative to the message start, which is 16 bytes
+	// before the fields array: both are 8-aligned, so alignment carries over
+	if m.Path != "" {
+		fields.field(dbusFieldPath, "o", m.Path)
+	}
+	if m.Interface != "" {
+		fields.field(dbusFieldInterface, "s", m.Interface)
+	}
+	if m.Member != "" {
+		fields.field(dbusFieldMember, "s", m.Member)
+	}
+	if m.ErrorName != "" {
+		fields.field(dbusFieldErrorName, "s", m.ErrorName)
+	}
+	if m.ReplySerial != 0 {
+		fields.align(8)
+		fields.buf.WriteByte(dbusFieldReplySerial)
+		fields.signature("u")
+		fields.uint32(m.ReplySerial)
+	}
+	if m.Destination != "" {
+		fields.field(dbusFieldDestination, "s", m.Destination)
+	}
+	if m.Signature != "" {
+		fields.field(dbusFieldSignature, "g", m.Signature)
+	}
+
+	var out dbusEncoder
+	out.buf.Write([]byte{'l', m.Type, 0, 1})
+	out.uint32(uint32(body.buf.Len()))
+	out.uint32(m.Serial)
+	out.uint32(uint32(fields.buf.Len()))
+	out.buf.Write(fields.buf.Bytes())
+	out.align(8)
+	out.buf.Write(body.buf.Bytes())
+	return out.buf.Bytes()
+}
+
+// dbusDecoder reads D-Bus values from a buffer holding a whole message
+type dbusDecoder struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+	err   error
+}
+
+func (d *dbusDecoder) align(n int) {
+	for d.pos%n != 0 {
+		d.pos++
+	}
+}
+
+func (d *dbusDecoder) need(n int) bool {
+	if d.err == nil && d.pos+n > len(d.data) {
+		d.err = errors.New("truncated D-Bus message")
+	}
+	return d.err == nil
+}
+
+func (d *dbusDecoder) byte() byte {
+	if !d.need(1) {
+		return 0
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *dbusDecoder) uint32() uint32 {
+	d.align(4)
+	if !d.need(4) {
+		return 0
+	}
+	v := d.order.Uint32(d.data[d.pos:])
+	d.pos += 4
+	return v
+}
+
+func (d *dbusDecoder) string() string {
+	n := int(d.uint32())
+	if !d.need(n + 1) {
+		return ""
+	}
+	s := string(d.data[d.pos : d.pos+n])
+	d.pos += n + 1
+	return s
+}
+
+func (d *dbusDecoder) signature() string {
+	n := int(d.byte())
+	if !d.need(n + 1) {
+		return ""
+	}
+	s := string(d.data[d.pos : d.pos+n])
+	d.pos += n + 1
+	return s
+}
+
+// value decodes a single value of a basic type as a string
+func (d *dbusDecoder) value(sig string) string {
+	switch sig {
+	case "s", "o":
+		return d.string()
+	case "g":
+		return d.signature()
+	case "u":
+		return strconv.FormatUint(uint64(d.uint32()), 10)
+	case "v":
+		return d.value(d.signature())
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unsupported D-Bus type %q", sig)
+		}
+		return ""
+	}
+}
+
+// readDBusMessage reads and decodes one message from r
+func readDBusMessage(r io.Reader) (*dbusMessage, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid D-Bus endianness byte %q", fixed[0])
+	}
+	bodyLen := int(order.Uint32(fixed[4:]))
+	fieldsLen := int(order.Uint32(fixed[12:]))
+	headerLen := 16 + fieldsLen
+	if pad := headerLen % 8; pad != 0 {
+		headerLen += 8 - pad
+	}
+
+	data := make([]byte, headerLen+bodyLen)
+	copy(data, fixed)
+	if _, err := io.ReadFull(r, data[16:]); err != nil {
+		return nil, err
+	}
+
+	m := &dbusMessage{Type: fixed[1], Serial: order.Uint32(fixed[8:])}
+	d := &dbusDecoder{data: data, pos: 16, order: order}
+	for d.err == nil && d.pos < 16+fieldsLen {
+		d.align(8)
+		code := d.byte()
+		value := d.value(d.signature())
+		switch code {
+		case dbusFieldPath:
+			m.Path = value
+		case dbusFieldInterface:
+			m.Interface = value
+		case dbusFieldMember:
+			m.Member = value
+		case dbusFieldErrorName:
+			m.ErrorName = value
+		case dbusFieldReplySerial:
+			serial, _ := strconv.ParseUint(value, 10, 32)
+			m.ReplySerial = uint32(serial)
+		case dbusFieldDestination:
+			m.Destination = value
+		case dbusFieldSignature:
+			m.Signature = value
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	// Unsupported body types only matter if this is the reply we wait for
+	d.pos = headerLen
+	for _, c := range m.Signature {
+		m.Body = append(m.Body, d.value(string(c)))
+		if d.err != nil {
+			m.Body, m.bodyErr = nil, d.err
+			break
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSystemdBus is a minimal D-Bus peer that behaves like systemd for
+// RestartUnit, ReloadUnit, LoadUnit and property reads
+type fakeSystemdBus struct {
+	listener net.Listener
+	state    string
+
+	mu       sync.Mutex
+	calls    []string
+	jobError string
+}
+
+func newFakeSystemdBus(t *testing.T, state string) *fakeSystemdBus {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "bus.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on fake bus: %v", err)
+	}
+	bus := &fakeSystemdBus{listener: listener, state: state}
+	t.Cleanup(func() { _ = listener.Close() })
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "unix:path="+socket)
+	go bus.serve()
+	return bus
+}
+
+func (b *fakeSystemdBus) Calls() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.calls...)
+}
+
+func (b *fakeSystemdBus) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeSystemdBus) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+
+	// Authentication: a NUL byte, AUTH, then BEGIN
+	if _, err := reader.ReadByte(); err != nil {
+		return
+	}
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, "AUTH EXTERNAL") {
+		_, _ = conn.Write([]byte("REJECTED EXTERNAL\r\n"))
+		return
+	}
+	_, _ = conn.Write([]byte("OK 0123456789abcdef\r\n"))
+	if line, err := reader.ReadString('\n'); err != nil || line != "BEGIN\r\n" {
+		return
+	}
+
+	var serial uint32
+	for {
+		msg, err := readDBusMessage(reader)
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.calls = append(b.calls, msg.Member+" "+strings.Join(msg.Body, " "))
+		jobError := b.jobError
+		b.mu.Unlock()
+
+		serial++
+		reply := &dbusMessage{Type: dbusMethodReturn, Serial: serial, ReplySerial: msg.Serial}
+		switch {
+		case msg.Member == "Hello":
+			// Send an unrelated signal first, as a real bus does
+			signal := &dbusMessage{Type: 4, Serial: serial, Path: "/org/freedesktop/DBus",
+				Interface: "org.freedesktop.DBus", Member: "NameAcquired", Signature: "s", Body: []string{":1.42"}}
+			_, _ = conn.Write(encodeDBusMessage(signal))
+			serial++
+			reply.Serial = serial
+			reply.Signature, reply.Body = "s", []string{":1.42"}
+		case msg.Member == "RestartUnit" || msg.Member == "ReloadUnit":
+			reply.Signature, reply.Body = "o", []string{"/org/freedesktop/systemd1/job/7"}
+		case msg.Member == "LoadUnit":
+			reply.Signature, reply.Body = "o", []string{"/org/freedesktop/systemd1/unit/app_2eservice"}
+		case msg.Member == "Get" && strings.Contains(msg.Path, "/job/"):
+			reply.Type, reply.ErrorName = dbusError, dbusUnknownObject
+			if jobError != "" {
+				reply.ErrorName = jobError
+			}
+			reply.Signature, reply.Body = "s", []string{"Unknown object"}
+		case msg.Member == "Get":
+			reply.Signature, reply.Body = "v", []string{b.state}
+		default:
+			reply.Type, reply.ErrorName = dbusError, "org.freedesktop.DBus.Error.UnknownMethod"
+		}
+		_, _ = conn.Write(encodeDBusMessage(reply))
+	}
+}
+
+func TestDBusMessageRoundTrip(t *testing.T) {
+	msg := &dbusMessage{
+		Type:        dbusMethodCall,
+		Serial:      3,
+		Path:        systemdPath,
+		Interface:   systemdManagerIface,
+		Member:      "RestartUnit",
+		Destination: systemdDest,
+		Signature:   "ss",
+		Body:        []string{"app.service", "replace"},
+	}
+
+	decoded, err := readDBusMessage(strings.NewReader(string(encodeDBusMessage(msg))))
+	if err != nil {
+		t.Fatalf("Failed to decode message: %v", err)
+	}
+	if decoded.Member != msg.Member || decoded.Path != msg.Path || decoded.Destination != msg.Destination ||
+		decoded.Serial != 3 || strings.Join(decoded.Body, ",") != "app.service,replace" {
+		t.Errorf("Round trip mismatch: %+v", decoded)
+	}
+}
+
+func TestDBusUnitManager(t *testing.T) {
+	bus := newFakeSystemdBus(t, "active")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := dialSystemBus(ctx)
+	if err != nil {
+		t.Fatalf("Failed to dial fake bus: %v", err)
+	}
+	mgr := &dbusUnitManager{conn: conn}
+	defer func() { _ = mgr.Close() }()
+
+	if err := mgr.RestartUnit(ctx, "app.service"); err != nil {
+		t.Fatalf("RestartUnit failed: %v", err)
+	}
+	state, err := mgr.ActiveState(ctx, "app.service")
+	if err != nil {
+		t.Fatalf("ActiveState failed: %v", err)
+	}
+	if state != "active" {
+		t.Errorf("Expected state 'active', got %q", state)
+	}
+
+	calls := bus.Calls()
+	if len(calls) < 2 || calls[1] != "RestartUnit app.service replace" {
+		t.Errorf("Unexpected calls: %v", calls)
+	}
+
+	_, err = conn.Call(ctx, systemdDest, systemdPath, systemdManagerIface, "NoSuchMethod")
+	var dbusErr *DBusError
+	if !errors.As(err, &dbusErr) || dbusErr.Name != "org.freedesktop.DBus.Error.UnknownMethod" {
+		t.Errorf("Expected UnknownMethod error, got %v", err)
+	}
+
+	// Any other error about the job is not taken to mean it has finished
+	bus.mu.Lock()
+	bus.jobError = "org.freedesktop.DBus.Error.AccessDenied"
+	bus.mu.Unlock()
+	err = mgr.RestartUnit(ctx, "app.service")
+	if !errors.As(err, &dbusErr) || dbusErr.Name != "org.freedesktop.DBus.Error.AccessDenied" {
+		t.Errorf("Expected AccessDenied waiting for the job, got %v", err)
+	}
+}
@@ -4,68 +4,304 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime/debug"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Deployer manages the deployment process
+// Deployer manages the deployment process of one application
 type Deployer struct {
-	config *Config
-	logger *log.Logger
-	state  *DeploymentState
-	github *GitHubClient
-	dryRun bool
+	// config is replaced by reloads while holding opMu and configMu. Code
+	// that may run outside opMu reads it with currentConfig.
+	config     *Config
+	configMu   sync.RWMutex
+	logger     *slog.Logger
+	state      *DeploymentState
+	github     *GitHubClient
+	supervisor *Supervisor
+	sdNotify   *sdNotifier
+	metrics    *Metrics
+	notifier   *Notifier
+	dryRun     bool
+
+	// plannedTag is the release a dry run last planned, guarded by opMu
+	plannedTag string
+
+	// soakingTag is the latest release while it is younger than
+	// min_release_age or waiting for its rollout stage, guarded by opMu
+	soakingTag string
+
+	// scheduleKey identifies the release and version of the asset the
+	// rollout schedule in scheduleStages was read from, guarded by opMu
+	scheduleKey    string
+	scheduleStages []rolloutStage
+	scheduleHalted bool
+
+	// stagedTag is the release prepared in the inactive slot whose switch
+	// is deferred until deployments are allowed, guarded by opMu
+	stagedTag string
+
+	// trigger requests an immediate check from the polling loop
+	trigger chan struct{}
+
+	// reloaded tells the polling loop that the configuration was replaced
+	reloaded chan struct{}
+
+	// metricsChanged publishes the metrics after an operation, if set
+	metricsChanged func()
+
+	// opMu serializes checks, deploys and rollbacks within this process
+	opMu sync.Mutex
+
+	// checkStarted is when the running check or admin operation began, in
+	// Unix nanoseconds, or 0
+	checkStarted atomic.Int64
+
+	// lastProgress is when the deployer last changed phase or a hook wrote
+	// a line of output, in Unix nanoseconds
+	lastProgress atomic.Int64
+
+	// phaseMu guards phase, the last status reported by setStatus
+	phaseMu sync.Mutex
+	phase   string
 }
 
 // NewDeployer creates a new deployer instance
-func NewDeployer(config *Config, logger *log.Logger, dryRun bool) (*Deployer, error) {
+func NewDeployer(config *Config, logger *slog.Logger, dryRun bool) (*Deployer, error) {
+	notifier, err := NewNotifier(config.Notifications, logger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification settings: %w", err)
+	}
+	return newDeployer(config, logger, NewGitHubClient(config.GitHubToken, logger), notifier, dryRun)
+}
+
+// newDeployer creates a deployer for one app, using a GitHub client and
+// notifier that may be shared with other apps
+func newDeployer(config *Config, logger *slog.Logger, github *GitHubClient, notifier *Notifier, dryRun bool) (*Deployer, error) {
+	if config.Name != "" {
+		logger = logger.With("app", config.Name)
+	}
+
 	state, err := LoadState(config.StateFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load state: %w", err)
 	}
+	if state.RecoveredFromBackup {
+		logger.Warn("State file was unreadable, recovered state from backup", "file", config.StateFile)
+	}
 
-	github := NewGitHubClient(config.GitHubToken)
+	d := &Deployer{
+		config:   config,
+		logger:   logger,
+		state:    state,
+		github:   github,
+		sdNotify: newSDNotifier(),
+		metrics:  NewMetrics(),
+		notifier: notifier,
+		dryRun:   dryRun,
+		trigger:  make(chan struct{}, 1),
+		reloaded: make(chan struct{}, 1),
+	}
+	d.metrics.app = config.Name
 
-	return &Deployer{
-		config: config,
-		logger: logger,
-		state:  state,
-		github: github,
-		dryRun: dryRun,
-	}, nil
-}
+	if config.Supervise.Enabled {
+		d.supervisor = NewSupervisor(d, config.Supervise)
+	}
 
-// Run starts the deployment polling loop
-func (d *Deployer) Run(ctx context.Context) error {
-	ticker := time.NewTicker(time.Duration(d.config.CheckIntervalSecs) * time.Second)
-	defer ticker.Stop()
+	// Reconcile the recorded state with what is actually on disk
+	report := d.reconcile()
+	for _, problem := range report.Problems {
+		logger.Warn("Reconciliation problem", "problem", problem)
+	}
+	switch {
+	case len(report.Repairs) == 0:
+	case dryRun:
+		d.logRepairs(report.Repairs)
+	default:
+		// Taking the lock reloads the state and reconciles it again, logging
+		// the repairs
+		err := d.withDeployLock(func() error {
+			return d.state.SaveState(config.StateFile)
+		})
+		var held *LockHeldError
+		switch {
+		case errors.As(err, &held):
+			d.logRepairs(report.Repairs)
+			logger.Warn("Not saving reconciled state while another process deploys", "error", err)
+		case err != nil:
+			return nil, fmt.Errorf("failed to save reconciled state: %w", err)
+		}
+	}
+	d.metrics.SetActive(d.getCurrentVersion(), d.state.ActiveSlot, d.state.Paused)
 
-	// Perform initial check
-	if err := d.checkAndDeploy(ctx); err != nil {
-		d.logger.Printf("Initial deployment check failed: %v", err)
+	return d, nil
+}
+
+// startSupervisor starts the supervised application, if there is one and
+// something has been deployed
+func (d *Deployer) startSupervisor() {
+	if d.supervisor == nil || d.dryRun {
+		return
+	}
+	if _, err := os.Stat(d.currentConfig().CurrentSymlink); err != nil {
+		d.logger.Info("Nothing deployed yet, application will start after the first deployment")
+		return
 	}
+	d.supervisor.Start()
+}
+
+// currentConfig returns the configuration in effect
+func (d *Deployer) currentConfig() *Config {
+	d.configMu.RLock()
+	defer d.configMu.RUnlock()
+	return d.config
+}
+
+// checkInterval returns the time between scheduled checks
+func (d *Deployer) checkInterval() time.Duration {
+	return time.Duration(d.currentConfig().CheckIntervalSecs) * time.Second
+}
 
+// run checks for new releases straight away, then every check interval and
+// whenever a check is triggered, until ctx is cancelled
+func (d *Deployer) run(ctx context.Context) {
+	ticker := time.NewTicker(d.checkInterval())
+	defer ticker.Stop()
+
+	d.logger.Info("Watching for releases", "repo", d.currentConfig().Repo)
+	d.runCheck(ctx, "Initial deployment check failed")
 	for {
 		select {
 		case <-ctx.Done():
-			d.logger.Println("Shutting down deployer")
-			return nil
+			return
 		case <-ticker.C:
-			if err := d.checkAndDeploy(ctx); err != nil {
-				d.logger.Printf("Deployment check failed: %v", err)
-			}
+			d.runCheck(ctx, "Deployment check failed")
+		case <-d.trigger:
+			d.runCheck(ctx, "Triggered deployment check failed")
+		case <-d.reloaded:
+			ticker.Reset(d.checkInterval())
 		}
 	}
 }
 
+// runCheck runs one check, logging failures and keeping systemd's status
+// current. A panic fails the check rather than every app in the process.
+func (d *Deployer) runCheck(ctx context.Context, failureMessage string) {
+	d.opMu.Lock()
+	defer d.opMu.Unlock()
+	defer d.writeMetricsTextfile()
+
+	defer d.beginOperation()()
+	defer func() {
+		if r := recover(); r != nil {
+			d.logger.Error(failureMessage, "panic", r, "stack", string(debug.Stack()))
+			d.setStatus("Last check failed: %v", r)
+		}
+	}()
+
+	if err := d.checkAndDeploy(ctx); err != nil {
+		d.logger.Error(failureMessage, "error", err)
+		d.setStatus("Last check failed: %v", err)
+		return
+	}
+	d.setStatus("%s", d.idleStatus())
+}
+
+// beginOperation marks a check or operation as running for the stall
+// detector, and returns the function that marks it finished
+func (d *Deployer) beginOperation() func() {
+	d.checkStarted.Store(time.Now().UnixNano())
+	return func() { d.checkStarted.Store(0) }
+}
+
+// progressed records that the running operation is still making progress
+func (d *Deployer) progressed() {
+	d.lastProgress.Store(time.Now().UnixNano())
+}
+
+// stalledFor reports how long the running check or operation has gone
+// without progress, or 0 when idle
+func (d *Deployer) stalledFor() time.Duration {
+	started := d.checkStarted.Load()
+	if started == 0 {
+		return 0
+	}
+	if progress := d.lastProgress.Load(); progress > started {
+		started = progress
+	}
+	return time.Since(time.Unix(0, started))
+}
+
+// writeMetricsTextfile publishes the metrics after an operation
+func (d *Deployer) writeMetricsTextfile() {
+	if d.metricsChanged != nil {
+		d.metricsChanged()
+	}
+}
+
+// idleStatus describes the deployer between checks
+func (d *Deployer) idleStatus() string {
+	status := "Idle, nothing deployed"
+	if version := d.getCurrentVersion(); version != "" {
+		status = fmt.Sprintf("Idle, running %s from %s slot", version, d.state.ActiveSlot)
+	}
+	if d.stagedTag != "" {
+		status += fmt.Sprintf(", %s staged until deployments are allowed", d.stagedTag)
+	}
+	return status
+}
+
+// setStatus records the current phase and reports it to systemd, prefixed
+// with the app's name when several apps share the process
+func (d *Deployer) setStatus(format string, args ...any) {
+	status := fmt.Sprintf(format, args...)
+	d.phaseMu.Lock()
+	d.phase = status
+	d.phaseMu.Unlock()
+	d.progressed()
+	if name := d.currentConfig().Name; name != "" {
+		status = name + ": " + status
+	}
+	if err := d.sdNotify.Status(status); err != nil {
+		d.logger.Warn("Failed to notify systemd", "error", err)
+	}
+}
+
+// currentPhase returns the last status reported by setStatus
+func (d *Deployer) currentPhase() string {
+	d.phaseMu.Lock()
+	defer d.phaseMu.Unlock()
+	return d.phase
+}
+
 // checkAndDeploy checks for new releases and deploys if needed
-func (d *Deployer) checkAndDeploy(ctx context.Context) error {
-	d.logger.Printf("Checking for new releases for repo: %s", d.config.Repo)
+func (d *Deployer) checkAndDeploy(ctx context.Context) (err error) {
+	if d.state.Paused {
+		d.logger.Info("Deployments are paused, skipping check")
+		return nil
+	}
+
+	// The quota is shared by every app using this client
+	if until, limited := d.github.RateLimitedUntil(); limited {
+		d.logger.Debug("Skipping check until the GitHub rate limit resets", "reset", until)
+		return nil
+	}
+
+	d.metrics.CheckStarted()
+	defer func() {
+		if err == nil {
+			d.metrics.CheckSucceeded()
+		}
+	}()
+
+	d.logger.Debug("Checking for new releases", "repo", d.config.Repo)
+	d.setStatus("Checking %s for new releases", d.config.Repo)
 
 	release, err := d.github.GetLatestRelease(ctx, d.config.Repo)
 	if err != nil {
@@ -75,209 +311,571 @@ func (d *Deployer) checkAndDeploy(ctx context.Context) error {
 	// Check if this version is already deployed
 	currentVersion := d.getCurrentVersion()
 	if release.TagName == currentVersion {
-		d.logger.Printf("Already on latest version: %s", release.TagName)
+		d.logger.Debug("Already on latest version", "tag", release.TagName)
+		return nil
+	}
+
+	// Give a release time to be withdrawn or superseded by a hotfix, and
+	// roll it out to the fleet in stages
+	now := time.Now()
+	var wait string
+	if eligibleAt := d.releaseEligibleAt(release); now.Before(eligibleAt) {
+		wait = "it is younger than min_release_age until " + eligibleAt.Format(time.RFC3339)
+	} else if wait, err = d.rolloutWait(ctx, release, now); err != nil {
+		return err
+	}
+	if wait != "" {
+		if release.TagName == d.soakingTag {
+			d.logger.Debug("New version is not due yet", "tag", release.TagName, "reason", wait)
+		} else {
+			d.logger.Info("New version is not due yet, waiting", "tag", release.TagName,
+				"published_at", release.PublishedAt, "reason", wait)
+			d.soakingTag = release.TagName
+		}
 		return nil
 	}
+	d.soakingTag = ""
 
-	d.logger.Printf("New version available: %s (current: %s)", release.TagName, currentVersion)
+	if release.TagName == d.stagedTag {
+		d.logger.Debug("Staged version is waiting to be switched to", "tag", release.TagName)
+	} else {
+		d.logger.Info("New version available", "tag", release.TagName, "current", currentVersion)
+	}
 
 	if d.dryRun {
-		d.logger.Printf("DRY RUN: Would deploy version %s", release.TagName)
+		// Plan each new release once rather than downloading it every check
+		if release.TagName == d.plannedTag {
+			return nil
+		}
+		if err := d.logPlan(ctx, release); err != nil {
+			return err
+		}
+		d.plannedTag = release.TagName
 		return nil
 	}
 
-	return d.deploy(ctx, release)
+	return d.withDeployLock(func() error {
+		// Another process may have deployed it while we waited for the lock
+		if release.TagName == d.getCurrentVersion() {
+			d.logger.Info("Version was deployed by another process", "tag", release.TagName)
+			return nil
+		}
+		if d.state.Paused {
+			d.logger.Info("Deployments were paused by another process, skipping", "tag", release.TagName)
+			return nil
+		}
+		return d.deploy(ctx, release, true)
+	})
 }
 
-// getCurrentVersion gets the currently deployed version
-func (d *Deployer) getCurrentVersion() string {
-	if d.state.ActiveSlot == "blue" {
-		return d.state.BlueVersion
+// DeployTag deploys a specific release tag, even while automatic
+// deployments are paused, outside the deploy window or the release is not
+// due under min_release_age and rollout, but not while they are frozen
+func (d *Deployer) DeployTag(ctx context.Context, tag string) error {
+	if frozen, reason := readFreeze(d.config.FreezeFile); frozen {
+		return freezeError(reason)
 	}
-	return d.state.GreenVersion
-}
 
-// deploy performs the actual deployment
-func (d *Deployer) deploy(ctx context.Context, release *Release) error {
-	inactiveSlot := d.state.GetInactiveSlot()
-	d.logger.Printf("Starting deployment of %s to %s slot", release.TagName, inactiveSlot)
-
-	// Find the asset to download
-	asset, err := release.FindAssetWithSuffix(d.config.AssetSuffix)
+	release, err := d.github.GetReleaseByTag(ctx, d.config.Repo, tag)
 	if err != nil {
-		return fmt.Errorf("failed to find asset: %w", err)
+		return fmt.Errorf("failed to get release %s: %w", tag, err)
 	}
 
-	// Create deployment directory
-	deploymentDir := filepath.Join(d.config.InstallDir, inactiveSlot)
-	if err := os.MkdirAll(deploymentDir, 0o755); err != nil {
-		return fmt.Errorf("failed to create deployment directory: %w", err)
+	if d.dryRun {
+		return d.logPlan(ctx, release)
 	}
 
-	// Download and extract
-	assetPath := filepath.Join(deploymentDir, asset.Name)
-	if err := d.github.DownloadAsset(ctx, asset, assetPath); err != nil {
-		return fmt.Errorf("failed to download asset: %w", err)
+	return d.withDeployLock(func() error {
+		if release.TagName == d.getCurrentVersion() {
+			d.logger.Info("Version is already deployed", "tag", release.TagName)
+			return nil
+		}
+		return d.deploy(ctx, release, false)
+	})
+}
+
+// releaseEligibleAt returns when a release is old enough to be deployed
+// automatically. A release without a publication time is always eligible.
+func (d *Deployer) releaseEligibleAt(release *Release) time.Time {
+	if release.PublishedAt.IsZero() || d.config.MinReleaseAge == "" {
+		return time.Time{}
 	}
+	// Validation rejects a min_release_age that doesn't parse
+	age, _ := time.ParseDuration(d.config.MinReleaseAge)
+	return release.PublishedAt.Add(age)
+}
 
-	// Optional checksum verification
-	if d.config.VerifyChecksums {
-		d.logger.Printf("Checksum verification enabled, looking for checksums asset")
-		// Try to find a checksums file in release assets
-		var checksumsAsset *Asset
-		for _, a := range release.Assets {
-			if strings.HasPrefix(a.Name, strings.TrimSuffix(asset.Name, filepath.Ext(asset.Name))) && strings.Contains(a.Name, "checksums") {
-				checksumsAsset = &a
-				break
-			}
+// SetPaused pauses or resumes automatic deployments
+func (d *Deployer) SetPaused(paused bool) error {
+	if d.dryRun {
+		d.logger.Info("DRY RUN: Would change paused state", "paused", paused)
+		return nil
+	}
+
+	return d.withDeployLock(func() error {
+		d.state.Paused = paused
+		if err := d.state.SaveState(d.config.StateFile); err != nil {
+			return fmt.Errorf("failed to save state: %w", err)
 		}
-		if checksumsAsset != nil {
-			checksumPath := filepath.Join(deploymentDir, checksumsAsset.Name)
-			if err := d.github.DownloadAsset(ctx, checksumsAsset, checksumPath); err != nil {
-				return fmt.Errorf("failed to download checksums asset: %w", err)
-			}
-			m, err := ParseChecksums(checksumPath)
-			if err != nil {
-				return fmt.Errorf("failed to parse checksums: %w", err)
-			}
-			// lookup by base name
-			base := filepath.Base(asset.Name)
-			if expected, ok := m[base]; ok {
-				if err := VerifyFileSHA256(assetPath, expected); err != nil {
-					return fmt.Errorf("checksum verification failed: %w", err)
-				}
-				d.logger.Printf("Checksum verification passed for %s", base)
-			} else {
-				return fmt.Errorf("no checksum entry found for %s", base)
-			}
+		d.metrics.SetActive(d.getCurrentVersion(), d.state.ActiveSlot, paused)
+		if paused {
+			d.logger.Info("Automatic deployments paused")
 		} else {
-			d.logger.Printf("VERIFY_CHECKSUMS set but no checksums asset found; aborting")
-			return errors.New("checksums verification requested but no checksums asset found")
+			d.logger.Info("Automatic deployments resumed")
 		}
+		return nil
+	})
+}
+
+// recordHistory adds an entry for a finished deployment or rollback and
+// saves the state
+func (d *Deployer) recordHistory(entry HistoryEntry, err error) {
+	entry.Time = time.Now().UTC()
+	entry.Success = err == nil
+	if err != nil {
+		entry.Error = err.Error()
 	}
+	d.state.AddHistory(entry)
+	d.metrics.SetActive(d.getCurrentVersion(), d.state.ActiveSlot, d.state.Paused)
+	if saveErr := d.state.SaveState(d.config.StateFile); saveErr != nil {
+		d.logger.Warn("Failed to record history", "error", saveErr)
+	}
+}
 
-	// Extract archive based on extension
-	d.logger.Printf("Extracting asset: %s", asset.Name)
-	var extractErr error
-	if strings.HasSuffix(asset.Name, ".tar.gz") || strings.HasSuffix(asset.Name, ".tgz") {
-		extractErr = ExtractTarGz(assetPath, deploymentDir)
-	} else if strings.HasSuffix(asset.Name, ".zip") {
-		extractErr = ExtractZip(assetPath, deploymentDir)
+// getCurrentVersion gets the currently deployed version
+func (d *Deployer) getCurrentVersion() string {
+	return d.slotVersion(d.state.ActiveSlot)
+}
+
+// deploy performs the actual deployment and runs the on_failure hook if it
+// fails. A scheduled deployment is subject to the freeze file and deploy
+// window. The caller must hold the deployment lock.
+func (d *Deployer) deploy(ctx context.Context, release *Release, scheduled bool) error {
+	inactiveSlot := d.state.GetInactiveSlot()
+	hc := &HookContext{
+		Tag:          release.TagName,
+		Slot:         inactiveSlot,
+		Dir:          d.slotDir(inactiveSlot),
+		PreviousTag:  d.getCurrentVersion(),
+		PreviousSlot: d.state.ActiveSlot,
+	}
+
+	// Every line logged for this deployment carries its tag and slot
+	log := d.logger.With("tag", release.TagName, "slot", inactiveSlot)
+	ctx = withLogger(ctx, log)
+
+	start := time.Now()
+	wasStaged := d.stagedTag == release.TagName
+	err := d.deployRelease(ctx, release, hc, scheduled)
+	if errors.Is(err, errDeployDeferred) {
+		// Not a deployment yet: the release waits in the inactive slot
+		if wasStaged {
+			log.Debug("Switch to staged release deferred", "reason", err)
+		} else {
+			log.Info("Staged release, switch deferred", "reason", err, durationMS(time.Since(start)))
+		}
+		return nil
+	}
+	if err != nil {
+		log.Error("Deployment failed", "error", err, durationMS(time.Since(start)))
 	} else {
-		// Not an archive; assume it's a binary. Nothing to extract.
-		d.logger.Printf("Asset is not an archive, skipping extraction")
-		extractErr = nil
+		log.Info("Deployment completed successfully", durationMS(time.Since(start)))
 	}
-	if extractErr != nil {
-		return fmt.Errorf("failed to extract archive: %w", extractErr)
+	d.metrics.DeploymentFinished(err)
+	d.recordHistory(HistoryEntry{
+		Action:      "deploy",
+		Tag:         release.TagName,
+		Slot:        inactiveSlot,
+		PreviousTag: hc.PreviousTag,
+	}, err)
+	d.notifyDeploy(release, hc, err)
+	if err != nil {
+		hc.Error = err.Error()
+		if hookErr := d.runHook(ctx, hookOnFailure, hc); hookErr != nil {
+			log.Warn("Hook failed", "phase", hookOnFailure, "error", hookErr)
+		}
 	}
+	return err
+}
 
-	// Run install command if configured (e.g., poetry install)
-	if d.config.RunCommand != "" {
-		d.logger.Printf("Running install command: %s", d.config.RunCommand)
-		if err := runCommand(deploymentDir, d.config.RunCommand); err != nil {
-			return fmt.Errorf("run command failed: %w", err)
+// deployRelease prepares a release in the inactive slot and switches to it.
+// A scheduled deployment that may not switch yet leaves the release staged
+// and returns errDeployDeferred.
+func (d *Deployer) deployRelease(ctx context.Context, release *Release, hc *HookContext, scheduled bool) error {
+	inactiveSlot := hc.Slot
+	log := d.log(ctx)
+
+	staged := d.isStaged(release.TagName, hc.Dir)
+	if staged {
+		log.Debug("Release is already staged", "previous_tag", hc.PreviousTag)
+	} else {
+		log.Info("Starting deployment", "previous_tag", hc.PreviousTag)
+		d.stagedTag = ""
+		if err := d.prepareRelease(ctx, release, hc); err != nil {
+			return err
+		}
+	}
+
+	if scheduled {
+		if reason := d.deferReason(time.Now()); reason != "" {
+			// Recorded in the slot so a restart does not mistake the
+			// release for one that was deployed
+			if !staged {
+				if err := setSlotStaged(hc.Dir, true); err != nil {
+					return fmt.Errorf("failed to mark release as staged: %w", err)
+				}
+			}
+			d.stagedTag = release.TagName
+			d.setStatus("Staged %s in %s slot, waiting: %s", release.TagName, inactiveSlot, reason)
+			return fmt.Errorf("%w: %s", errDeployDeferred, reason)
 		}
-		d.logger.Printf("Install command completed successfully")
 	}
+	d.stagedTag = ""
 
 	// Health check if configured
 	if d.config.HealthCheckURL != "" {
-		d.logger.Printf("Performing health check on %s", d.config.HealthCheckURL)
+		d.setStatus("Health checking %s", release.TagName)
+		log.Info("Performing health check", "phase", "health_check", "url", d.config.HealthCheckURL)
+		checkStart := time.Now()
 		if err := performHealthCheck(d.config.HealthCheckURL, time.Duration(d.config.HealthCheckTimeout)*time.Second); err != nil {
-			return fmt.Errorf("health check failed: %w", err)
+			return fmt.Errorf("%w: %w", errHealthCheckFailed, err)
 		}
-		d.logger.Printf("Health check passed")
+		log.Info("Health check passed", "phase", "health_check", durationMS(time.Since(checkStart)))
 	}
 
+	if err := d.runHook(ctx, hookPreSwitch, hc); err != nil {
+		return err
+	}
+	if err := setSlotStaged(hc.Dir, false); err != nil {
+		return fmt.Errorf("failed to update slot manifest: %w", err)
+	}
+
+	// Stop the supervised application while the slot changes under it
+	d.setStatus("Switching to %s", release.TagName)
+	d.supervisor.Stop()
+
 	// Atomically switch symlink
-	d.logger.Printf("Switching symlink %s to %s", d.config.CurrentSymlink, deploymentDir)
-	if err := switchSymlink(d.config.CurrentSymlink, deploymentDir); err != nil {
+	log.Info("Switching symlink", "phase", "switch", "symlink", d.config.CurrentSymlink, "target", hc.Dir)
+	if err := switchSymlink(d.config.CurrentSymlink, hc.Dir); err != nil {
+		d.supervisor.Start()
 		return fmt.Errorf("failed to switch symlink: %w", err)
 	}
 
 	// Update state and save
-	if d.state.ActiveSlot == "blue" {
-		d.state.GreenVersion = release.TagName
-	} else {
-		d.state.BlueVersion = release.TagName
-	}
+	d.setSlotVersion(inactiveSlot, release.TagName)
 	d.state.SwitchSlot()
-	if err := d.state.SaveState(d.config.StateFile); err != nil {
-		return fmt.Errorf("failed to save state: %w", err)
+	saveErr := d.state.SaveState(d.config.StateFile)
+	d.supervisor.Start()
+	if saveErr != nil {
+		return fmt.Errorf("failed to save state: %w", saveErr)
 	}
 
-	// Run post-deploy script if configured
-	if d.config.PostDeployScript != "" {
-		d.logger.Printf("Running post-deploy script: %s", d.config.PostDeployScript)
-		if err := runCommand("/", d.config.PostDeployScript); err != nil {
-			d.logger.Printf("Warning: post-deploy script failed: %v", err)
-		} else {
-			d.logger.Printf("Post-deploy script completed successfully")
+	// Restart services onto the new release; a unit that fails to come up
+	// fails the deployment
+	if err := d.restartUnits(ctx); err != nil {
+		if !d.config.Restart.RollbackOnFailure {
+			return fmt.Errorf("service restart failed: %w", err)
 		}
+		log.Error("Service restart failed, rolling back", "phase", "restart", "error", err)
+		if rbErr := d.rollback(ctx); rbErr != nil {
+			return fmt.Errorf("service restart failed: %w; rollback also failed: %v", err, rbErr)
+		}
+		return fmt.Errorf("service restart failed, rolled back to %s: %w", d.getCurrentVersion(), err)
 	}
 
-	d.logger.Printf("Deployment of %s to %s slot completed successfully", release.TagName, inactiveSlot)
+	// The new version is live; later hook failures are only warnings
+	for _, phase := range []string{hookPostSwitch, hookPostDeploy} {
+		if err := d.runHook(ctx, phase, hc); err != nil {
+			log.Warn("Hook failed", "phase", phase, "error", err)
+		}
+	}
+	return nil
+}
+
+// prepareRelease downloads, extracts and installs a release in the inactive
+// slot, ready to be switched to
+func (d *Deployer) prepareRelease(ctx context.Context, release *Release, hc *HookContext) error {
+	inactiveSlot := hc.Slot
+
+	// Find the asset to download
+	asset, err := release.FindAssetWithSuffix(d.config.AssetSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to find asset: %w", err)
+	}
+
+	// Never write into the slot that is actually serving
+	if err := d.ensureSlotNotLive(inactiveSlot); err != nil {
+		return err
+	}
+
+	// Create deployment directory
+	deploymentDir := hc.Dir
+	if err := os.MkdirAll(deploymentDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create deployment directory: %w", err)
+	}
+	if err := removeSlotManifest(deploymentDir); err != nil {
+		return fmt.Errorf("failed to clear slot manifest: %w", err)
+	}
+
+	if err := d.runHook(ctx, hookPreDownload, hc); err != nil {
+		return err
+	}
+
+	// Download and extract
+	d.setStatus("Downloading %s (%s)", release.TagName, asset.Name)
+	assetPath, err := d.downloadAsset(ctx, release, asset, deploymentDir)
+	if err != nil {
+		return err
+	}
+	d.setStatus("Extracting %s into %s slot", release.TagName, inactiveSlot)
+	if err := d.extractAsset(ctx, assetPath, deploymentDir); err != nil {
+		return err
+	}
+
+	// Bring in persistent files before anything runs in the slot
+	if err := d.linkSharedPaths(ctx, deploymentDir); err != nil {
+		return fmt.Errorf("failed to link shared paths: %w", err)
+	}
+
+	if err := d.runHook(ctx, hookPostExtract, hc); err != nil {
+		return err
+	}
+
+	// Run install command if configured (e.g., poetry install)
+	d.setStatus("Installing %s", release.TagName)
+	if err := d.runHook(ctx, hookInstall, hc); err != nil {
+		return fmt.Errorf("run command failed: %w", err)
+	}
+
+	// Record what this slot now holds
+	manifest := &SlotManifest{
+		Tag:        release.TagName,
+		Slot:       inactiveSlot,
+		Asset:      asset.Name,
+		DeployedAt: time.Now().UTC(),
+	}
+	if err := writeSlotManifest(deploymentDir, manifest); err != nil {
+		return fmt.Errorf("failed to write slot manifest: %w", err)
+	}
+	return nil
+}
+
+// isStaged reports whether release tag was staged in the slot at dir and is
+// still there
+func (d *Deployer) isStaged(tag, dir string) bool {
+	if d.stagedTag != tag {
+		return false
+	}
+	manifest, err := readSlotManifest(dir)
+	return err == nil && manifest.Staged && manifest.Tag == tag
+}
+
+// downloadAsset downloads a release asset into dir and verifies its
+// checksum if configured, returning the path of the downloaded asset
+func (d *Deployer) downloadAsset(ctx context.Context, release *Release, asset *Asset, dir string) (string, error) {
+	log := d.log(ctx)
+	assetPath := filepath.Join(dir, asset.Name)
+	log.Info("Downloading asset", "phase", "download", "asset", asset.Name)
+	downloadStart := time.Now()
+	if err := d.github.DownloadAsset(ctx, asset, assetPath); err != nil {
+		return "", fmt.Errorf("failed to download asset: %w", err)
+	}
+	if info, err := os.Stat(assetPath); err == nil {
+		elapsed := time.Since(downloadStart)
+		d.metrics.Downloaded(info.Size(), elapsed)
+		log.Info("Downloaded asset", "phase", "download", "asset", asset.Name, "bytes", info.Size(), durationMS(elapsed))
+	}
+
+	// Optional checksum verification
+	if !d.config.VerifyChecksums {
+		return assetPath, nil
+	}
+	log.Debug("Checksum verification enabled, looking for checksums asset", "phase", "verify")
+	// Try to find a checksums file in release assets
+	var checksumsAsset *Asset
+	for _, a := range release.Assets {
+		if strings.HasPrefix(a.Name, strings.TrimSuffix(asset.Name, filepath.Ext(asset.Name))) && strings.Contains(a.Name, "checksums") {
+			checksumsAsset = &a
+			break
+		}
+	}
+	if checksumsAsset == nil {
+		return "", errors.New("checksums verification requested but no checksums asset found")
+	}
+	checksumPath := filepath.Join(dir, checksumsAsset.Name)
+	if err := d.github.DownloadAsset(ctx, checksumsAsset, checksumPath); err != nil {
+		return "", fmt.Errorf("failed to download checksums asset: %w", err)
+	}
+	m, err := ParseChecksums(checksumPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse checksums: %w", err)
+	}
+	// lookup by base name
+	base := filepath.Base(asset.Name)
+	expected, ok := m[base]
+	if !ok {
+		return "", fmt.Errorf("no checksum entry found for %s", base)
+	}
+	if err := VerifyFileSHA256(assetPath, expected); err != nil {
+		return "", fmt.Errorf("checksum verification failed: %w", err)
+	}
+	log.Info("Checksum verification passed", "phase", "verify", "asset", base)
+	return assetPath, nil
+}
+
+// extractAsset extracts an archive asset into dir based on its extension.
+// Any other asset is assumed to be a binary and left as it is.
+func (d *Deployer) extractAsset(ctx context.Context, assetPath, dir string) error {
+	log := d.log(ctx)
+	name := filepath.Base(assetPath)
+	log.Info("Extracting asset", "phase", "extract", "asset", name)
+	var err error
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		err = ExtractTarGz(assetPath, dir)
+	case strings.HasSuffix(name, ".zip"):
+		err = ExtractZip(assetPath, dir)
+	default:
+		log.Info("Asset is not an archive, skipping extraction", "phase", "extract")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract archive: %w", err)
+	}
 	return nil
 }
 
 // Rollback performs a rollback to the previous version
-func (d *Deployer) Rollback() error {
+func (d *Deployer) Rollback(ctx context.Context) error {
+	if d.dryRun {
+		d.logger.Info("DRY RUN: Would rollback",
+			"slot", d.state.ActiveSlot, "tag", d.getCurrentVersion(), "to_slot", d.state.GetInactiveSlot())
+		return nil
+	}
+
+	return d.withDeployLock(func() error {
+		return d.rollback(ctx)
+	})
+}
+
+// rollback switches back to the previous slot and records the outcome. The
+// caller must hold the deployment lock.
+func (d *Deployer) rollback(ctx context.Context) error {
+	entry := HistoryEntry{
+		Action:      "rollback",
+		Tag:         d.slotVersion(d.state.GetInactiveSlot()),
+		Slot:        d.state.GetInactiveSlot(),
+		PreviousTag: d.getCurrentVersion(),
+	}
+	log := d.logger.With("action", "rollback", "tag", entry.Tag, "slot", entry.Slot)
+	ctx = withLogger(ctx, log)
+
+	start := time.Now()
+	err := d.switchBack(ctx)
+	if err != nil {
+		log.Error("Rollback failed", "error", err, durationMS(time.Since(start)))
+	} else {
+		log.Info("Rollback completed", durationMS(time.Since(start)))
+	}
+	d.metrics.RollbackFinished()
+	d.recordHistory(entry, err)
+
+	n := Notification{Event: eventRollback, App: d.config.Name, Repo: d.config.Repo, Tag: entry.Tag, PreviousTag: entry.PreviousTag, Slot: entry.Slot}
+	if err != nil {
+		n.Error = err.Error()
+	}
+	d.notifier.Notify(n)
+	return err
+}
+
+// notifyDeploy sends the notification for a finished deployment
+func (d *Deployer) notifyDeploy(release *Release, hc *HookContext, err error) {
+	n := Notification{
+		Event:        eventDeploySuccess,
+		App:          d.config.Name,
+		Repo:         d.config.Repo,
+		Tag:          release.TagName,
+		PreviousTag:  hc.PreviousTag,
+		Slot:         hc.Slot,
+		ReleaseNotes: releaseNotesExcerpt(release.Body),
+	}
+	if err != nil {
+		n.Event = eventDeployFailure
+		if errors.Is(err, errHealthCheckFailed) {
+			n.Event = eventHealthCheckFailure
+		}
+		n.Error = err.Error()
+	}
+	d.notifier.Notify(n)
+}
+
+// switchBack performs the rollback to the previous slot
+func (d *Deployer) switchBack(ctx context.Context) error {
 	currentSlot := d.state.ActiveSlot
 	previousSlot := d.state.GetInactiveSlot()
-	previousVersion := d.getCurrentVersion()
+	currentVersion := d.getCurrentVersion()
+	log := d.log(ctx)
 
-	d.logger.Printf("Starting rollback from %s slot (version %s) to %s slot",
-		currentSlot, previousVersion, previousSlot)
+	log.Info("Starting rollback", "previous_tag", currentVersion, "previous_slot", currentSlot)
+	d.setStatus("Rolling back to %s slot", previousSlot)
 
-	if d.dryRun {
-		d.logger.Printf("DRY RUN: Would rollback to %s slot", previousSlot)
-		return nil
+	// Refuse to point the symlink at a slot that no longer exists
+	previousDir := d.slotDir(previousSlot)
+	if _, err := os.Stat(previousDir); err != nil {
+		return fmt.Errorf("cannot rollback to %s slot: %w", previousSlot, err)
+	}
+
+	// The previous release was replaced by one waiting for the deploy window
+	if manifest, err := readSlotManifest(previousDir); err == nil && manifest.Staged {
+		return fmt.Errorf("cannot rollback: the %s slot holds staged release %s", previousSlot, manifest.Tag)
 	}
 
 	// Switch back to previous slot
+	d.supervisor.Stop()
 	d.state.SwitchSlot()
 
 	// Update symlink to point to previous slot
-	previousDir := filepath.Join(d.config.InstallDir, previousSlot)
 	if err := switchSymlink(d.config.CurrentSymlink, previousDir); err != nil {
+		d.state.SwitchSlot()
+		d.supervisor.Start()
 		return fmt.Errorf("failed to switch symlink during rollback: %w", err)
 	}
 
 	// Save state
-	if err := d.state.SaveState(d.config.StateFile); err != nil {
-		return fmt.Errorf("failed to save state during rollback: %w", err)
+	saveErr := d.state.SaveState(d.config.StateFile)
+	d.supervisor.Start()
+	if saveErr != nil {
+		return fmt.Errorf("failed to save state during rollback: %w", saveErr)
+	}
+
+	if err := d.restartUnits(ctx); err != nil {
+		return fmt.Errorf("service restart failed during rollback: %w", err)
 	}
 
-	// Run post-deploy script if configured
-	if d.config.PostDeployScript != "" {
-		d.logger.Printf("Running post-deploy script after rollback")
-		if err := runCommand("/", d.config.PostDeployScript); err != nil {
-			d.logger.Printf("Warning: post-deploy script failed during rollback: %v", err)
+	hc := &HookContext{
+		Tag:          d.getCurrentVersion(),
+		Slot:         previousSlot,
+		Dir:          previousDir,
+		PreviousTag:  currentVersion,
+		PreviousSlot: currentSlot,
+	}
+	for _, phase := range []string{hookPostRollback, hookPostDeploy} {
+		if err := d.runHook(ctx, phase, hc); err != nil {
+			log.Warn("Hook failed during rollback", "phase", phase, "error", err)
 		}
 	}
 
 	// Validate rollback with health check if configured
 	if d.config.HealthCheckURL != "" {
-		d.logger.Printf("Validating rollback with health check")
+		log.Info("Validating rollback with health check", "phase", "health_check")
 		if err := performHealthCheck(d.config.HealthCheckURL, time.Duration(d.config.HealthCheckTimeout)*time.Second); err != nil {
 			return fmt.Errorf("rollback validation failed: %w", err)
 		}
 	}
-
-	d.logger.Printf("Rollback completed to %s slot", previousSlot)
 	return nil
 }
 
-// runCommand runs a shell command in the specified working directory
-func runCommand(workingDir string, command string) error {
-	// Use 'sh -c' for portability
-	cmd := exec.Command("sh", "-c", command)
-	cmd.Dir = workingDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
+// errHealthCheckFailed marks a deployment that failed its health check
+var errHealthCheckFailed = errors.New("health check failed")
 
 // performHealthCheck polls the health endpoint until timeout
 func performHealthCheck(url string, timeout time.Duration) error {
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Default email templates, used unless notifications.email overrides them
+const (
+	defaultEmailSubject = "[gh-deployer] {{.Event}}: {{.Repo}} {{.Tag}} on {{.Host}}"
+	defaultEmailBody    = `{{.Message}}
+
+Repository:   {{.Repo}}
+Host:         {{.Host}}
+Tag:          {{.Tag}}
+Previous tag: {{.PreviousTag}}
+Slot:         {{.Slot}}
+Time:         {{.Time.Format "2006-01-02 15:04:05 MST"}}
+{{- if .Error}}
+Error:        {{.Error}}
+{{- end}}
+{{- if .ReleaseNotes}}
+
+Release notes:
+{{.ReleaseNotes}}
+{{- end}}
+`
+)
+
+// emailSink sends notifications through an SMTP relay
+type emailSink struct {
+	host     string
+	port     int
+	tls      string
+	username string
+	password string
+	from     string
+	to       []string
+	subject  *template.Template
+	body     *template.Template
+}
+
+// newEmailSink validates an email configuration
+func newEmailSink(cfg EmailConfig) (*emailSink, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("host is required")
+	}
+	if cfg.From == "" || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("from and to are required")
+	}
+	s := &emailSink{
+		host:     cfg.Host,
+		port:     cfg.Port,
+		tls:      cfg.TLS,
+		username: cfg.Username,
+		password: cfg.Password,
+		from:     cfg.From,
+		to:       cfg.To,
+	}
+	if s.tls == "" {
+		s.tls = "starttls"
+	}
+	switch s.tls {
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("unknown tls mode %q", cfg.TLS)
+	}
+	if s.port == 0 {
+		s.port = 587
+		if s.tls == "tls" {
+			s.port = 465
+		}
+	}
+
+	subject, body := defaultEmailSubject, defaultEmailBody
+	if cfg.Subject != "" {
+		subject = cfg.Subject
+	}
+	if cfg.Body != "" {
+		body = cfg.Body
+	}
+	var err error
+	if s.subject, err = template.New("subject").Parse(subject); err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+	if s.body, err = template.New("body").Parse(body); err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+	return s, nil
+}
+
+// Name identifies the sink in logs
+func (s *emailSink) Name() string {
+	return "email " + s.host
+}
+
+// Send delivers the notification to every recipient in one message
+func (s *emailSink) Send(ctx context.Context, n *Notification) error {
+	msg, err := s.message(n)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: s.host}
+	if s.tls == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = c.Close() }()
+
+	if s.tls == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if s.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	for _, to := range s.to {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		_ = w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message renders the headers and quoted-printable body of the email
+func (s *emailSink) message(n *Notification) ([]byte, error) {
+	var subject, body bytes.Buffer
+	if err := s.subject.Execute(&subject, n); err != nil {
+		return nil, fmt.Errorf("failed to render subject: %w", err)
+	}
+	if err := s.body.Execute(&body, n); err != nil {
+		return nil, fmt.Errorf("failed to render body: %w", err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write(body.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
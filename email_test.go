@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpMessage is a message received by fakeSMTPServer
+type smtpMessage struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTPServer is a minimal SMTP server that accepts every message,
+// advertising PLAIN authentication and, optionally, STARTTLS
+type fakeSMTPServer struct {
+	listener net.Listener
+	startTLS bool
+
+	mu       sync.Mutex
+	messages []smtpMessage
+}
+
+func newFakeSMTPServer(t *testing.T, startTLS bool) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &fakeSMTPServer{listener: listener, startTLS: startTLS}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) received() []smtpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpMessage(nil), s.messages...)
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	tp := textproto.NewConn(conn)
+	var msg smtpMessage
+	_ = tp.PrintfLine("220 localhost ready")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			_ = tp.PrintfLine("250-localhost")
+			if s.startTLS {
+				_ = tp.PrintfLine("250-STARTTLS")
+			}
+			_ = tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			msg.auth = arg
+			_ = tp.PrintfLine("235 ok")
+		case "MAIL":
+			msg.from = arg
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			msg.to = append(msg.to, arg)
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			_ = tp.PrintfLine("250 queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestEmailSinkSendsTemplatedMessage(t *testing.T) {
+	server := newFakeSMTPServer(t, false)
+	sink, err := newEmailSink(EmailConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		TLS:      "none",
+		Username: "deployer",
+		Password: "secret",
+		From:     "deployer@example.com",
+		To:       []string{"ops@example.com", "dev@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("newEmailSink failed: %v", err)
+	}
+
+	n := &Notification{
+		Event:        eventDeploySuccess,
+		Repo:         "owner/app",
+		Tag:          "v2.0.0",
+		PreviousTag:  "v1.0.0",
+		Slot:         "green",
+		Host:         "web-1",
+		ReleaseNotes: "Fixes the café menu",
+		Time:         time.Now(),
+		Message:      "Deployed owner/app v2.0.0 to the green slot on web-1",
+	}
+	if err := sink.Send(context.Background(), n); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(messages))
+	}
+	msg := messages[0]
+	if msg.from != "FROM:<deployer@example.com>" {
+		t.Errorf("Unexpected sender %q", msg.from)
+	}
+	if len(msg.to) != 2 || msg.to[1] != "TO:<dev@example.com>" {
+		t.Errorf("Unexpected recipients %q", msg.to)
+	}
+	wantAuth := "PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00deployer\x00secret"))
+	if msg.auth != wantAuth {
+		t.Errorf("Unexpected AUTH %q", msg.auth)
+	}
+
+	// DotReader has already turned CRLF line endings into LF
+	header, body, _ := strings.Cut(msg.data, "\n\n")
+	var subject string
+	for _, line := range strings.Split(header, "\n") {
+		if value, ok := strings.CutPrefix(line, "Subject: "); ok {
+			subject, _ = new(mime.WordDecoder).DecodeHeader(value)
+		}
+	}
+	if subject != "[gh-deployer] deploy_success: owner/app v2.0.0 on web-1" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatalf("Invalid quoted-printable body: %v", err)
+	}
+	for _, want := range []string{n.Message, "Previous tag: v1.0.0", "Release notes:\nFixes the café menu"} {
+		if !strings.Contains(string(decoded), want) {
+			t.Errorf("Expected body to contain %q, got:\n%s", want, decoded)
+		}
+	}
+}
+
+func TestEmailSinkRequiresStartTLS(t *testing.T) {
+	server := newFakeSMTPServer(t, false)
+	sink, err := newEmailSink(EmailConfig{
+		Host: "127.0.0.1",
+		Port: server.port(),
+		From: "deployer@example.com",
+		To:   []string{"ops@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("newEmailSink failed: %v", err)
+	}
+	err = sink.Send(context.Background(), &Notification{Event: eventRollback})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Expected a STARTTLS error, got %v", err)
+	}
+	if len(server.received()) != 0 {
+		t.Error("Expected no message to be sent without STARTTLS")
+	}
+}
+
+func TestNewEmailSinkValidation(t *testing.T) {
+	valid := EmailConfig{Host: "smtp.example.com", From: "a@example.com", To: []string{"b@example.com"}}
+	sink, err := newEmailSink(valid)
+	if err != nil {
+		t.Fatalf("newEmailSink failed: %v", err)
+	}
+	if sink.port != 587 || sink.tls != "starttls" {
+		t.Errorf("Expected STARTTLS on 587 by default, got %s on %d", sink.tls, sink.port)
+	}
+	implicit := valid
+	implicit.TLS = "tls"
+	if sink, _ := newEmailSink(implicit); sink.port != 465 {
+		t.Errorf("Expected port 465 for implicit TLS, got %d", sink.port)
+	}
+
+	for name, mutate := range map[string]func(*EmailConfig){
+		"missing recipients": func(c *EmailConfig) { c.To = nil },
+		"unknown tls":        func(c *EmailConfig) { c.TLS = "ssl3" },
+		"bad subject":        func(c *EmailConfig) { c.Subject = "{{.Tag" },
+	} {
+		cfg := valid
+		mutate(&cfg)
+		if _, err := newEmailSink(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestReleaseNotesExcerpt(t *testing.T) {
+	if got := releaseNotesExcerpt("  short notes\r\n"); got != "short notes" {
+		t.Errorf("Unexpected excerpt %q", got)
+	}
+	long := strings.Repeat("- a change worth mentioning\n", 100)
+	got := releaseNotesExcerpt(long)
+	if len(got) > releaseNotesLimit+len("\n…") || !strings.HasSuffix(got, "mentioning\n…") {
+		t.Errorf("Expected the excerpt to end on a whole line, got %d bytes ending %q", len(got), got[len(got)-20:])
+	}
+}
+
+func TestNotifierSendsEmail(t *testing.T) {
+	server := newFakeSMTPServer(t, false)
+	n := newTestNotifier(t, NotificationsConfig{Email: EmailConfig{
+		Host:   "127.0.0.1",
+		Port:   server.port(),
+		TLS:    "none",
+		From:   "deployer@example.com",
+		To:     []string{"ops@example.com"},
+		Events: []string{eventDeployFailure},
+		Body:   "{{.Message}} (custom body)",
+	}})
+	n.Notify(Notification{Event: eventDeploySuccess, Tag: "v2.0.0"})
+	n.Notify(Notification{Event: eventDeployFailure, Tag: "v2.0.0", Error: "boom"})
+	n.Close()
+
+	messages := server.received()
+	if len(messages) != 1 || !strings.Contains(messages[0].data, "boom (custom body)") {
+		t.Errorf("Expected only the failure email, got %+v", messages)
+	}
+}
@@ -3,55 +3,151 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// rateLimitBackoff is how long to stop calling the API after being rate
+// limited without being told when the limit resets
+const rateLimitBackoff = time.Minute
+
+// errRateLimited is returned while the API quota is exhausted
+var errRateLimited = errors.New("rate limited by GitHub API")
+
 // GitHubClient handles GitHub API interactions
 type GitHubClient struct {
-	token  string
 	client *http.Client
+	logger *slog.Logger
+
+	// tokenMu guards token, which a config reload may replace
+	tokenMu sync.Mutex
+	token   string
+
+	// rateLimitRemaining is the last X-RateLimit-Remaining seen, or -1
+	rateLimitRemaining atomic.Int64
+
+	// rateLimitReset is the Unix time until which the API quota is
+	// exhausted, or 0. Every app sharing the client waits for it.
+	rateLimitReset atomic.Int64
 }
 
 // Release represents a GitHub release
 type Release struct {
-	TagName string  `json:"tag_name"`
-	Assets  []Asset `json:"assets"`
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	Body        string    `json:"body"` // release notes
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []Asset   `json:"assets"`
 }
 
 // Asset represents a GitHub release asset
 type Asset struct {
-	Name               string `json:"name"`
-	BrowserDownloadURL string `json:"browser_download_url"`
+	ID                 int64     `json:"id"`
+	Name               string    `json:"name"`
+	BrowserDownloadURL string    `json:"browser_download_url"`
+	UpdatedAt          time.Time `json:"updated_at"`
 }
 
-// NewGitHubClient creates a new GitHub client
-func NewGitHubClient(token string) *GitHubClient {
-	return &GitHubClient{
+// NewGitHubClient creates a new GitHub client that logs through logger
+func NewGitHubClient(token string, logger *slog.Logger) *GitHubClient {
+	c := &GitHubClient{
 		token: token,
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		logger: logger,
+	}
+	c.rateLimitRemaining.Store(-1)
+	return c
+}
+
+// SetToken replaces the token used for subsequent requests
+func (c *GitHubClient) SetToken(token string) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.token = token
+}
+
+// authorize adds the token, if there is one, to req
+func (c *GitHubClient) authorize(req *http.Request) {
+	c.tokenMu.Lock()
+	token := c.token
+	c.tokenMu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+}
+
+// RateLimitRemaining returns the remaining API quota reported by the last
+// API response, or -1 if none has been seen
+func (c *GitHubClient) RateLimitRemaining() int {
+	return int(c.rateLimitRemaining.Load())
+}
+
+// RateLimitedUntil reports whether the API quota is exhausted, and when it
+// resets
+func (c *GitHubClient) RateLimitedUntil() (time.Time, bool) {
+	reset := c.rateLimitReset.Load()
+	if reset == 0 {
+		return time.Time{}, false
+	}
+	until := time.Unix(reset, 0)
+	return until, time.Now().Before(until)
+}
+
+// trackRateLimit records the quota reported by an API response
+func (c *GitHubClient) trackRateLimit(resp *http.Response) {
+	exhausted := false
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		c.rateLimitRemaining.Store(int64(remaining))
+		exhausted = remaining == 0
+	}
+	limited := resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests
+	if !exhausted && !limited {
+		return
+	}
+	reset := time.Now().Add(rateLimitBackoff).Unix()
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		reset = time.Now().Unix() + int64(secs)
+	} else if at, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		reset = at
 	}
+	c.rateLimitReset.Store(reset)
 }
 
 // GetLatestRelease gets the latest release for a repository
 func (c *GitHubClient) GetLatestRelease(ctx context.Context, repo string) (*Release, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repo)
+	return c.getRelease(ctx, fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repo))
+}
+
+// GetReleaseByTag gets the release for a specific tag
+func (c *GitHubClient) GetReleaseByTag(ctx context.Context, repo, tag string) (*Release, error) {
+	return c.getRelease(ctx, fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", repo, url.PathEscape(tag)))
+}
+
+// getRelease fetches and decodes a single release from the API
+func (c *GitHubClient) getRelease(ctx context.Context, url string) (*Release, error) {
+	if until, limited := c.RateLimitedUntil(); limited {
+		return nil, fmt.Errorf("%w until %s", errRateLimited, until.Format(time.RFC3339))
+	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	if c.token != "" {
-		req.Header.Set("Authorization", "token "+c.token)
-	}
+	c.authorize(req)
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 
 	resp, err := c.client.Do(req)
@@ -60,14 +156,18 @@ func (c *GitHubClient) GetLatestRelease(ctx context.Context, repo string) (*Rele
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			// Log the error but don't return it as we're in a defer
-			// In production, you might want to use a proper logger
-			fmt.Printf("Warning: failed to close response body: %v\n", err)
+			c.logger.Warn("Failed to close response body", "error", err)
 		}
 	}()
 
-	if resp.StatusCode == 403 {
-		return nil, fmt.Errorf("rate limited by GitHub API")
+	c.trackRateLimit(resp)
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("release not found")
+	}
+
+	if resp.StatusCode == 403 || resp.StatusCode == 429 {
+		return nil, errRateLimited
 	}
 
 	if resp.StatusCode != 200 {
@@ -93,32 +193,60 @@ func (r *Release) FindAssetWithSuffix(suffix string) (*Asset, error) {
 	return nil, fmt.Errorf("no asset found with suffix %s", suffix)
 }
 
-// DownloadAsset downloads an asset to the specified path
-func (c *GitHubClient) DownloadAsset(ctx context.Context, asset *Asset, destPath string) error {
+// openAsset starts downloading an asset. The caller must close the body.
+func (c *GitHubClient) openAsset(ctx context.Context, asset *Asset) (io.ReadCloser, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", asset.BrowserDownloadURL, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create download request: %w", err)
+		return nil, fmt.Errorf("failed to create download request: %w", err)
 	}
 
-	if c.token != "" {
-		req.Header.Set("Authorization", "token "+c.token)
-	}
+	c.authorize(req)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to download asset: %w", err)
+		return nil, fmt.Errorf("failed to download asset: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// FetchAsset downloads a small asset into memory, failing if it is larger
+// than limit bytes
+func (c *GitHubClient) FetchAsset(ctx context.Context, asset *Asset, limit int64) ([]byte, error) {
+	body, err := c.openAsset(ctx, asset)
+	if err != nil {
+		return nil, err
 	}
 	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			// Log the error but don't return it as we're in a defer
-			// In production, you might want to use a proper logger
-			fmt.Printf("Warning: failed to close response body: %v\n", err)
+		if err := body.Close(); err != nil {
+			c.logger.Warn("Failed to close response body", "error", err)
 		}
 	}()
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("download failed with status %d", resp.StatusCode)
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download asset: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("asset %s is larger than %d bytes", asset.Name, limit)
+	}
+	return data, nil
+}
+
+// DownloadAsset downloads an asset to the specified path
+func (c *GitHubClient) DownloadAsset(ctx context.Context, asset *Asset, destPath string) error {
+	body, err := c.openAsset(ctx, asset)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		if err := body.Close(); err != nil {
+			c.logger.Warn("Failed to close response body", "error", err)
+		}
+	}()
 
 	// Ensure destination directory exists
 	destDir := filepath.Dir(destPath)
@@ -134,7 +262,7 @@ func (c *GitHubClient) DownloadAsset(ctx context.Context, asset *Asset, destPath
 	}
 
 	// Copy the response body to file
-	if _, err := io.Copy(outFile, resp.Body); err != nil {
+	if _, err := io.Copy(outFile, body); err != nil {
 		_ = outFile.Close()
 		_ = os.Remove(tmpPath)
 		return fmt.Errorf("failed to write download to disk: %w", err)
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -41,7 +42,7 @@ func TestGitHubClient_GetLatestRelease(t *testing.T) {
 	defer server.Close()
 
 	// Create client with mock server
-	client := NewGitHubClient("")
+	client := NewGitHubClient("", slog.Default())
 	client.client.Transport = &mockTransport{server: server}
 
 	release, err := client.GetLatestRelease(context.Background(), "test/repo")
@@ -73,7 +74,7 @@ func TestGitHubClient_GetLatestRelease_WithAuth(t *testing.T) {
 	}))
 	defer server.Close()
 
-	client := NewGitHubClient("test-token")
+	client := NewGitHubClient("test-token", slog.Default())
 	client.client.Transport = &mockTransport{server: server}
 
 	_, err := client.GetLatestRelease(context.Background(), "test/repo")
@@ -82,6 +83,39 @@ func TestGitHubClient_GetLatestRelease_WithAuth(t *testing.T) {
 	}
 }
 
+func TestGitHubClient_GetReleaseByTagTracksRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/test/repo/releases/tags/v2.0.0" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		_, _ = w.Write([]byte(`{"tag_name": "v2.0.0"}`))
+	}))
+	defer server.Close()
+
+	client := NewGitHubClient("", slog.Default())
+	client.client.Transport = &mockTransport{server: server}
+	if got := client.RateLimitRemaining(); got != -1 {
+		t.Errorf("Expected unknown rate limit before any request, got %d", got)
+	}
+
+	release, err := client.GetReleaseByTag(context.Background(), "test/repo", "v2.0.0")
+	if err != nil {
+		t.Fatalf("Failed to get release by tag: %v", err)
+	}
+	if release.TagName != "v2.0.0" {
+		t.Errorf("Expected tag name 'v2.0.0', got '%s'", release.TagName)
+	}
+	if got := client.RateLimitRemaining(); got != 4999 {
+		t.Errorf("Expected rate limit 4999, got %d", got)
+	}
+
+	if _, err := client.GetReleaseByTag(context.Background(), "test/repo", "v9.9.9"); err == nil {
+		t.Error("Expected an error for a missing tag")
+	}
+}
+
 func TestRelease_FindAssetWithSuffix(t *testing.T) {
 	release := &Release{
 		TagName: "v1.0.0",
@@ -130,7 +164,7 @@ func TestGitHubClient_DownloadAsset(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	client := NewGitHubClient("")
+	client := NewGitHubClient("", slog.Default())
 	client.client = srv.Client()
 
 	asset := &Asset{
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hook phase names, also exposed to hooks as DEPLOY_PHASE
+const (
+	hookPreDownload  = "pre_download"
+	hookPostExtract  = "post_extract"
+	hookInstall      = "install"
+	hookPreSwitch    = "pre_switch"
+	hookPostSwitch   = "post_switch"
+	hookPostDeploy   = "post_deploy"
+	hookOnFailure    = "on_failure"
+	hookPostRollback = "post_rollback"
+)
+
+// hookKillGrace is how long a cancelled hook's output pipes are drained
+// after the process group has been killed
+const hookKillGrace = 5 * time.Second
+
+// HookContext describes the deployment a hook runs for
+type HookContext struct {
+	Tag          string
+	Slot         string
+	Dir          string
+	PreviousTag  string
+	PreviousSlot string
+	Error        string
+}
+
+// hookFor returns the configured hook for a phase with hook_defaults applied
+func (d *Deployer) hookFor(phase string) HookConfig {
+	hook := d.configuredHook(phase)
+	hook.ExecConfig = hook.ExecConfig.merge(d.config.HookDefaults)
+	return hook
+}
+
+// configuredHook returns the hook for a phase as written in the config. The
+// legacy run_command and post_deploy_script settings are the commands of the
+// install and post_deploy hooks unless those set their own.
+func (d *Deployer) configuredHook(phase string) HookConfig {
+	switch phase {
+	case hookPreDownload:
+		return d.config.Hooks.PreDownload
+	case hookPostExtract:
+		return d.config.Hooks.PostExtract
+	case hookInstall:
+		hook := d.config.Hooks.Install
+		if hook.Command == "" {
+			hook.Command = d.config.RunCommand
+		}
+		return hook
+	case hookPreSwitch:
+		return d.config.Hooks.PreSwitch
+	case hookPostSwitch:
+		return d.config.Hooks.PostSwitch
+	case hookPostDeploy:
+		hook := d.config.Hooks.PostDeploy
+		if hook.Command == "" {
+			hook.Command = d.config.PostDeployScript
+			// post_deploy_script has always run from the filesystem root
+			if hook.WorkingDir == "" {
+				hook.WorkingDir = "/"
+			}
+		}
+		return hook
+	case hookOnFailure:
+		return d.config.Hooks.OnFailure
+	case hookPostRollback:
+		return d.config.Hooks.PostRollback
+	}
+	return HookConfig{}
+}
+
+// hookEnv returns the DEPLOY_* variables describing the deployment
+func (d *Deployer) hookEnv(phase string, hc *HookContext) []string {
+	return []string{
+		"DEPLOY_PHASE=" + phase,
+		"DEPLOY_REPO=" + d.config.Repo,
+		"DEPLOY_TAG=" + hc.Tag,
+		"DEPLOY_SLOT=" + hc.Slot,
+		"DEPLOY_DIR=" + hc.Dir,
+		"DEPLOY_SYMLINK=" + d.config.CurrentSymlink,
+		"PREVIOUS_TAG=" + hc.PreviousTag,
+		"PREVIOUS_SLOT=" + hc.PreviousSlot,
+		"DEPLOY_ERROR=" + hc.Error,
+	}
+}
+
+// runHook runs the hook configured for phase, if any. The command runs via
+// sh -c in the deployment directory with a controlled environment plus the
+// DEPLOY_* variables, its output goes to the deployment log, and it is killed
+// when ctx is cancelled or its timeout expires.
+func (d *Deployer) runHook(ctx context.Context, phase string, hc *HookContext) error {
+	hook := d.hookFor(phase)
+	if hook.Command == "" {
+		return nil
+	}
+
+	hookCtx := ctx
+	if hook.TimeoutSecs > 0 {
+		var cancel context.CancelFunc
+		hookCtx, cancel = context.WithTimeout(ctx, time.Duration(hook.TimeoutSecs)*time.Second)
+		defer cancel()
+	}
+
+	dir := hc.Dir
+	if info, err := os.Stat(dir); dir == "" || err != nil || !info.IsDir() {
+		dir = d.config.InstallDir
+	}
+
+	cmd, err := d.buildCommand(hookCtx, hook.Command, hook.ExecConfig, dir, d.hookEnv(phase, hc))
+	if err != nil {
+		return fmt.Errorf("%s hook: %w", phase, err)
+	}
+	log := d.log(ctx).With("phase", phase)
+	stdout := newLogLineWriter(log.With("source", "hook"), "stdout")
+	stderr := newLogLineWriter(log.With("source", "hook"), "stderr")
+	// A hook that keeps writing output is not wedged
+	stdout.onLine, stderr.onLine = d.progressed, d.progressed
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = hookKillGrace
+	configureHookProcess(cmd)
+
+	log.Info("Running hook", "command", hook.Command)
+	start := time.Now()
+	err = cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s hook cancelled: %w", phase, ctx.Err())
+		}
+		if errors.Is(hookCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%s hook timed out after %ds", phase, hook.TimeoutSecs)
+		}
+		return fmt.Errorf("%s hook failed: %w", phase, err)
+	}
+	log.Info("Hook completed", durationMS(time.Since(start)))
+	return nil
+}
+
+// logLineWriter writes each complete line of command output to a logger
+type logLineWriter struct {
+	logger *slog.Logger
+	onLine func() // called for each line, if set
+	mu     sync.Mutex
+	buf    bytes.Buffer
+}
+
+// newLogLineWriter logs lines of a command's stream ("stdout" or "stderr")
+func newLogLineWriter(logger *slog.Logger, stream string) *logLineWriter {
+	return &logLineWriter{logger: logger.With("stream", stream)}
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// Keep the partial line until the rest arrives
+			w.buf.WriteString(line)
+			break
+		}
+		w.logger.Info(strings.TrimRight(line, "\r\n"))
+		if w.onLine != nil {
+			w.onLine()
+		}
+	}
+	return len(p), nil
+}
+
+// Flush logs any trailing output not terminated by a newline
+func (w *logLineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() > 0 {
+		w.logger.Info(w.buf.String())
+		w.buf.Reset()
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHookEnvironmentAndOutput(t *testing.T) {
+	var logs bytes.Buffer
+	deployer := &Deployer{
+		config: &Config{AppConfig: AppConfig{
+			Repo:       "test/repo",
+			InstallDir: t.TempDir(),
+			Hooks: HooksConfig{
+				PreSwitch: HookConfig{Command: `echo "tag=$DEPLOY_TAG slot=$DEPLOY_SLOT prev=$PREVIOUS_TAG"; echo oops >&2`},
+			},
+		}},
+		logger: slog.New(slog.NewTextHandler(&logs, nil)),
+	}
+
+	hc := &HookContext{Tag: "v2.0.0", Slot: "green", PreviousTag: "v1.0.0"}
+	if err := deployer.runHook(context.Background(), hookPreSwitch, hc); err != nil {
+		t.Fatalf("Hook failed: %v", err)
+	}
+
+	out := logs.String()
+	if !strings.Contains(out, `msg="tag=v2.0.0 slot=green prev=v1.0.0" phase=pre_switch source=hook stream=stdout`) {
+		t.Errorf("Expected hook stdout in the log, got:\n%s", out)
+	}
+	if !strings.Contains(out, `msg=oops phase=pre_switch source=hook stream=stderr`) {
+		t.Errorf("Expected hook stderr in the log, got:\n%s", out)
+	}
+}
+
+func TestRunHookTimeout(t *testing.T) {
+	deployer := &Deployer{
+		config: &Config{AppConfig: AppConfig{
+			InstallDir: t.TempDir(),
+			Hooks: HooksConfig{
+				PreDownload: HookConfig{Command: "sleep 30", TimeoutSecs: 1},
+			},
+		}},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	start := time.Now()
+	err := deployer.runHook(context.Background(), hookPreDownload, &HookContext{})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Hook was not killed promptly, took %s", elapsed)
+	}
+}
+
+func TestLegacyCommandsTakeHookSettings(t *testing.T) {
+	deployer := &Deployer{
+		config: &Config{AppConfig: AppConfig{
+			InstallDir:       t.TempDir(),
+			RunCommand:       "sleep 30",
+			PostDeployScript: "pwd",
+			Hooks: HooksConfig{
+				Install:    HookConfig{TimeoutSecs: 1},
+				PostDeploy: HookConfig{TimeoutSecs: 5},
+			},
+		}},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	start := time.Now()
+	err := deployer.runHook(context.Background(), hookInstall, &HookContext{})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Expected run_command to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("run_command was not killed promptly, took %s", elapsed)
+	}
+
+	hook := deployer.hookFor(hookPostDeploy)
+	if hook.Command != "pwd" || hook.TimeoutSecs != 5 || hook.WorkingDir != "/" {
+		t.Errorf("Expected post_deploy_script with the post_deploy timeout, run from /, got %+v", hook)
+	}
+	if problems := loadProblems(t, validConfig+"run_command: make\nhooks:\n  install:\n    command: make install\n"); !strings.Contains(problems, "set only one of run_command and hooks.install.command") {
+		t.Errorf("Expected run_command and hooks.install.command together to be rejected, got:\n%s", problems)
+	}
+}
+
+func TestRunHookCancelled(t *testing.T) {
+	deployer := &Deployer{
+		config: &Config{AppConfig: AppConfig{
+			InstallDir: t.TempDir(),
+			Hooks: HooksConfig{
+				PostExtract: HookConfig{Command: "sleep 30"},
+			},
+		}},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := deployer.runHook(ctx, hookPostExtract, &HookContext{}); err == nil {
+		t.Fatal("Expected error for cancelled hook")
+	}
+}
+
+func TestRunHookNotConfigured(t *testing.T) {
+	deployer := &Deployer{config: &Config{}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	if err := deployer.runHook(context.Background(), hookOnFailure, &HookContext{}); err != nil {
+		t.Errorf("Expected no error for unconfigured hook, got %v", err)
+	}
+}
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -23,15 +31,17 @@ func TestDeployerIntegration(t *testing.T) {
 
 	// Create test config
 	config := &Config{
-		Repo:               "nonexistent/repo", // This will fail, which is expected
-		AssetSuffix:        ".tar.gz",
-		CheckIntervalSecs:  1, // Short interval for testing
-		InstallDir:         installDir,
-		CurrentSymlink:     filepath.Join(tempDir, "current"),
-		RunCommand:         "echo test",
-		PostDeployScript:   "echo 'post-deploy'",
-		StateFile:          stateFile,
-		HealthCheckTimeout: 5,
+		AppConfig: AppConfig{
+			Repo:               "nonexistent/repo", // This will fail, which is expected
+			AssetSuffix:        ".tar.gz",
+			InstallDir:         installDir,
+			CurrentSymlink:     filepath.Join(tempDir, "current"),
+			RunCommand:         "echo test",
+			PostDeployScript:   "echo 'post-deploy'",
+			StateFile:          stateFile,
+			HealthCheckTimeout: 5,
+		},
+		CheckIntervalSecs: 1, // Short interval for testing
 		Logging: LoggingConfig{
 			Level: "debug",
 			File:  logFile,
@@ -39,7 +49,7 @@ func TestDeployerIntegration(t *testing.T) {
 	}
 
 	// Create logger
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	// Create deployer
 	deployer, err := NewDeployer(config, logger, true) // dry-run mode
@@ -51,7 +61,7 @@ func TestDeployerIntegration(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err = deployer.Run(ctx)
+	err = newTestDaemon(deployer).Run(ctx)
 	if err != nil {
 		t.Fatalf("Deployer run failed: %v", err)
 	}
@@ -86,13 +96,15 @@ func TestDeployerRollback(t *testing.T) {
 
 	// Create config
 	config := &Config{
-		StateFile: stateFile,
+		AppConfig: AppConfig{
+			StateFile: stateFile,
+		},
 		Logging: LoggingConfig{
 			Level: "info",
 		},
 	}
 
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	// Test with dry-run mode
 	t.Run("DryRun", func(t *testing.T) {
@@ -107,7 +119,7 @@ func TestDeployerRollback(t *testing.T) {
 		}
 
 		// Test rollback in dry-run
-		if err := deployer.Rollback(); err != nil {
+		if err := deployer.Rollback(context.Background()); err != nil {
 			t.Fatalf("Rollback failed: %v", err)
 		}
 
@@ -145,7 +157,7 @@ func TestDeployerRollback(t *testing.T) {
 		}
 
 		// Test rollback
-		if err := deployer.Rollback(); err != nil {
+		if err := deployer.Rollback(context.Background()); err != nil {
 			t.Fatalf("Rollback failed: %v", err)
 		}
 
@@ -165,3 +177,202 @@ func TestDeployerRollback(t *testing.T) {
 		}
 	})
 }
+
+// newTestReleaseServer serves a fake GitHub API whose latest release is tag,
+// published when the server starts, with a single app.tar.gz asset
+// containing files
+func newTestReleaseServer(t *testing.T, tag string, files map[string]string) *httptest.Server {
+	t.Helper()
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+
+	published := time.Now().UTC().Format(time.RFC3339)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/releases/latest"), strings.HasSuffix(r.URL.Path, "/releases/tags/"+tag):
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"tag_name": %q, "published_at": %q, "assets": [{"name": "app.tar.gz", "browser_download_url": "%s/download/app.tar.gz"}]}`,
+				tag, published, server.URL)
+		case r.URL.Path == "/download/app.tar.gz":
+			_, _ = w.Write(archive.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestDeployer creates a deployer whose GitHub client talks to server
+func newTestDeployer(t *testing.T, config *Config, server *httptest.Server) *Deployer {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	deployer, err := NewDeployer(config, logger, false)
+	if err != nil {
+		t.Fatalf("Failed to create deployer: %v", err)
+	}
+	deployer.github.client.Transport = &mockTransport{server: server}
+	return deployer
+}
+
+// newTestDaemon runs a single deployer as the daemon would
+func newTestDaemon(d *Deployer) *Daemon {
+	return newDaemon(d.config, d.logger, []*Deployer{d})
+}
+
+func TestDeployRunsLifecycleHooks(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	hookLog := filepath.Join(t.TempDir(), "hooks.log")
+	record := func(phase string) HookConfig {
+		return HookConfig{
+			Command:     fmt.Sprintf(`echo "%s $DEPLOY_TAG $DEPLOY_SLOT $PREVIOUS_TAG" >> %s`, phase, hookLog),
+			TimeoutSecs: 10,
+		}
+	}
+	config.Hooks = HooksConfig{
+		PreDownload:  record(hookPreDownload),
+		PostExtract:  record(hookPostExtract),
+		PreSwitch:    record(hookPreSwitch),
+		PostSwitch:   record(hookPostSwitch),
+		OnFailure:    record(hookOnFailure),
+		PostRollback: record(hookPostRollback),
+	}
+
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+	deployer := newTestDeployer(t, config, server)
+
+	if err := deployer.checkAndDeploy(context.Background()); err != nil {
+		t.Fatalf("Deployment failed: %v", err)
+	}
+	if err := deployer.Rollback(context.Background()); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	data, err := os.ReadFile(hookLog)
+	if err != nil {
+		t.Fatalf("Failed to read hook log: %v", err)
+	}
+	expected := strings.Join([]string{
+		"pre_download v2.0.0 green v1.0.0",
+		"post_extract v2.0.0 green v1.0.0",
+		"pre_switch v2.0.0 green v1.0.0",
+		"post_switch v2.0.0 green v1.0.0",
+		"post_rollback v1.0.0 blue v2.0.0",
+	}, "\n") + "\n"
+	if string(data) != expected {
+		t.Errorf("Unexpected hook sequence:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestDeployFailureRunsOnFailureHook(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	marker := filepath.Join(t.TempDir(), "failed")
+	config.Hooks.PreSwitch = HookConfig{Command: "exit 3"}
+	config.Hooks.OnFailure = HookConfig{Command: fmt.Sprintf(`echo "$DEPLOY_ERROR" > %s`, marker)}
+
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+	deployer := newTestDeployer(t, config, server)
+
+	if err := deployer.checkAndDeploy(context.Background()); err == nil {
+		t.Fatal("Expected deployment to fail when pre_switch hook fails")
+	}
+	if deployer.state.ActiveSlot != "blue" {
+		t.Errorf("Expected active slot to stay 'blue', got '%s'", deployer.state.ActiveSlot)
+	}
+	data, err := os.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("Expected on_failure hook to run: %v", err)
+	}
+	if !strings.Contains(string(data), "pre_switch hook failed") {
+		t.Errorf("Expected DEPLOY_ERROR to describe the failure, got %q", data)
+	}
+}
+
+func TestMinReleaseAge(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.MinReleaseAge = "24h"
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+	deployer := newTestDeployer(t, config, server)
+
+	if err := deployer.checkAndDeploy(context.Background()); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if deployer.getCurrentVersion() != "v1.0.0" || deployer.soakingTag != "v2.0.0" || deployer.metrics.downloadBytes != 0 {
+		t.Fatalf("Expected a release published just now not to be downloaded, got %+v", deployer.state)
+	}
+
+	// A manual deployment doesn't wait
+	if err := deployer.DeployTag(context.Background(), "v2.0.0"); err != nil {
+		t.Fatalf("Deployment failed: %v", err)
+	}
+	if deployer.getCurrentVersion() != "v2.0.0" {
+		t.Errorf("Expected a manual deployment of a new release to go ahead, got %+v", deployer.state)
+	}
+}
+
+func TestDeployCommandSkipsMinReleaseAge(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.MinReleaseAge = "720h"
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	github := NewGitHubClient("", logger)
+	github.client.Transport = &mockTransport{server: server}
+
+	var out bytes.Buffer
+	if err := deployCommand(context.Background(), config, nil, &out, github, logger, false); err == nil {
+		t.Error("Expected deploy without --tag to be refused")
+	}
+	if err := deployCommand(context.Background(), config, []string{"--tag", "v2.0.0"}, &out, github, logger, false); err != nil {
+		t.Fatalf("deploy failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "v2.0.0 is deployed in the green slot") {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+	state, err := LoadState(config.StateFile)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if state.ActiveSlot != "green" || state.GreenVersion != "v2.0.0" {
+		t.Errorf("Expected a release published just now to be deployed, got %+v", state)
+	}
+}
+
+func TestReleaseEligibleAt(t *testing.T) {
+	published := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	d := &Deployer{config: &Config{AppConfig: AppConfig{MinReleaseAge: "36h"}}}
+	if got := d.releaseEligibleAt(&Release{PublishedAt: published}); !got.Equal(published.Add(36 * time.Hour)) {
+		t.Errorf("Unexpected eligibility time %s", got)
+	}
+	if got := d.releaseEligibleAt(&Release{}); !got.IsZero() {
+		t.Errorf("Expected a release without a publication time to be eligible, got %s", got)
+	}
+	if problems := loadProblems(t, validConfig+"min_release_age: 1 day\n"); !strings.Contains(problems, "min_release_age") {
+		t.Errorf("Expected min_release_age to be rejected, got:\n%s", problems)
+	}
+}
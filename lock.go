@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockFileName is the advisory lock file created in install_dir
+const lockFileName = ".gh-deployer.lock"
+
+// errLockHeld is returned by the platform lock primitives when another
+// process already holds the lock
+var errLockHeld = errors.New("lock is held by another process")
+
+// LockHeldError reports that another gh-deployer process holds the lock
+type LockHeldError struct {
+	Path string
+	PID  int
+}
+
+func (e *LockHeldError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("another gh-deployer process (pid %d) holds the deployment lock %s", e.PID, e.Path)
+	}
+	return fmt.Sprintf("another gh-deployer process holds the deployment lock %s", e.Path)
+}
+
+// InstanceLock is an advisory, process-exclusive lock on a file. The
+// operating system releases it automatically if the holder dies.
+type InstanceLock struct {
+	path string
+	file *os.File
+}
+
+// AcquireInstanceLock takes the lock at path without blocking. If another
+// process holds it, a *LockHeldError naming the holder PID is returned.
+func AcquireInstanceLock(path string) (*InstanceLock, error) {
+	f, err := openLockFile(path)
+	if errors.Is(err, errLockHeld) {
+		return nil, &LockHeldError{Path: path, PID: readLockHolder(path)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		if errors.Is(err, errLockHeld) {
+			return nil, &LockHeldError{Path: path, PID: readLockHolder(path)}
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	// Record the holder so a competing process can name it
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+		_ = f.Sync()
+	}
+
+	return &InstanceLock{path: path, file: f}, nil
+}
+
+// Release gives up the lock. The lock file itself is left in place, since
+// removing it would let two processes lock different inodes.
+func (l *InstanceLock) Release() error {
+	_ = l.file.Truncate(0)
+	unlockErr := unlockFile(l.file)
+	closeErr := l.file.Close()
+	if unlockErr != nil {
+		return unlockErr
+	}
+	return closeErr
+}
+
+// readLockHolder returns the PID recorded in a lock file, or 0 if unknown
+func readLockHolder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// lockPath returns the path of the deployment lock for this install_dir
+func (d *Deployer) lockPath() string {
+	return filepath.Join(d.config.InstallDir, lockFileName)
+}
+
+// withDeployLock runs fn while holding the deployment lock. Every operation
+// that writes slots, the symlink or the state file must go through it. The
+// state is reloaded and reconciled once the lock is held, since another
+// process may have changed it, the symlink or the slots in the meantime.
+func (d *Deployer) withDeployLock(fn func() error) error {
+	if err := os.MkdirAll(d.config.InstallDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create install directory: %w", err)
+	}
+
+	lock, err := AcquireInstanceLock(d.lockPath())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			d.logger.Warn("Failed to release deployment lock", "error", err)
+		}
+	}()
+
+	state, err := LoadState(d.config.StateFile)
+	if err != nil {
+		return fmt.Errorf("failed to reload state: %w", err)
+	}
+	d.state = state
+	d.logRepairs(d.reconcile().Repairs)
+
+	return fn()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstanceLockExclusive(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), lockFileName)
+
+	first, err := AcquireInstanceLock(lockPath)
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+
+	_, err = AcquireInstanceLock(lockPath)
+	var held *LockHeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("Expected LockHeldError, got %v", err)
+	}
+	if held.PID != os.Getpid() {
+		t.Errorf("Expected holder pid %d, got %d", os.Getpid(), held.PID)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+
+	second, err := AcquireInstanceLock(lockPath)
+	if err != nil {
+		t.Fatalf("Failed to re-acquire released lock: %v", err)
+	}
+	if err := second.Release(); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+}
+
+func TestRollbackRespectsDeployLock(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0", GreenVersion: "v1.1.0"})
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	deployer, err := NewDeployer(config, logger, false)
+	if err != nil {
+		t.Fatalf("Failed to create deployer: %v", err)
+	}
+
+	// Simulate another process holding the lock
+	lock, err := AcquireInstanceLock(deployer.lockPath())
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+
+	var held *LockHeldError
+	if err := deployer.Rollback(context.Background()); !errors.As(err, &held) {
+		t.Fatalf("Expected rollback to fail with LockHeldError, got %v", err)
+	}
+	if deployer.state.ActiveSlot != "blue" {
+		t.Errorf("Expected state untouched while locked, got active slot '%s'", deployer.state.ActiveSlot)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+	if err := deployer.Rollback(context.Background()); err != nil {
+		t.Fatalf("Rollback failed after lock was released: %v", err)
+	}
+	if deployer.state.ActiveSlot != "green" {
+		t.Errorf("Expected active slot 'green' after rollback, got '%s'", deployer.state.ActiveSlot)
+	}
+}
+
+func TestReconcileDoesNotSaveWhileLocked(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0", GreenVersion: "v1.1.0"})
+	if err := os.Symlink(filepath.Join(config.InstallDir, "green"), config.CurrentSymlink); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	// Another process is deploying and owns the state file
+	lock, err := AcquireInstanceLock(filepath.Join(config.InstallDir, lockFileName))
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	defer func() { _ = lock.Release() }()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	deployer, err := NewDeployer(config, logger, false)
+	if err != nil {
+		t.Fatalf("Failed to create deployer: %v", err)
+	}
+	if deployer.state.ActiveSlot != "green" {
+		t.Errorf("Expected active slot repaired in memory to 'green', got '%s'", deployer.state.ActiveSlot)
+	}
+	saved, err := LoadState(config.StateFile)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if saved.ActiveSlot != "blue" {
+		t.Errorf("Expected the state file untouched while locked, got active slot '%s'", saved.ActiveSlot)
+	}
+}
+
+func TestDeployLockReconcilesReloadedState(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0", GreenVersion: "v1.1.0"})
+	if err := os.Symlink(filepath.Join(config.InstallDir, "blue"), config.CurrentSymlink); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	deployer, err := NewDeployer(config, logger, false)
+	if err != nil {
+		t.Fatalf("Failed to create deployer: %v", err)
+	}
+
+	// Another process switched to green and died before saving its state
+	if err := os.Remove(config.CurrentSymlink); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(config.InstallDir, "green"), config.CurrentSymlink); err != nil {
+		t.Fatal(err)
+	}
+
+	var active, target string
+	err = deployer.withDeployLock(func() error {
+		active, target = deployer.state.ActiveSlot, deployer.state.GetInactiveSlot()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withDeployLock failed: %v", err)
+	}
+	if active != "green" || target != "blue" {
+		t.Errorf("Expected the live green slot to be active under the lock, got active %s, target %s", active, target)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// parseLogLevel converts a logging.level setting to a slog level
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+}
+
+// newLogHandler creates a text or JSON handler writing to w
+func newLogHandler(w io.Writer, cfg LoggingConfig) (slog.Handler, error) {
+	level, err := parseLogLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+}
+
+// setupLogging creates the logger described by the logging settings and
+// makes it the default, so anything using the log package ends up there too.
+// Logging to a file returns the file so it can be reopened on SIGHUP. If the
+// log file cannot be opened it logs to stdout instead.
+func setupLogging(cfg LoggingConfig) (*slog.Logger, *rotatingFile, error) {
+	if _, err := parseSize(cfg.MaxSize); err != nil {
+		return nil, nil, fmt.Errorf("invalid logging.max_size: %w", err)
+	}
+
+	var out io.Writer = os.Stdout
+	var logFile *rotatingFile
+	var fileErr error
+	if cfg.File != "" {
+		logFile, fileErr = openRotatingFile(cfg)
+		if fileErr == nil {
+			out = logFile
+		}
+	}
+
+	handler, err := newLogHandler(out, cfg)
+	if err != nil {
+		if logFile != nil {
+			_ = logFile.Close()
+		}
+		return nil, nil, err
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	if fileErr != nil {
+		logger.Error("Failed to open log file, logging to stdout", "file", cfg.File, "error", fileErr)
+	}
+	return logger, logFile, nil
+}
+
+// loggerKey is the context key for a logger carrying deployment fields
+type loggerKey struct{}
+
+// withLogger returns a context whose operations log through logger
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// log returns the logger for ctx: the deployment's logger, with its tag and
+// slot fields, inside a deployment or rollback, and the deployer's otherwise
+func (d *Deployer) log(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return d.logger
+}
+
+// durationMS is the duration_ms field attached to timed log lines
+func durationMS(d time.Duration) slog.Attr {
+	return slog.Int64("duration_ms", d.Milliseconds())
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestNewLogHandlerLevelsAndFormats(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := newLogHandler(&buf, LoggingConfig{Level: "warn", Format: "json"})
+	if err != nil {
+		t.Fatalf("newLogHandler failed: %v", err)
+	}
+	logger := slog.New(handler)
+	logger.Info("dropped")
+	logger.Warn("kept", "tag", "v1.0.0")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a single JSON line, got %q: %v", buf.String(), err)
+	}
+	if entry["msg"] != "kept" || entry["tag"] != "v1.0.0" {
+		t.Errorf("Unexpected entry %v", entry)
+	}
+
+	if _, err := newLogHandler(&buf, LoggingConfig{Level: "loud"}); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+	if _, err := newLogHandler(&buf, LoggingConfig{Format: "xml"}); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+func TestDeploymentLogLinesCarryFields(t *testing.T) {
+	server := newTestReleaseServer(t, "v1.0.0", map[string]string{"app.txt": "v1"})
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.RunCommand = "true"
+	d := newTestDeployer(t, config, server)
+
+	var buf bytes.Buffer
+	d.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	d.github.logger = d.logger
+	if err := d.checkAndDeploy(context.Background()); err != nil {
+		t.Fatalf("Deployment failed: %v", err)
+	}
+
+	phases := map[string]bool{}
+	var completed map[string]any
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid JSON log line %q: %v", scanner.Text(), err)
+		}
+		if entry["msg"] == "New version available" {
+			continue
+		}
+		if entry["tag"] != "v1.0.0" || entry["slot"] != "green" {
+			t.Errorf("Deployment log line without tag and slot: %s", scanner.Text())
+		}
+		if phase, ok := entry["phase"].(string); ok {
+			phases[phase] = true
+		}
+		if entry["msg"] == "Deployment completed successfully" {
+			completed = entry
+		}
+	}
+
+	for _, phase := range []string{"download", "extract", hookInstall, "switch"} {
+		if !phases[phase] {
+			t.Errorf("No log line for phase %s, got phases %v", phase, phases)
+		}
+	}
+	if completed == nil {
+		t.Fatal("No completion log line")
+	}
+	if _, ok := completed["duration_ms"]; !ok {
+		t.Errorf("Completion log line has no duration_ms: %v", completed)
+	}
+}
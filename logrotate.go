@@ -0,0 +1,268 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat timestamps rotated log files, e.g. deployer.log.2006-01-02T15-04-05.000
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// parseSize parses a size such as "100MB", "512K" or "1048576". Units are
+// binary (1KB = 1024 bytes). An empty string means no limit.
+func parseSize(size string) (int64, error) {
+	s := strings.TrimSpace(strings.ToUpper(size))
+	if s == "" {
+		return 0, nil
+	}
+	number := strings.TrimRight(s, "KMGTIB")
+	unit := strings.TrimSuffix(strings.TrimSuffix(s[len(number):], "B"), "I")
+
+	multiplier := map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}[unit]
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if multiplier == 0 || err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// rotatingFile is a log file that rotates itself once it reaches maxSize,
+// keeping at most maxBackups rotated files no older than maxAge, optionally
+// gzipped. Reopen supports external rotation by logrotate.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+	compress   bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	// mill compresses and prunes backups in the background
+	mill     chan struct{}
+	millDone chan struct{}
+}
+
+// openRotatingFile opens the log file described by cfg
+func openRotatingFile(cfg LoggingConfig) (*rotatingFile, error) {
+	maxSize, err := parseSize(cfg.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid logging.max_size: %w", err)
+	}
+	r := &rotatingFile{
+		path:       cfg.File,
+		maxSize:    maxSize,
+		maxBackups: cfg.MaxBackups,
+		maxAge:     time.Duration(cfg.MaxAge) * 24 * time.Hour,
+		compress:   cfg.Compress,
+		mill:       make(chan struct{}, 1),
+		millDone:   make(chan struct{}),
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	go r.runMill()
+	// Apply the retention settings to backups left by earlier runs
+	r.mill <- struct{}{}
+	return r, nil
+}
+
+// open opens the log file for appending. The caller must hold mu or own r.
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past maxSize
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the oversized file rather than losing lines
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and starts a new one. The caller must hold mu.
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	backup := r.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(r.path, backup); err != nil {
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	select {
+	case r.mill <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Reopen closes and reopens the log file, for use after logrotate has moved
+// it. It is safe to call on a nil file.
+func (r *rotatingFile) Reopen() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file != nil {
+		_ = r.file.Close()
+		r.file = nil
+	}
+	return r.open()
+}
+
+// Close closes the file and waits for background compression to finish
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.mu.Unlock()
+
+	close(r.mill)
+	<-r.millDone
+	return err
+}
+
+// runMill compresses and prunes backups whenever the mill is signalled
+func (r *rotatingFile) runMill() {
+	defer close(r.millDone)
+	for range r.mill {
+		if err := r.millOnce(); err != nil {
+			fmt.Fprintf(os.Stderr, "log backup maintenance failed: %v\n", err)
+		}
+	}
+}
+
+// logBackup is a rotated log file
+type logBackup struct {
+	path string
+	time time.Time
+}
+
+// backups lists rotated files of r.path, newest first
+func (r *rotatingFile) backups() ([]logBackup, error) {
+	dir, base := filepath.Dir(r.path), filepath.Base(r.path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []logBackup
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), base+".")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, strings.TrimSuffix(stamp, ".gz"), time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, logBackup{path: filepath.Join(dir, entry.Name()), time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.After(backups[j].time) })
+	return backups, nil
+}
+
+// millOnce removes backups beyond maxBackups or older than maxAge and
+// compresses the rest if enabled
+func (r *rotatingFile) millOnce() error {
+	backups, err := r.backups()
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for i, b := range backups {
+		expired := r.maxAge > 0 && time.Since(b.time) > r.maxAge
+		if (r.maxBackups > 0 && i >= r.maxBackups) || expired {
+			if err := os.Remove(b.path); err != nil {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+		if r.compress && !strings.HasSuffix(b.path, ".gz") {
+			if err := gzipFile(b.path); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// gzipFile replaces path with path.gz
+func gzipFile(path string) error {
+	if err := writeGzip(path, path+".gz"); err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// writeGzip writes a gzipped copy of src to dst
+func writeGzip(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"":        0,
+		"1048576": 1048576,
+		"512K":    512 << 10,
+		"100MB":   100 << 20,
+		"1.5 GB":  3 << 29,
+		"2MiB":    2 << 20,
+		"10b":     10,
+	}
+	for input, want := range tests {
+		got, err := parseSize(input)
+		if err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"lots", "10XB", "-1MB", "MB"} {
+		if _, err := parseSize(input); err == nil {
+			t.Errorf("parseSize(%q) should fail", input)
+		}
+	}
+}
+
+// logBackups returns the rotated files next to path
+func logBackups(t *testing.T, path string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestRotatingFileRotatesAndPrunes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deployer.log")
+	r, err := openRotatingFile(LoggingConfig{File: path, MaxSize: "100", MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("openRotatingFile failed: %v", err)
+	}
+
+	line := strings.Repeat("x", 59) + "\n"
+	for i := 0; i < 5; i++ {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		// Backups are named by timestamp, so keep them distinct
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != line {
+		t.Errorf("Expected the live file to hold only the last line, got %q (%v)", data, err)
+	}
+	if backups := logBackups(t, path); len(backups) != 2 {
+		t.Errorf("Expected 2 backups after pruning, got %v", backups)
+	}
+}
+
+func TestRotatingFileCompressesAndExpiresBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deployer.log")
+	expired := path + "." + time.Now().AddDate(0, 0, -10).Format(backupTimeFormat) + ".gz"
+	if err := os.WriteFile(expired, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	unrelated := path + ".old"
+	if err := os.WriteFile(unrelated, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openRotatingFile(LoggingConfig{File: path, MaxSize: "10", MaxAge: 7, Compress: true})
+	if err != nil {
+		t.Fatalf("openRotatingFile failed: %v", err)
+	}
+	_, _ = r.Write([]byte("first line\n"))
+	_, _ = r.Write([]byte("second line\n"))
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("Expected backup older than max_age to be removed")
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("Files that are not backups must be left alone: %v", err)
+	}
+
+	var compressed []string
+	for _, b := range logBackups(t, path) {
+		if strings.HasSuffix(b, ".gz") {
+			compressed = append(compressed, b)
+		}
+	}
+	if len(compressed) != 1 {
+		t.Fatalf("Expected one compressed backup, got %v", logBackups(t, path))
+	}
+	f, err := os.Open(compressed[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Backup is not gzip: %v", err)
+	}
+	if data, _ := io.ReadAll(gz); string(data) != "first line\n" {
+		t.Errorf("Unexpected backup contents %q", data)
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deployer.log")
+	r, err := openRotatingFile(LoggingConfig{File: path})
+	if err != nil {
+		t.Fatalf("openRotatingFile failed: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+
+	_, _ = r.Write([]byte("before\n"))
+	// Simulate logrotate moving the file away
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reopen(); err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	_, _ = r.Write([]byte("after\n"))
+
+	if data, _ := os.ReadFile(path); string(data) != "after\n" {
+		t.Errorf("Expected new file to contain only later lines, got %q", data)
+	}
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "before\n" {
+		t.Errorf("Expected moved file to keep earlier lines, got %q", data)
+	}
+}
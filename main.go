@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
@@ -23,6 +25,7 @@ func main() {
 		showVersion = flag.Bool("version", false, "Show version information")
 		showHelp    = flag.Bool("help", false, "Show help information")
 	)
+	flag.Usage = usage
 	flag.Parse()
 
 	if *showVersion {
@@ -31,26 +34,128 @@ func main() {
 	}
 
 	if *showHelp {
-		fmt.Println("GitHub Release Deployer - Blue/Green deployment tool")
-		fmt.Println("")
-		fmt.Println("Usage:")
-		flag.PrintDefaults()
+		usage()
 		os.Exit(0)
 	}
 
+	command, args := "run", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	// These commands don't need a valid config
+	switch command {
+	case "validate-config":
+		if err := validateConfigCommand(*configPath, args, os.Stdout); err != nil {
+			if !errors.Is(err, errInvalidConfig) {
+				fmt.Fprintf(os.Stderr, "validate-config: %v\n", err)
+			}
+			os.Exit(1)
+		}
+		return
+	case "schema":
+		if err := schemaCommand(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "schema: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Load configuration
 	config, err := LoadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	for _, name := range unknownEnvOverrides(os.Environ()) {
+		log.Printf("Ignoring %s: %v", name, errUnknownSetting)
+	}
+
+	switch command {
+	case "run":
+		run(config, *configPath, args, *dryRun)
+	case "status":
+		if err := statusCommand(config, args, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "status: %v\n", err)
+			os.Exit(1)
+		}
+	case "freeze", "unfreeze":
+		if err := freezeCommand(config, command, args, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+			os.Exit(1)
+		}
+	case "plan":
+		// Only problems are logged, the plan itself goes to stdout
+		logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := planCommand(ctx, config, args, os.Stdout, logger)
+		stop()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "plan: %v\n", err)
+			os.Exit(1)
+		}
+	case "deploy":
+		logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := deployCommand(ctx, config, args, os.Stdout, NewGitHubClient(config.GitHubToken, logger), logger, *dryRun)
+		stop()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "deploy: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// usage prints the command line help
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "GitHub Release Deployer - Blue/Green deployment tool")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Usage:")
+	fmt.Fprintln(out, "  gh-deployer [flags] [run [--app name]]   Run the deployer (default)")
+	fmt.Fprintln(out, "  gh-deployer [flags] status [--app name]  Show deployed versions")
+	fmt.Fprintln(out, "  gh-deployer [flags] plan [--app name] [--tag tag] [--json]")
+	fmt.Fprintln(out, "                                          Show what deploying a release would change")
+	fmt.Fprintln(out, "  gh-deployer [flags] deploy [--app name] --tag tag")
+	fmt.Fprintln(out, "                                          Deploy a release now, whatever the window, age or rollout")
+	fmt.Fprintln(out, "  gh-deployer [flags] freeze [--app name] [reason]")
+	fmt.Fprintln(out, "                                          Hold automatic deployments until unfreeze")
+	fmt.Fprintln(out, "  gh-deployer [flags] unfreeze [--app name]")
+	fmt.Fprintln(out, "  gh-deployer [flags] validate-config [file...]")
+	fmt.Fprintln(out, "                                          Check config files and report every problem")
+	fmt.Fprintln(out, "  gh-deployer schema                       Print the JSON Schema of the config file")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Flags:")
+	flag.PrintDefaults()
+}
+
+// run runs the deployer for every app, or the one named with --app, until
+// it receives SIGINT or SIGTERM. SIGHUP reloads the config from configPath.
+func run(config *Config, configPath string, args []string, dryRun bool) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	app := fs.String("app", "", "Only run this app")
+	_ = fs.Parse(args)
+	if err := config.onlyApp(*app); err != nil {
+		log.Fatalf("Failed to select app: %v", err)
+	}
 
 	// Setup logging
-	logger := setupLogging(config)
+	logger, logFile, err := setupLogging(config.Logging)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	if logFile != nil {
+		defer func() { _ = logFile.Close() }()
+	}
 
-	// Create deployer
-	deployer, err := NewDeployer(config, logger, *dryRun)
+	// Create deployers
+	daemon, err := NewDaemon(config, logger, dryRun)
 	if err != nil {
-		logger.Fatalf("Failed to create deployer: %v", err)
+		logger.Error("Failed to create deployer", "error", err)
+		os.Exit(1)
 	}
 
 	// Setup graceful shutdown
@@ -62,29 +167,38 @@ func main() {
 
 	go func() {
 		<-signalChan
-		logger.Println("Received shutdown signal")
+		logger.Info("Received shutdown signal")
 		cancel()
 	}()
 
-	// Start the deployer
-	logger.Println("Starting GitHub Release Deployer")
-	if err := deployer.Run(ctx); err != nil {
-		logger.Fatalf("Deployer failed: %v", err)
-	}
+	// SIGHUP reopens the log file after logrotate has moved it and reloads
+	// the config
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
 
-	logger.Println("Deployer stopped gracefully")
-}
-
-func setupLogging(config *Config) *log.Logger {
-	// Set log output
-	if config.Logging.File != "" {
-		file, err := os.OpenFile(config.Logging.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o666)
-		if err == nil {
-			return log.New(file, "", log.LstdFlags|log.Lshortfile)
-		} else {
-			log.Printf("Failed to open log file %s: %v", config.Logging.File, err)
+	go func() {
+		for range hupChan {
+			if err := logFile.Reopen(); err != nil {
+				logger.Error("Failed to reopen log file", "error", err)
+			} else {
+				logger.Info("Reopened log file")
+			}
+			daemon.reloadFile(configPath, *app)
 		}
+	}()
+
+	if config.WatchConfig {
+		go watchConfig(ctx, configPath, configWatchInterval, func() {
+			daemon.reloadFile(configPath, *app)
+		})
+	}
+
+	// Start the deployer
+	logger.Info("Starting GitHub Release Deployer", "version", Version)
+	if err := daemon.Run(ctx); err != nil {
+		logger.Error("Deployer failed", "error", err)
+		os.Exit(1)
 	}
 
-	return log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)
+	logger.Info("Deployer stopped gracefully")
 }
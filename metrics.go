@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics holds the deployer's Prometheus metrics. It renders the text
+// exposition format itself to avoid a client library dependency.
+type Metrics struct {
+	mu  sync.Mutex
+	app string // labels every series when several apps share the process
+
+	checks          uint64
+	deployments     map[string]uint64 // by outcome
+	rollbacks       uint64
+	downloadBytes   uint64
+	downloadSeconds float64
+	lastDownload    float64
+	lastSuccess     time.Time
+	activeTag       string
+	activeSlot      string
+	paused          bool
+}
+
+// NewMetrics creates an empty metrics set
+func NewMetrics() *Metrics {
+	return &Metrics{deployments: map[string]uint64{"success": 0, "failure": 0}}
+}
+
+// CheckStarted counts a release check
+func (m *Metrics) CheckStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checks++
+}
+
+// CheckSucceeded records the time of a successful check or deployment
+func (m *Metrics) CheckSucceeded() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSuccess = time.Now()
+}
+
+// DeploymentFinished counts a deployment by outcome
+func (m *Metrics) DeploymentFinished(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.deployments["failure"]++
+		return
+	}
+	m.deployments["success"]++
+	m.lastSuccess = time.Now()
+}
+
+// RollbackFinished counts a rollback
+func (m *Metrics) RollbackFinished() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollbacks++
+}
+
+// Downloaded records a completed asset download
+func (m *Metrics) Downloaded(bytes int64, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downloadBytes += uint64(bytes)
+	m.downloadSeconds += duration.Seconds()
+	m.lastDownload = duration.Seconds()
+}
+
+// SetActive records the live version and slot
+func (m *Metrics) SetActive(tag, slot string, paused bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.activeTag, m.activeSlot, m.paused = tag, slot, paused
+}
+
+// WriteText writes the metrics in the Prometheus text format. rateLimit is
+// the remaining GitHub API quota, or negative if it is not known yet.
+func (m *Metrics) WriteText(w io.Writer, rateLimit int) error {
+	return writeMetrics(w, []*Metrics{m}, rateLimit)
+}
+
+// writeMetrics writes the metrics of every app in the Prometheus text
+// format, each family once with a series per app
+func writeMetrics(w io.Writer, sets []*Metrics, rateLimit int) error {
+	for _, m := range sets {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+	}
+
+	var b bytes.Buffer
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	metric("deployer_checks_total", "counter", "Number of release checks.")
+	for _, m := range sets {
+		fmt.Fprintf(&b, "deployer_checks_total%s %d\n", m.labels(), m.checks)
+	}
+
+	metric("deployer_deployments_total", "counter", "Number of deployments by outcome.")
+	for _, m := range sets {
+		outcomes := make([]string, 0, len(m.deployments))
+		for outcome := range m.deployments {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+		for _, outcome := range outcomes {
+			fmt.Fprintf(&b, "deployer_deployments_total%s %d\n", m.labels("outcome", outcome), m.deployments[outcome])
+		}
+	}
+
+	metric("deployer_rollbacks_total", "counter", "Number of rollbacks.")
+	for _, m := range sets {
+		fmt.Fprintf(&b, "deployer_rollbacks_total%s %d\n", m.labels(), m.rollbacks)
+	}
+
+	metric("deployer_active_version_info", "gauge", "The live release tag and slot.")
+	for _, m := range sets {
+		if m.activeTag != "" {
+			fmt.Fprintf(&b, "deployer_active_version_info%s 1\n", m.labels("tag", m.activeTag, "slot", m.activeSlot))
+		}
+	}
+
+	metric("deployer_paused", "gauge", "Whether automatic deployments are paused.")
+	for _, m := range sets {
+		paused := 0
+		if m.paused {
+			paused = 1
+		}
+		fmt.Fprintf(&b, "deployer_paused%s %d\n", m.labels(), paused)
+	}
+
+	metric("deployer_last_success_timestamp", "gauge", "Unix time of the last successful check or deployment.")
+	for _, m := range sets {
+		if !m.lastSuccess.IsZero() {
+			fmt.Fprintf(&b, "deployer_last_success_timestamp%s %d\n", m.labels(), m.lastSuccess.Unix())
+		}
+	}
+
+	metric("deployer_download_bytes_total", "counter", "Bytes of release assets downloaded.")
+	for _, m := range sets {
+		fmt.Fprintf(&b, "deployer_download_bytes_total%s %d\n", m.labels(), m.downloadBytes)
+	}
+
+	metric("deployer_download_seconds_total", "counter", "Time spent downloading release assets.")
+	for _, m := range sets {
+		fmt.Fprintf(&b, "deployer_download_seconds_total%s %g\n", m.labels(), m.downloadSeconds)
+	}
+
+	metric("deployer_last_download_duration_seconds", "gauge", "Duration of the most recent asset download.")
+	for _, m := range sets {
+		fmt.Fprintf(&b, "deployer_last_download_duration_seconds%s %g\n", m.labels(), m.lastDownload)
+	}
+
+	// The GitHub client, and so its quota, is shared by every app
+	metric("deployer_github_rate_limit_remaining", "gauge", "Remaining GitHub API requests in the current window.")
+	if rateLimit >= 0 {
+		fmt.Fprintf(&b, "deployer_github_rate_limit_remaining %d\n", rateLimit)
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// labels renders a label set from name/value pairs, led by the app label
+// when the metrics belong to a named app. The caller must hold mu.
+func (m *Metrics) labels(pairs ...string) string {
+	if m.app != "" {
+		pairs = append([]string{"app", m.app}, pairs...)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", pairs[i], escapeLabel(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// metricsHandler serves the metrics for Prometheus to scrape
+func (dm *Daemon) metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = dm.writeMetrics(w)
+	})
+}
+
+// writeMetrics writes the metrics of every app
+func (dm *Daemon) writeMetrics(w io.Writer) error {
+	sets := make([]*Metrics, 0, len(dm.apps))
+	for _, app := range dm.apps {
+		sets = append(sets, app.metrics)
+	}
+	return writeMetrics(w, sets, dm.github.RateLimitRemaining())
+}
+
+// startMetricsServer serves /metrics if metrics.listen is configured
+func (dm *Daemon) startMetricsServer() (*http.Server, error) {
+	addr := dm.config.Metrics.Listen
+	if addr == "" {
+		return nil, nil
+	}
+	listener, err := listenAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", dm.metricsHandler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			dm.logger.Error("Metrics server stopped", "error", err)
+		}
+	}()
+	dm.logger.Info("Serving metrics", "address", addr)
+	return srv, nil
+}
+
+// stopServer shuts an HTTP server down, waiting briefly for requests in flight
+func (dm *Daemon) stopServer(srv *http.Server) {
+	if srv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		dm.logger.Warn("Server shutdown failed", "error", err)
+	}
+}
+
+// writeMetricsTextfile writes the metrics for node_exporter's textfile
+// collector, if metrics.textfile is configured. The file is replaced
+// atomically so the collector never reads a partial file.
+func (dm *Daemon) writeMetricsTextfile() {
+	path := dm.config.Metrics.Textfile
+	if path == "" {
+		return
+	}
+	// Apps finish operations concurrently; keep their writes in order
+	dm.textfileMu.Lock()
+	defer dm.textfileMu.Unlock()
+	var b bytes.Buffer
+	_ = dm.writeMetrics(&b)
+	if err := writeFileAtomic(path, b.Bytes(), 0o644); err != nil {
+		dm.logger.Warn("Failed to write metrics textfile", "file", path, "error", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWriteText(t *testing.T) {
+	m := NewMetrics()
+	m.CheckStarted()
+	m.CheckStarted()
+	m.DeploymentFinished(nil)
+	m.DeploymentFinished(errors.New("boom"))
+	m.RollbackFinished()
+	m.Downloaded(2048, 1500*time.Millisecond)
+	m.SetActive(`v1.0.0"x`, "green", true)
+
+	var b strings.Builder
+	if err := m.WriteText(&b, 42); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE deployer_checks_total counter\ndeployer_checks_total 2\n",
+		"deployer_deployments_total{outcome=\"failure\"} 1\n",
+		"deployer_deployments_total{outcome=\"success\"} 1\n",
+		"deployer_rollbacks_total 1\n",
+		"deployer_active_version_info{tag=\"v1.0.0\\\"x\",slot=\"green\"} 1\n",
+		"deployer_paused 1\n",
+		"deployer_last_success_timestamp ",
+		"deployer_download_bytes_total 2048\n",
+		"deployer_last_download_duration_seconds 1.5\n",
+		"deployer_github_rate_limit_remaining 42\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Metrics output missing %q:\n%s", want, out)
+		}
+	}
+
+	// Unknown values are omitted rather than reported as zero
+	b.Reset()
+	_ = NewMetrics().WriteText(&b, -1)
+	for _, absent := range []string{"\ndeployer_github_rate_limit_remaining ", "\ndeployer_last_success_timestamp ", "\ndeployer_active_version_info{"} {
+		if strings.Contains(b.String(), absent) {
+			t.Errorf("Expected %q to be omitted before it is known", absent)
+		}
+	}
+}
+
+func TestDeployUpdatesMetricsAndTextfile(t *testing.T) {
+	server := newTestReleaseServer(t, "v1.0.0", map[string]string{"app.txt": "v1"})
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.Metrics.Textfile = filepath.Join(t.TempDir(), "gh_deployer.prom")
+	d := newTestDeployer(t, config, server)
+	dm := newTestDaemon(d)
+
+	d.runCheck(context.Background(), "Deployment check failed")
+
+	data, err := os.ReadFile(config.Metrics.Textfile)
+	if err != nil {
+		t.Fatalf("Metrics textfile was not written: %v", err)
+	}
+	for _, want := range []string{
+		"deployer_checks_total 1\n",
+		"deployer_deployments_total{outcome=\"success\"} 1\n",
+		"deployer_active_version_info{tag=\"v1.0.0\",slot=\"green\"} 1\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Metrics textfile missing %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "deployer_download_bytes_total 0\n") {
+		t.Errorf("Expected download bytes to be counted:\n%s", data)
+	}
+
+	metrics := httptest.NewServer(dm.metricsHandler())
+	defer metrics.Close()
+	resp, err := http.Get(metrics.URL)
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "deployer_checks_total 1\n") {
+		t.Errorf("Scrape missing checks counter:\n%s", body)
+	}
+}
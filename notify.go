@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// Notification events
+const (
+	eventDeploySuccess      = "deploy_success"
+	eventDeployFailure      = "deploy_failure"
+	eventRollback           = "rollback"
+	eventHealthCheckFailure = "health_check_failure"
+)
+
+// allEvents lists every notification event, in documentation order
+var allEvents = []string{eventDeploySuccess, eventDeployFailure, eventRollback, eventHealthCheckFailure}
+
+// defaultTemplates are the messages used unless notifications.templates overrides them
+var defaultTemplates = map[string]string{
+	eventDeploySuccess:      "Deployed {{.Repo}} {{.Tag}} to the {{.Slot}} slot on {{.Host}}",
+	eventDeployFailure:      "Deployment of {{.Repo}} {{.Tag}} failed on {{.Host}}: {{.Error}}",
+	eventRollback:           "Rolled back {{.Repo}} to {{.Tag}} in the {{.Slot}} slot on {{.Host}}{{if .Error}}: {{.Error}}{{end}}",
+	eventHealthCheckFailure: "Health check failed for {{.Repo}} {{.Tag}} on {{.Host}}: {{.Error}}",
+}
+
+// Notification defaults, used when the corresponding setting is zero
+const (
+	defaultNotifyAttempts  = 3
+	defaultNotifyRateLimit = time.Hour
+	defaultNotifyRetryWait = 2 * time.Second
+	notifySendTimeout      = 10 * time.Second
+	notifyCloseTimeout     = 30 * time.Second
+)
+
+// Notification describes a deployment event. Its fields are available to
+// message templates.
+type Notification struct {
+	Event        string    `json:"event"`
+	App          string    `json:"app,omitempty"`
+	Repo         string    `json:"repo"`
+	Tag          string    `json:"tag"`
+	PreviousTag  string    `json:"previous_tag,omitempty"`
+	Slot         string    `json:"slot"`
+	Host         string    `json:"host"`
+	Error        string    `json:"error,omitempty"`
+	ReleaseNotes string    `json:"release_notes,omitempty"`
+	Time         time.Time `json:"time"`
+	Message      string    `json:"message"`
+}
+
+// releaseNotesLimit caps the release notes excerpt included in notifications
+const releaseNotesLimit = 1000
+
+// releaseNotesExcerpt shortens release notes to at most releaseNotesLimit
+// bytes, cutting at a line break where possible
+func releaseNotesExcerpt(notes string) string {
+	notes = strings.TrimSpace(strings.ReplaceAll(notes, "\r\n", "\n"))
+	if len(notes) <= releaseNotesLimit {
+		return notes
+	}
+	cut := releaseNotesLimit
+	for cut > 0 && !utf8.RuneStart(notes[cut]) {
+		cut--
+	}
+	if nl := strings.LastIndexByte(notes[:cut], '\n'); nl > releaseNotesLimit/2 {
+		cut = nl
+	}
+	return strings.TrimSpace(notes[:cut]) + "\n…"
+}
+
+// notificationSink delivers notifications to one destination
+type notificationSink interface {
+	Name() string
+	Send(ctx context.Context, n *Notification) error
+}
+
+// sinkEntry is a sink with the events it subscribes to
+type sinkEntry struct {
+	sink   notificationSink
+	events []string
+}
+
+// Notifier renders notifications and delivers them to every subscribed sink
+// in the background, retrying failed sends and suppressing repeats of the
+// same event for the same app and tag within the rate limit window
+type Notifier struct {
+	logger    *slog.Logger
+	sinks     []sinkEntry
+	templates map[string]*template.Template
+	host      string
+	attempts  int
+	retryWait time.Duration
+	rateLimit time.Duration
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	wg       sync.WaitGroup
+}
+
+// NewNotifier creates a notifier for the notification settings. It returns
+// nil if no destinations are configured.
+func NewNotifier(cfg NotificationsConfig, logger *slog.Logger) (*Notifier, error) {
+	var sinks []sinkEntry
+	for i, wh := range cfg.Webhooks {
+		sink, err := newWebhookSink(wh)
+		if err != nil {
+			return nil, fmt.Errorf("notifications.webhooks[%d]: %w", i, err)
+		}
+		sinks = append(sinks, sinkEntry{sink: sink, events: wh.Events})
+	}
+	if cfg.Email.Host != "" {
+		sink, err := newEmailSink(cfg.Email)
+		if err != nil {
+			return nil, fmt.Errorf("notifications.email: %w", err)
+		}
+		sinks = append(sinks, sinkEntry{sink: sink, events: cfg.Email.Events})
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+
+	n := &Notifier{
+		logger:    logger,
+		sinks:     sinks,
+		templates: make(map[string]*template.Template),
+		attempts:  defaultNotifyAttempts,
+		retryWait: defaultNotifyRetryWait,
+		rateLimit: defaultNotifyRateLimit,
+		lastSent:  make(map[string]time.Time),
+	}
+	if cfg.Attempts > 0 {
+		n.attempts = cfg.Attempts
+	}
+	if cfg.RateLimitSecs != 0 {
+		n.rateLimit = time.Duration(cfg.RateLimitSecs) * time.Second
+	}
+	n.host, _ = os.Hostname()
+
+	events := cfg.Events
+	if len(events) == 0 {
+		events = allEvents
+	}
+	for _, event := range events {
+		if !slices.Contains(allEvents, event) {
+			return nil, fmt.Errorf("unknown notification event %q", event)
+		}
+	}
+	for i := range n.sinks {
+		if len(n.sinks[i].events) == 0 {
+			n.sinks[i].events = events
+		}
+		for _, event := range n.sinks[i].events {
+			if !slices.Contains(allEvents, event) {
+				return nil, fmt.Errorf("unknown notification event %q", event)
+			}
+		}
+	}
+
+	for _, event := range allEvents {
+		text := defaultTemplates[event]
+		if custom, ok := cfg.Templates[event]; ok {
+			text = custom
+		}
+		tmpl, err := template.New(event).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for %s: %w", event, err)
+		}
+		n.templates[event] = tmpl
+	}
+	for event := range cfg.Templates {
+		if !slices.Contains(allEvents, event) {
+			return nil, fmt.Errorf("template for unknown notification event %q", event)
+		}
+	}
+	return n, nil
+}
+
+// Notify sends the notification to every sink subscribed to its event without
+// blocking the caller. It is safe to call on a nil notifier.
+func (n *Notifier) Notify(event Notification) {
+	if n == nil {
+		return
+	}
+	event.Host = n.host
+	event.Time = time.Now().UTC()
+
+	// Apps sharing the notifier may release the same tag independently
+	if !n.allow(strings.Join([]string{event.Event, event.App, event.Repo, event.Tag}, "\x00")) {
+		n.logger.Info("Notification suppressed by rate limit", "event", event.Event, "app", event.App, "tag", event.Tag)
+		return
+	}
+
+	var msg bytes.Buffer
+	if err := n.templates[event.Event].Execute(&msg, event); err != nil {
+		n.logger.Warn("Failed to render notification", "event", event.Event, "error", err)
+		return
+	}
+	event.Message = msg.String()
+
+	for _, entry := range n.sinks {
+		if !slices.Contains(entry.events, event.Event) {
+			continue
+		}
+		n.wg.Add(1)
+		go func(sink notificationSink) {
+			defer n.wg.Done()
+			n.deliver(sink, &event)
+		}(entry.sink)
+	}
+}
+
+// allow reports whether a notification with key may be sent now
+func (n *Notifier) allow(key string) bool {
+	if n.rateLimit <= 0 {
+		return true
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	if last, ok := n.lastSent[key]; ok && now.Sub(last) < n.rateLimit {
+		return false
+	}
+	n.lastSent[key] = now
+	return true
+}
+
+// deliver sends to one sink, retrying with a doubling delay
+func (n *Notifier) deliver(sink notificationSink, event *Notification) {
+	wait := n.retryWait
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), notifySendTimeout)
+		err := sink.Send(ctx, event)
+		cancel()
+		if err == nil {
+			n.logger.Debug("Notification sent", "event", event.Event, "sink", sink.Name())
+			return
+		}
+		if attempt >= n.attempts {
+			n.logger.Warn("Failed to send notification", "event", event.Event, "sink", sink.Name(), "attempts", attempt, "error", err)
+			return
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// Close waits, for a bounded time, for notifications still being sent. It
+// is safe to call on a nil notifier.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(notifyCloseTimeout):
+		n.logger.Warn("Gave up waiting for notifications to be sent")
+	}
+}
+
+// webhookSink posts notifications to an HTTP endpoint
+type webhookSink struct {
+	url     string
+	format  string
+	headers map[string]string
+	client  *http.Client
+}
+
+// newWebhookSink validates a webhook configuration
+func newWebhookSink(cfg WebhookConfig) (*webhookSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	format := cfg.Format
+	if format == "" {
+		format = "json"
+	}
+	switch format {
+	case "json", "slack", "teams":
+	default:
+		return nil, fmt.Errorf("unknown webhook format %q", cfg.Format)
+	}
+	return &webhookSink{
+		url:     cfg.URL,
+		format:  format,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: notifySendTimeout},
+	}, nil
+}
+
+// Name identifies the sink in logs without exposing tokens in the URL path
+func (s *webhookSink) Name() string {
+	host := s.url
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexByte(host, '/'); i >= 0 {
+		host = host[:i]
+	}
+	return s.format + " webhook " + host
+}
+
+// Send posts the notification in the sink's payload format
+func (s *webhookSink) Send(ctx context.Context, n *Notification) error {
+	body, err := json.Marshal(s.payload(n))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// payload builds the request body for the sink's format
+func (s *webhookSink) payload(n *Notification) any {
+	switch s.format {
+	case "slack":
+		return map[string]string{"text": n.Message}
+	case "teams":
+		color := "2EB886"
+		if n.Error != "" {
+			color = "D50200"
+		}
+		return map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    n.Message,
+			"themeColor": color,
+			"title":      "gh-deployer: " + strings.ReplaceAll(n.Event, "_", " "),
+			"text":       n.Message,
+		}
+	}
+	return n
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder is a webhook endpoint that records request bodies and
+// fails the first failures requests
+type webhookRecorder struct {
+	mu       sync.Mutex
+	bodies   []string
+	failures int
+}
+
+func newWebhookRecorder(t *testing.T, failures int) (*webhookRecorder, *httptest.Server) {
+	t.Helper()
+	rec := &webhookRecorder{failures: failures}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if rec.failures > 0 {
+			rec.failures--
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		rec.bodies = append(rec.bodies, string(body))
+	}))
+	t.Cleanup(server.Close)
+	return rec, server
+}
+
+func (r *webhookRecorder) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.bodies...)
+}
+
+func newTestNotifier(t *testing.T, cfg NotificationsConfig) *Notifier {
+	t.Helper()
+	n, err := NewNotifier(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewNotifier failed: %v", err)
+	}
+	n.host = "web-1"
+	n.retryWait = time.Millisecond
+	return n
+}
+
+func TestNotifierPayloadFormats(t *testing.T) {
+	generic, genericServer := newWebhookRecorder(t, 0)
+	slack, slackServer := newWebhookRecorder(t, 0)
+	teams, teamsServer := newWebhookRecorder(t, 0)
+
+	n := newTestNotifier(t, NotificationsConfig{Webhooks: []WebhookConfig{
+		{URL: genericServer.URL},
+		{URL: slackServer.URL, Format: "slack"},
+		{URL: teamsServer.URL, Format: "teams"},
+	}})
+	n.Notify(Notification{Event: eventDeployFailure, Repo: "owner/app", Tag: "v2.0.0", Slot: "green", Error: "boom"})
+	n.Close()
+
+	want := "Deployment of owner/app v2.0.0 failed on web-1: boom"
+
+	var payload Notification
+	if bodies := generic.received(); len(bodies) != 1 {
+		t.Fatalf("Expected 1 generic webhook call, got %d", len(bodies))
+	} else if err := json.Unmarshal([]byte(bodies[0]), &payload); err != nil {
+		t.Fatalf("Invalid generic payload: %v", err)
+	}
+	if payload.Event != eventDeployFailure || payload.Tag != "v2.0.0" || payload.Slot != "green" ||
+		payload.Host != "web-1" || payload.Error != "boom" || payload.Message != want {
+		t.Errorf("Unexpected generic payload: %+v", payload)
+	}
+
+	var slackPayload map[string]string
+	if bodies := slack.received(); len(bodies) != 1 {
+		t.Fatalf("Expected 1 Slack webhook call, got %d", len(bodies))
+	} else if err := json.Unmarshal([]byte(bodies[0]), &slackPayload); err != nil {
+		t.Fatalf("Invalid Slack payload: %v", err)
+	}
+	if slackPayload["text"] != want {
+		t.Errorf("Unexpected Slack text %q", slackPayload["text"])
+	}
+
+	var card map[string]string
+	if bodies := teams.received(); len(bodies) != 1 {
+		t.Fatalf("Expected 1 Teams webhook call, got %d", len(bodies))
+	} else if err := json.Unmarshal([]byte(bodies[0]), &card); err != nil {
+		t.Fatalf("Invalid Teams payload: %v", err)
+	}
+	if card["@type"] != "MessageCard" || card["text"] != want || card["themeColor"] != "D50200" {
+		t.Errorf("Unexpected Teams card: %v", card)
+	}
+}
+
+func TestNotifierCustomTemplateAndEventFilter(t *testing.T) {
+	rec, server := newWebhookRecorder(t, 0)
+	n := newTestNotifier(t, NotificationsConfig{
+		Events:    []string{eventDeploySuccess},
+		Templates: map[string]string{eventDeploySuccess: "{{.Host}}: {{.PreviousTag}} to {{.Tag}}"},
+		Webhooks:  []WebhookConfig{{URL: server.URL, Format: "slack"}},
+	})
+	n.Notify(Notification{Event: eventRollback, Tag: "v1.0.0"})
+	n.Notify(Notification{Event: eventDeploySuccess, Tag: "v2.0.0", PreviousTag: "v1.0.0"})
+	n.Close()
+
+	bodies := rec.received()
+	if len(bodies) != 1 || !strings.Contains(bodies[0], `"web-1: v1.0.0 to v2.0.0"`) {
+		t.Errorf("Expected only the templated deploy_success message, got %q", bodies)
+	}
+}
+
+func TestNotifierRetriesFailedSends(t *testing.T) {
+	rec, server := newWebhookRecorder(t, 2)
+	n := newTestNotifier(t, NotificationsConfig{Webhooks: []WebhookConfig{{URL: server.URL}}})
+	n.Notify(Notification{Event: eventDeploySuccess, Tag: "v2.0.0"})
+	n.Close()
+	if got := len(rec.received()); got != 1 {
+		t.Errorf("Expected the third attempt to succeed, got %d deliveries", got)
+	}
+
+	rec, server = newWebhookRecorder(t, 5)
+	n = newTestNotifier(t, NotificationsConfig{Attempts: 2, Webhooks: []WebhookConfig{{URL: server.URL}}})
+	n.Notify(Notification{Event: eventDeploySuccess, Tag: "v2.0.0"})
+	n.Close()
+	if got := len(rec.received()); got != 0 {
+		t.Errorf("Expected no delivery after 2 failed attempts, got %d", got)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.failures != 3 {
+		t.Errorf("Expected exactly 2 attempts, %d failures left of 5", rec.failures)
+	}
+}
+
+func TestNotifierRateLimitsRepeats(t *testing.T) {
+	rec, server := newWebhookRecorder(t, 0)
+	n := newTestNotifier(t, NotificationsConfig{Webhooks: []WebhookConfig{{URL: server.URL}}})
+	for i := 0; i < 3; i++ {
+		n.Notify(Notification{Event: eventDeployFailure, Tag: "v2.0.0", Error: "boom"})
+	}
+	n.Notify(Notification{Event: eventDeployFailure, Tag: "v2.0.1", Error: "boom"})
+	n.Close()
+	if got := len(rec.received()); got != 2 {
+		t.Errorf("Expected one notification per tag, got %d", got)
+	}
+
+	// Apps sharing a notifier are rate limited separately
+	rec, server = newWebhookRecorder(t, 0)
+	n = newTestNotifier(t, NotificationsConfig{Webhooks: []WebhookConfig{{URL: server.URL}}})
+	for _, app := range []string{"api", "web", "api"} {
+		n.Notify(Notification{Event: eventDeployFailure, App: app, Repo: "owner/" + app, Tag: "v1.0.0", Error: "boom"})
+	}
+	n.Close()
+	if got := len(rec.received()); got != 2 {
+		t.Errorf("Expected one notification per app, got %d", got)
+	}
+
+	rec, server = newWebhookRecorder(t, 0)
+	n = newTestNotifier(t, NotificationsConfig{RateLimitSecs: -1, Webhooks: []WebhookConfig{{URL: server.URL}}})
+	for i := 0; i < 3; i++ {
+		n.Notify(Notification{Event: eventDeployFailure, Tag: "v2.0.0", Error: "boom"})
+	}
+	n.Close()
+	if got := len(rec.received()); got != 3 {
+		t.Errorf("Expected every notification with rate limiting disabled, got %d", got)
+	}
+}
+
+func TestNewNotifierValidation(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if n, err := NewNotifier(NotificationsConfig{}, logger); n != nil || err != nil {
+		t.Errorf("Expected no notifier without destinations, got %v, %v", n, err)
+	}
+	for name, cfg := range map[string]NotificationsConfig{
+		"missing url":    {Webhooks: []WebhookConfig{{Format: "slack"}}},
+		"unknown format": {Webhooks: []WebhookConfig{{URL: "http://x", Format: "irc"}}},
+		"unknown event":  {Events: []string{"deployed"}, Webhooks: []WebhookConfig{{URL: "http://x"}}},
+		"bad template":   {Templates: map[string]string{eventRollback: "{{.Tag"}, Webhooks: []WebhookConfig{{URL: "http://x"}}},
+	} {
+		if _, err := NewNotifier(cfg, logger); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDeployNotifiesOnSuccessAndHealthCheckFailure(t *testing.T) {
+	rec, hookServer := newWebhookRecorder(t, 0)
+
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.Notifications.Webhooks = []WebhookConfig{{URL: hookServer.URL}}
+
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+	deployer := newTestDeployer(t, config, server)
+	deployer.notifier.retryWait = time.Millisecond
+	if err := deployer.checkAndDeploy(context.Background()); err != nil {
+		t.Fatalf("Deployment failed: %v", err)
+	}
+	deployer.notifier.Close()
+
+	bodies := rec.received()
+	if len(bodies) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(bodies))
+	}
+	var n Notification
+	if err := json.Unmarshal([]byte(bodies[0]), &n); err != nil {
+		t.Fatalf("Invalid payload: %v", err)
+	}
+	if n.Event != eventDeploySuccess || n.Tag != "v2.0.0" || n.PreviousTag != "v1.0.0" || n.Slot != "green" {
+		t.Errorf("Unexpected notification: %+v", n)
+	}
+
+	// A failed health check gets its own event
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(unhealthy.Close)
+	config = setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.HealthCheckURL = unhealthy.URL
+	config.HealthCheckTimeout = 1
+	config.Notifications.Webhooks = []WebhookConfig{{URL: hookServer.URL}}
+
+	deployer = newTestDeployer(t, config, server)
+	if err := deployer.checkAndDeploy(context.Background()); err == nil {
+		t.Fatal("Expected the deployment to fail its health check")
+	}
+	deployer.notifier.Close()
+
+	bodies = rec.received()
+	if len(bodies) != 2 {
+		t.Fatalf("Expected 2 notifications, got %d", len(bodies))
+	}
+	if err := json.Unmarshal([]byte(bodies[1]), &n); err != nil {
+		t.Fatalf("Invalid payload: %v", err)
+	}
+	if n.Event != eventHealthCheckFailure || !strings.Contains(n.Error, "health check failed") {
+		t.Errorf("Unexpected notification: %+v", n)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DeployPlan describes what deploying a release would change, worked out
+// without touching the slots, the symlink or the state
+type DeployPlan struct {
+	App          string        `json:"app,omitempty"`
+	Repo         string        `json:"repo"`
+	Tag          string        `json:"tag"`
+	Asset        string        `json:"asset"`
+	CurrentTag   string        `json:"current_tag"`
+	ActiveSlot   string        `json:"active_slot"`
+	TargetSlot   string        `json:"target_slot"`
+	UpToDate     bool          `json:"up_to_date"`
+	Added        []FileChange  `json:"added"`
+	Removed      []FileChange  `json:"removed"`
+	Modified     []FileChange  `json:"modified"`
+	SizeDelta    int64         `json:"size_delta"`
+	Hooks        []PlannedHook `json:"hooks"`
+	HealthCheck  string        `json:"health_check,omitempty"`
+	RestartUnits []string      `json:"restart_units,omitempty"`
+}
+
+// FileChange is a file that a deployment would add, remove or modify,
+// relative to the slot directory
+type FileChange struct {
+	Path    string `json:"path"`
+	OldSize int64  `json:"old_size"`
+	NewSize int64  `json:"new_size"`
+}
+
+// PlannedHook is a hook that a successful deployment would run
+type PlannedHook struct {
+	Phase   string `json:"phase"`
+	Command string `json:"command"`
+}
+
+// plannedPhases are the hooks a successful deployment runs, in order
+var plannedPhases = []string{hookPreDownload, hookPostExtract, hookInstall, hookPreSwitch, hookPostSwitch, hookPostDeploy}
+
+// Plan works out what deploying tag, or the latest release if tag is empty,
+// would change. The release is downloaded, verified and extracted into a
+// temporary directory and compared with the active slot.
+func (d *Deployer) Plan(ctx context.Context, tag string) (*DeployPlan, error) {
+	var release *Release
+	var err error
+	if tag == "" {
+		release, err = d.github.GetLatestRelease(ctx, d.config.Repo)
+	} else {
+		release, err = d.github.GetReleaseByTag(ctx, d.config.Repo, tag)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get release: %w", err)
+	}
+	return d.planRelease(ctx, release)
+}
+
+// planRelease works out what deploying release would change
+func (d *Deployer) planRelease(ctx context.Context, release *Release) (*DeployPlan, error) {
+	asset, err := release.FindAssetWithSuffix(d.config.AssetSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find asset: %w", err)
+	}
+	plan := &DeployPlan{
+		App:         d.config.Name,
+		Repo:        d.config.Repo,
+		Tag:         release.TagName,
+		Asset:       asset.Name,
+		CurrentTag:  d.getCurrentVersion(),
+		ActiveSlot:  d.state.ActiveSlot,
+		TargetSlot:  d.state.GetInactiveSlot(),
+		HealthCheck: d.config.HealthCheckURL,
+	}
+	plan.UpToDate = plan.Tag == plan.CurrentTag
+
+	dir, err := os.MkdirTemp("", "gh-deployer-plan-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plan directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	ctx = withLogger(ctx, d.logger.With("tag", release.TagName, "action", "plan"))
+	d.setStatus("Planning %s", release.TagName)
+	assetPath, err := d.downloadAsset(ctx, release, asset, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.extractAsset(ctx, assetPath, dir); err != nil {
+		return nil, err
+	}
+
+	// Shared paths and the slot manifest are put in place by the deployer,
+	// and the archive and checksums file are downloaded beside the release,
+	// so none of them are part of what the release ships
+	skip := map[string]bool{slotManifestName: true}
+	for _, a := range release.Assets {
+		skip[a.Name] = true
+	}
+	for _, sp := range d.config.SharedPaths {
+		if rel, err := cleanSharedPath(sp.Path); err == nil {
+			skip[filepath.ToSlash(rel)] = true
+		}
+	}
+	next, err := snapshotTree(dir, skip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read release: %w", err)
+	}
+	current := map[string]fileSnapshot{}
+	if plan.CurrentTag != "" {
+		if current, err = snapshotTree(d.slotDir(d.state.ActiveSlot), skip); err != nil {
+			return nil, fmt.Errorf("failed to read %s slot: %w", d.state.ActiveSlot, err)
+		}
+	}
+	plan.diff(current, next)
+
+	for _, phase := range plannedPhases {
+		if hook := d.hookFor(phase); hook.Command != "" {
+			plan.Hooks = append(plan.Hooks, PlannedHook{Phase: phase, Command: hook.Command})
+		}
+	}
+	if d.config.Restart.Mode == "systemd" {
+		plan.RestartUnits = append(slices.Clone(d.config.Restart.Units), d.config.Restart.ReloadUnits...)
+	}
+	return plan, nil
+}
+
+// fileSnapshot is what a plan compares of each file
+type fileSnapshot struct {
+	size int64
+	mode fs.FileMode
+	hash string // SHA-256 of a regular file, or the target of a symlink
+}
+
+// snapshotTree records every file and symlink under root by slash-separated
+// relative path, leaving out the paths in skip
+func snapshotTree(root string, skip map[string]bool) (map[string]fileSnapshot, error) {
+	files := make(map[string]fileSnapshot)
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if skip[rel] {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		snapshot := fileSnapshot{size: info.Size(), mode: info.Mode()}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			snapshot.hash, err = os.Readlink(path)
+		} else if info.Mode().IsRegular() {
+			snapshot.hash, err = hashFile(path)
+		}
+		if err != nil {
+			return err
+		}
+		files[rel] = snapshot
+		return nil
+	})
+	return files, err
+}
+
+// hashFile returns the hex SHA-256 of a file's contents
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// logPlan logs a summary of the plan for deploying release, as a dry run
+// does instead of deploying
+func (d *Deployer) logPlan(ctx context.Context, release *Release) error {
+	plan, err := d.planRelease(ctx, release)
+	if err != nil {
+		return fmt.Errorf("failed to plan deployment: %w", err)
+	}
+	hooks := make([]string, len(plan.Hooks))
+	for i, hook := range plan.Hooks {
+		hooks[i] = hook.Phase
+	}
+	d.logger.Info("DRY RUN: Would deploy version", "tag", plan.Tag, "slot", plan.TargetSlot,
+		"added", len(plan.Added), "removed", len(plan.Removed), "modified", len(plan.Modified),
+		"size_delta", plan.SizeDelta, "hooks", strings.Join(hooks, ","))
+	return nil
+}
+
+// diff fills in the file changes from current to next
+func (p *DeployPlan) diff(current, next map[string]fileSnapshot) {
+	for path, n := range next {
+		c, ok := current[path]
+		switch {
+		case !ok:
+			p.Added = append(p.Added, FileChange{Path: path, NewSize: n.size})
+		case c != n:
+			p.Modified = append(p.Modified, FileChange{Path: path, OldSize: c.size, NewSize: n.size})
+		}
+		p.SizeDelta += n.size
+	}
+	for path, c := range current {
+		if _, ok := next[path]; !ok {
+			p.Removed = append(p.Removed, FileChange{Path: path, OldSize: c.size})
+		}
+		p.SizeDelta -= c.size
+	}
+	for _, changes := range [][]FileChange{p.Added, p.Removed, p.Modified} {
+		slices.SortFunc(changes, func(a, b FileChange) int { return strings.Compare(a.Path, b.Path) })
+	}
+}
+
+// Write prints the plan for a person to read
+func (p *DeployPlan) Write(out io.Writer) {
+	name := p.Repo
+	if p.App != "" {
+		name = p.App + " (" + p.Repo + ")"
+	}
+	fmt.Fprintf(out, "Plan for %s\n", name)
+	fmt.Fprintf(out, "  Release: %s (%s)\n", p.Tag, p.Asset)
+	fmt.Fprintf(out, "  Current: %s in %s slot\n", orNone(p.CurrentTag), p.ActiveSlot)
+	if p.UpToDate {
+		fmt.Fprintf(out, "  %s is already deployed, nothing would be deployed\n", p.Tag)
+		return
+	}
+	fmt.Fprintf(out, "  Target:  %s slot\n", p.TargetSlot)
+	fmt.Fprintf(out, "  Files:   %d added, %d removed, %d modified, %+d bytes\n",
+		len(p.Added), len(p.Removed), len(p.Modified), p.SizeDelta)
+	for _, c := range p.Added {
+		fmt.Fprintf(out, "    + %s (%d bytes)\n", c.Path, c.NewSize)
+	}
+	for _, c := range p.Removed {
+		fmt.Fprintf(out, "    - %s (%d bytes)\n", c.Path, c.OldSize)
+	}
+	for _, c := range p.Modified {
+		fmt.Fprintf(out, "    ~ %s (%d -> %d bytes)\n", c.Path, c.OldSize, c.NewSize)
+	}
+	if len(p.Hooks) == 0 {
+		fmt.Fprintln(out, "  Hooks:   none")
+	} else {
+		fmt.Fprintln(out, "  Hooks:")
+		for _, hook := range p.Hooks {
+			fmt.Fprintf(out, "    %s: %s\n", hook.Phase, hook.Command)
+		}
+	}
+	if p.HealthCheck != "" {
+		fmt.Fprintf(out, "  Health check: %s\n", p.HealthCheck)
+	}
+	if len(p.RestartUnits) > 0 {
+		fmt.Fprintf(out, "  Restart: %s\n", strings.Join(p.RestartUnits, ", "))
+	}
+}
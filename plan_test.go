@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanComparesReleaseWithActiveSlot(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.SharedPaths = []SharedPath{{Path: "data/"}}
+	config.Hooks.PreSwitch = HookConfig{Command: "./smoke-test.sh"}
+	config.RunCommand = "make install"
+
+	blue := filepath.Join(config.InstallDir, "blue")
+	for name, content := range map[string]string{
+		"app.py":      "print('v1')\n",
+		"README":      "same\n",
+		"old.py":      "gone\n",
+		"data/app.db": "kept by shared_paths",
+	} {
+		path := filepath.Join(blue, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeSlotManifest(blue, &SlotManifest{Tag: "v1.0.0", Slot: "blue"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(blue, config.CurrentSymlink); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{
+		"app.py": "print('version 2')\n",
+		"README": "same\n",
+		"new.py": "new\n",
+	})
+	deployer := newTestDeployer(t, config, server)
+
+	// The active slot holds the archive it was deployed from
+	if err := os.WriteFile(filepath.Join(blue, "app.tar.gz"), []byte("v1 archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := deployer.Plan(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if plan.Tag != "v2.0.0" || plan.CurrentTag != "v1.0.0" || plan.TargetSlot != "green" || plan.UpToDate {
+		t.Errorf("Unexpected plan: %+v", plan)
+	}
+	paths := func(changes []FileChange) string {
+		var names []string
+		for _, c := range changes {
+			names = append(names, c.Path)
+		}
+		return strings.Join(names, ",")
+	}
+	if got := paths(plan.Added); got != "new.py" {
+		t.Errorf("Expected new.py to be added, got %s", got)
+	}
+	if got := paths(plan.Removed); got != "old.py" {
+		t.Errorf("Expected old.py to be removed, got %s", got)
+	}
+	if got := paths(plan.Modified); got != "app.py" {
+		t.Errorf("Expected only app.py to be modified, not the archive, got %s", got)
+	}
+	var hooks []string
+	for _, hook := range plan.Hooks {
+		hooks = append(hooks, hook.Phase)
+	}
+	if strings.Join(hooks, ",") != "install,pre_switch" {
+		t.Errorf("Unexpected hooks: %v", hooks)
+	}
+
+	// Nothing was deployed
+	if deployer.state.ActiveSlot != "blue" || deployer.slotVersion("green") != "" {
+		t.Errorf("Expected state to be untouched, got %+v", deployer.state)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(config.InstallDir, "green")); len(entries) != 0 {
+		t.Errorf("Expected green slot to stay empty, got %d entries", len(entries))
+	}
+	if target, _ := os.Readlink(config.CurrentSymlink); target != blue {
+		t.Errorf("Expected symlink to stay on blue, got %s", target)
+	}
+
+	var out bytes.Buffer
+	plan.Write(&out)
+	for _, want := range []string{
+		"Release: v2.0.0 (app.tar.gz)",
+		"Current: v1.0.0 in blue slot",
+		"1 added, 1 removed, 1 modified",
+		"+ new.py (4 bytes)",
+		"- old.py (5 bytes)",
+		"pre_switch: ./smoke-test.sh",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in plan:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestPlanFirstDeploymentAddsEverything(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	server := newTestReleaseServer(t, "v1.0.0", map[string]string{"app.py": "print('hi')\n"})
+	deployer := newTestDeployer(t, config, server)
+
+	plan, err := deployer.Plan(context.Background(), "v1.0.0")
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(plan.Added) != 1 || plan.Added[0].Path != "app.py" || len(plan.Removed)+len(plan.Modified) != 0 {
+		t.Errorf("Expected only app.py to be added, not the archive, got %+v", plan)
+	}
+	if want := int64(len("print('hi')\n")); plan.SizeDelta != want {
+		t.Errorf("Expected a size delta of %d bytes, got %d", want, plan.SizeDelta)
+	}
+}
+
+func TestDryRunPlansEachReleaseOnce(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+	deployer := newTestDeployer(t, config, server)
+	deployer.dryRun = true
+
+	var downloaded []uint64
+	for i := 0; i < 2; i++ {
+		if err := deployer.checkAndDeploy(context.Background()); err != nil {
+			t.Fatalf("Dry run check failed: %v", err)
+		}
+		downloaded = append(downloaded, deployer.metrics.downloadBytes)
+	}
+	if deployer.plannedTag != "v2.0.0" || downloaded[0] == 0 || downloaded[1] != downloaded[0] {
+		t.Errorf("Expected v2.0.0 to be downloaded and planned once, got %q after %v bytes", deployer.plannedTag, downloaded)
+	}
+	if deployer.getCurrentVersion() != "v1.0.0" || len(deployer.state.History) != 0 {
+		t.Errorf("Expected a dry run to deploy nothing, got %+v", deployer.state)
+	}
+}
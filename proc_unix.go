@@ -0,0 +1,103 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup runs the command in its own process group so that
+// signals reach anything the shell started
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// configureHookProcess makes cancelling the command kill its whole process group
+func configureHookProcess(cmd *exec.Cmd) {
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd.Process)
+	}
+}
+
+// terminateProcessGroup asks a process group started with setProcessGroup to exit
+func terminateProcessGroup(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGTERM)
+}
+
+// killProcessGroup forcibly kills a process group started with setProcessGroup
+func killProcessGroup(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
+
+// setCommandCredential makes cmd run as the named user and/or group. When
+// only a group is given the command keeps the deployer's user. The returned
+// account is nil if no user was named.
+func setCommandCredential(cmd *exec.Cmd, userName, groupName string) (*user.User, error) {
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	var groups []uint32
+	var account *user.User
+
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return nil, fmt.Errorf("unknown hook user %q: %w", userName, err)
+		}
+		account = u
+		uid, gid, err = parseIDs(u.Uid, u.Gid)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := u.GroupIds()
+		if err == nil {
+			for _, id := range ids {
+				if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+					groups = append(groups, uint32(g))
+				}
+			}
+		}
+	}
+
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return nil, fmt.Errorf("unknown hook group %q: %w", groupName, err)
+		}
+		id, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gid %q for group %q", g.Gid, groupName)
+		}
+		gid = uint32(id)
+	}
+
+	if os.Getuid() != 0 && (uid != uint32(os.Getuid()) || gid != uint32(os.Getgid())) {
+		return nil, fmt.Errorf("running commands as %s:%s requires gh-deployer to run as root", userName, groupName)
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: gid, Groups: groups}
+	return account, nil
+}
+
+// parseIDs parses numeric user and group IDs
+func parseIDs(uidStr, gidStr string) (uint32, uint32, error) {
+	uid, err := strconv.ParseUint(uidStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid uid %q", uidStr)
+	}
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid gid %q", gidStr)
+	}
+	return uint32(uid), uint32(gid), nil
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+)
+
+// setProcessGroup is a no-op on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// configureHookProcess leaves the default cancellation, which kills only the
+// shell process itself
+func configureHookProcess(cmd *exec.Cmd) {}
+
+// terminateProcessGroup kills the process; Windows has no SIGTERM equivalent
+func terminateProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// killProcessGroup kills the process
+func killProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// setCommandCredential is not supported on Windows
+func setCommandCredential(cmd *exec.Cmd, userName, groupName string) (*user.User, error) {
+	return nil, errors.New("running commands as another user or group is not supported on Windows")
+}
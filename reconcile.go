@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// slotManifestName is the file written into each slot describing what it holds
+const slotManifestName = ".gh-deployer-slot.yaml"
+
+// SlotManifest records what was deployed into a slot directory
+type SlotManifest struct {
+	Tag        string    `yaml:"tag"`
+	Slot       string    `yaml:"slot"`
+	Asset      string    `yaml:"asset"`
+	DeployedAt time.Time `yaml:"deployed_at"`
+	// Staged marks a release waiting for deployments to be allowed, which
+	// has never been switched to
+	Staged bool `yaml:"staged,omitempty"`
+}
+
+// ReconcileReport describes the differences found between the state file
+// and the filesystem, and the repairs made to the state
+type ReconcileReport struct {
+	// LiveSlot is the slot current_symlink actually points to, or "" if it
+	// does not exist or points outside the install directory
+	LiveSlot string
+	Repairs  []string
+	Problems []string
+}
+
+// readSlotManifest reads the manifest from a slot directory
+func readSlotManifest(slotDir string) (*SlotManifest, error) {
+	data, err := os.ReadFile(filepath.Join(slotDir, slotManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m SlotManifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse slot manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// writeSlotManifest writes the manifest into a slot directory
+func writeSlotManifest(slotDir string, m *SlotManifest) error {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal slot manifest: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(slotDir, slotManifestName), data, 0o644)
+}
+
+// setSlotStaged marks the release in a slot as staged, or clears the mark
+// before switching to it
+func setSlotStaged(slotDir string, staged bool) error {
+	m, err := readSlotManifest(slotDir)
+	if err != nil {
+		return err
+	}
+	if m.Staged == staged {
+		return nil
+	}
+	m.Staged = staged
+	return writeSlotManifest(slotDir, m)
+}
+
+// removeSlotManifest removes the manifest so a partially written slot is
+// never mistaken for a complete deployment
+func removeSlotManifest(slotDir string) error {
+	err := os.Remove(filepath.Join(slotDir, slotManifestName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// slotDir returns the deployment directory for a slot
+func (d *Deployer) slotDir(slot string) string {
+	return filepath.Join(d.config.InstallDir, slot)
+}
+
+// liveSlot returns the slot current_symlink actually resolves to. It returns
+// "" if the symlink does not exist and an error if it points elsewhere.
+func (d *Deployer) liveSlot() (string, error) {
+	if d.config.CurrentSymlink == "" {
+		return "", nil
+	}
+	if _, err := os.Lstat(d.config.CurrentSymlink); os.IsNotExist(err) {
+		return "", nil
+	}
+	target, err := os.Stat(d.config.CurrentSymlink)
+	if err != nil {
+		return "", fmt.Errorf("current symlink %s is broken: %w", d.config.CurrentSymlink, err)
+	}
+	for _, slot := range []string{"blue", "green"} {
+		info, err := os.Stat(d.slotDir(slot))
+		if err == nil && os.SameFile(target, info) {
+			return slot, nil
+		}
+	}
+	return "", fmt.Errorf("current symlink %s does not point to a slot in %s",
+		d.config.CurrentSymlink, d.config.InstallDir)
+}
+
+// ensureSlotNotLive refuses to touch a slot that current_symlink points to,
+// whatever the state file claims
+func (d *Deployer) ensureSlotNotLive(slot string) error {
+	live, err := d.liveSlot()
+	if err != nil {
+		// A symlink pointing outside the slots cannot be the target slot
+		return nil
+	}
+	if live == slot {
+		return fmt.Errorf("refusing to deploy into %s slot: %s points to it", slot, d.config.CurrentSymlink)
+	}
+	return nil
+}
+
+// reconcile compares the state with current_symlink and the slot manifests,
+// repairs the in-memory state to match the filesystem and reports anything
+// it could not repair
+func (d *Deployer) reconcile() *ReconcileReport {
+	report := &ReconcileReport{}
+	if d.config.InstallDir == "" {
+		return report
+	}
+
+	live, err := d.liveSlot()
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+	}
+	report.LiveSlot = live
+
+	if live == "" && err == nil && d.getCurrentVersion() != "" {
+		report.Problems = append(report.Problems, fmt.Sprintf(
+			"state records %s as active on %s slot but %s does not exist",
+			d.getCurrentVersion(), d.state.ActiveSlot, d.config.CurrentSymlink))
+	}
+
+	for _, slot := range []string{"blue", "green"} {
+		dir := d.slotDir(slot)
+		recorded := d.slotVersion(slot)
+
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if recorded != "" {
+				d.setSlotVersion(slot, "")
+				report.Repairs = append(report.Repairs, fmt.Sprintf(
+					"%s slot directory is missing, cleared recorded version %s", slot, recorded))
+			}
+			continue
+		}
+
+		manifest, err := readSlotManifest(dir)
+		switch {
+		case os.IsNotExist(err):
+			// Deployed before manifests existed or never completed
+			if recorded != "" {
+				report.Problems = append(report.Problems, fmt.Sprintf(
+					"%s slot has no manifest, cannot verify recorded version %s", slot, recorded))
+			}
+		case err != nil:
+			report.Problems = append(report.Problems, fmt.Sprintf("%s slot: %v", slot, err))
+		case manifest.Staged && slot != live:
+			// Never deployed, so not a version to record or roll back to
+			d.stagedTag = manifest.Tag
+		case manifest.Tag != recorded:
+			d.setSlotVersion(slot, manifest.Tag)
+			report.Repairs = append(report.Repairs, fmt.Sprintf(
+				"%s slot holds %s according to its manifest, state recorded %q", slot, manifest.Tag, recorded))
+		}
+	}
+
+	if live != "" && live != d.state.ActiveSlot {
+		report.Repairs = append(report.Repairs, fmt.Sprintf(
+			"%s points to %s slot, state recorded %s as active", d.config.CurrentSymlink, live, d.state.ActiveSlot))
+		d.state.ActiveSlot = live
+	}
+
+	return report
+}
+
+// logRepairs logs the repairs reconcile made to the state
+func (d *Deployer) logRepairs(repairs []string) {
+	for _, repair := range repairs {
+		d.logger.Info("Reconciliation repaired state", "repair", repair)
+	}
+}
+
+// slotVersion returns the version recorded for a slot
+func (d *Deployer) slotVersion(slot string) string {
+	if slot == "blue" {
+		return d.state.BlueVersion
+	}
+	return d.state.GreenVersion
+}
+
+// setSlotVersion records the version deployed in a slot
+func (d *Deployer) setSlotVersion(slot, version string) {
+	if slot == "blue" {
+		d.state.BlueVersion = version
+	} else {
+		d.state.GreenVersion = version
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setupSlots creates blue and green slot directories, a state file and
+// returns a config pointing at them
+func setupSlots(t *testing.T, state *DeploymentState) *Config {
+	t.Helper()
+	tempDir := t.TempDir()
+	installDir := filepath.Join(tempDir, "deployments")
+	for _, slot := range []string{"blue", "green"} {
+		if err := os.MkdirAll(filepath.Join(installDir, slot), 0o755); err != nil {
+			t.Fatalf("Failed to create %s slot: %v", slot, err)
+		}
+	}
+	config := &Config{AppConfig: AppConfig{
+		InstallDir:     installDir,
+		CurrentSymlink: filepath.Join(tempDir, "current"),
+		StateFile:      filepath.Join(tempDir, "state.yaml"),
+	}}
+	if err := state.SaveState(config.StateFile); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	return config
+}
+
+func TestReconcileRepairsActiveSlotFromSymlink(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0", GreenVersion: "v1.1.0"})
+
+	// Someone manually repointed the symlink at green
+	if err := os.Symlink(filepath.Join(config.InstallDir, "green"), config.CurrentSymlink); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	deployer, err := NewDeployer(config, logger, false)
+	if err != nil {
+		t.Fatalf("Failed to create deployer: %v", err)
+	}
+
+	if deployer.state.ActiveSlot != "green" {
+		t.Errorf("Expected active slot repaired to 'green', got '%s'", deployer.state.ActiveSlot)
+	}
+	if deployer.getCurrentVersion() != "v1.1.0" {
+		t.Errorf("Expected current version 'v1.1.0', got '%s'", deployer.getCurrentVersion())
+	}
+
+	saved, err := LoadState(config.StateFile)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if saved.ActiveSlot != "green" {
+		t.Errorf("Expected repaired state to be saved, got active slot '%s'", saved.ActiveSlot)
+	}
+}
+
+func TestReconcileUsesSlotManifests(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0", GreenVersion: "v1.1.0"})
+
+	manifest := &SlotManifest{Tag: "v1.2.0", Slot: "green", DeployedAt: time.Now()}
+	if err := writeSlotManifest(filepath.Join(config.InstallDir, "green"), manifest); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(config.InstallDir, "blue")); err != nil {
+		t.Fatalf("Failed to remove blue slot: %v", err)
+	}
+
+	deployer := &Deployer{config: config, state: &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0", GreenVersion: "v1.1.0"}}
+	report := deployer.reconcile()
+
+	if deployer.state.GreenVersion != "v1.2.0" {
+		t.Errorf("Expected green version from manifest 'v1.2.0', got '%s'", deployer.state.GreenVersion)
+	}
+	if deployer.state.BlueVersion != "" {
+		t.Errorf("Expected blue version cleared for missing slot, got '%s'", deployer.state.BlueVersion)
+	}
+	if len(report.Repairs) != 2 {
+		t.Errorf("Expected 2 repairs, got %d: %v", len(report.Repairs), report.Repairs)
+	}
+}
+
+func TestReconcileFlagsForeignSymlink(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue"})
+
+	elsewhere := t.TempDir()
+	if err := os.Symlink(elsewhere, config.CurrentSymlink); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	deployer := &Deployer{config: config, state: &DeploymentState{ActiveSlot: "blue"}}
+	report := deployer.reconcile()
+
+	if report.LiveSlot != "" {
+		t.Errorf("Expected no live slot, got '%s'", report.LiveSlot)
+	}
+	if len(report.Problems) == 0 {
+		t.Error("Expected a problem for symlink pointing outside install_dir")
+	}
+}
+
+func TestEnsureSlotNotLive(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue"})
+
+	// State says blue is active, but the symlink serves green
+	if err := os.Symlink(filepath.Join(config.InstallDir, "green"), config.CurrentSymlink); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	deployer := &Deployer{config: config, state: &DeploymentState{ActiveSlot: "blue"}}
+	if err := deployer.ensureSlotNotLive("green"); err == nil {
+		t.Error("Expected refusal to deploy into the live green slot")
+	}
+	if err := deployer.ensureSlotNotLive("blue"); err != nil {
+		t.Errorf("Expected blue slot to be deployable, got: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// configWatchInterval is how often watch_config checks the config file
+const configWatchInterval = 5 * time.Second
+
+// reloadFile loads the config file at path, narrowed to the named app if
+// one was selected on the command line, and swaps it in. An invalid config
+// is logged and rejected, and the running one kept.
+func (dm *Daemon) reloadFile(path, app string) {
+	dm.logger.Info("Reloading configuration", "file", path)
+	config, err := LoadConfig(path)
+	if err == nil {
+		err = config.onlyApp(app)
+	}
+	if err == nil {
+		err = dm.Reload(config)
+	}
+	if err != nil {
+		dm.logger.Error("Rejected configuration reload, keeping the running configuration", "error", err)
+		return
+	}
+	dm.logger.Info("Reloaded configuration")
+}
+
+// Reload validates config against the running configuration and swaps it
+// into every app between checks. The apps and where they are installed
+// cannot change without a restart, and neither can the listeners, logging
+// and supervision, which keep their running settings.
+func (dm *Daemon) Reload(config *Config) error {
+	dm.reloadMu.Lock()
+	defer dm.reloadMu.Unlock()
+
+	configs := make(map[string]*Config)
+	for _, appConfig := range config.AppConfigs() {
+		configs[appConfig.Name] = appConfig
+	}
+	if len(configs) != len(dm.apps) {
+		return fmt.Errorf("apps were added or removed, restart to apply")
+	}
+	for _, d := range dm.apps {
+		running := d.currentConfig()
+		next, ok := configs[running.Name]
+		if !ok {
+			return fmt.Errorf("app %s was removed, restart to apply", running.Name)
+		}
+		if next.InstallDir != running.InstallDir || next.CurrentSymlink != running.CurrentSymlink || next.StateFile != running.StateFile {
+			return fmt.Errorf("changing install_dir, current_symlink or state_file of %s requires a restart", running.Repo)
+		}
+	}
+
+	running := dm.apps[0].currentConfig()
+	notifier := dm.notifier
+	if !reflect.DeepEqual(config.Notifications, running.Notifications) {
+		var err error
+		if notifier, err = NewNotifier(config.Notifications, dm.logger); err != nil {
+			return fmt.Errorf("invalid notification settings: %w", err)
+		}
+	}
+
+	for _, setting := range []struct {
+		name            string
+		running, reload any
+	}{
+		{"admin", running.Admin, config.Admin},
+		{"github_webhook", running.GitHubWebhook, config.GitHubWebhook},
+		{"metrics", running.Metrics, config.Metrics},
+		{"logging", running.Logging, config.Logging},
+		{"watch_config", running.WatchConfig, config.WatchConfig},
+	} {
+		if !reflect.DeepEqual(setting.running, setting.reload) {
+			dm.logger.Warn("Setting changed, restart to apply", "setting", setting.name)
+		}
+	}
+
+	dm.github.SetToken(config.GitHubToken)
+	for _, d := range dm.apps {
+		running := d.currentConfig()
+		next := configs[running.Name]
+		next.Admin = running.Admin
+		next.GitHubWebhook = running.GitHubWebhook
+		next.Metrics = running.Metrics
+		next.Logging = running.Logging
+		next.WatchConfig = running.WatchConfig
+		if !reflect.DeepEqual(next.Supervise, running.Supervise) {
+			d.logger.Warn("Setting changed, restart to apply", "setting", "supervise")
+			next.Supervise = running.Supervise
+		}
+		d.applyConfig(next, notifier)
+	}
+
+	if notifier != dm.notifier {
+		// Let the old notifier finish sending what it already has
+		dm.notifier.Close()
+		dm.notifier = notifier
+	}
+	return nil
+}
+
+// applyConfig swaps in a reloaded configuration and notifier once the
+// check, deploy or rollback in progress, if any, has finished
+func (d *Deployer) applyConfig(config *Config, notifier *Notifier) {
+	d.opMu.Lock()
+	defer d.opMu.Unlock()
+
+	d.configMu.Lock()
+	d.config = config
+	d.configMu.Unlock()
+	d.notifier = notifier
+
+	select {
+	case d.reloaded <- struct{}{}:
+	default:
+	}
+}
+
+// watchConfig calls reload whenever the contents of the file at path
+// change, checking every interval until ctx is cancelled
+func watchConfig(ctx context.Context, path string, interval time.Duration, reload func()) {
+	hash := func() []byte {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// Possibly mid-replace; look again next time
+			return nil
+		}
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+
+	last := hash()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := hash()
+			if current == nil || bytes.Equal(current, last) {
+				continue
+			}
+			last = current
+			reload()
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newReloadTestDaemon runs a single deployer for test/repo whose config was
+// loaded from the returned file
+func newReloadTestDaemon(t *testing.T, extra string) (*Daemon, string) {
+	t.Helper()
+	server := newTestReleaseServer(t, "v1.0.0", map[string]string{"app.txt": "v1"})
+	slots := setupSlots(t, &DeploymentState{ActiveSlot: "blue"})
+	path := writeReloadConfig(t, filepath.Join(t.TempDir(), "config.yaml"), slots, extra)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	return newTestDaemon(newTestDeployer(t, config, server)), path
+}
+
+// writeReloadConfig writes a config for test/repo using the slots of
+// slots, followed by extra
+func writeReloadConfig(t *testing.T, path string, slots *Config, extra string) string {
+	t.Helper()
+	content := fmt.Sprintf("repo: test/repo\nasset_suffix: .tar.gz\ninstall_dir: %s\ncurrent_symlink: %s\nstate_file: %s\n%s",
+		slots.InstallDir, slots.CurrentSymlink, slots.StateFile, extra)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestReloadAppliesConfig(t *testing.T) {
+	dm, path := newReloadTestDaemon(t, "check_interval_seconds: 3600\nadmin:\n  listen: 127.0.0.1:0\n  token: old\n")
+	d := dm.apps[0]
+	writeReloadConfig(t, path, d.config, `check_interval_seconds: 60
+github_token: new-token
+admin:
+  listen: 127.0.0.1:0
+  token: new
+hooks:
+  post_switch:
+    command: "echo switched"
+notifications:
+  webhooks:
+    - url: http://127.0.0.1:1/hook
+`)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if err := dm.Reload(config); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	applied := d.currentConfig()
+	if applied.CheckIntervalSecs != 60 || applied.Hooks.PostSwitch.Command != "echo switched" {
+		t.Errorf("Expected the new interval and hooks, got %+v", applied)
+	}
+	if dm.github.token != "new-token" {
+		t.Errorf("Expected the GitHub client to use the new token, got %q", dm.github.token)
+	}
+	if applied.Admin.Token != "old" {
+		t.Errorf("Expected the admin API to keep its settings until restart, got %+v", applied.Admin)
+	}
+	if d.notifier == nil || d.notifier != dm.notifier {
+		t.Error("Expected a notifier for the new webhook")
+	}
+	if !triggeredReload(d) {
+		t.Error("Expected the polling loop to be told about the reload")
+	}
+}
+
+// triggeredReload reports whether the polling loop was told of a reload
+func triggeredReload(d *Deployer) bool {
+	select {
+	case <-d.reloaded:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestReloadRejectsIncompatibleChanges(t *testing.T) {
+	dm, path := newReloadTestDaemon(t, "")
+	d := dm.apps[0]
+	running := d.currentConfig()
+
+	for name, mutate := range map[string]func(*Config){
+		"moved install_dir": func(c *Config) { c.InstallDir = t.TempDir() },
+		"moved state_file":  func(c *Config) { c.StateFile = filepath.Join(t.TempDir(), "state.yaml") },
+		"added apps": func(c *Config) {
+			c.Apps = []AppConfig{{Name: "web", Repo: "test/web", InstallDir: "/srv/web"}, {Name: "api", Repo: "test/api", InstallDir: "/srv/api"}}
+		},
+		"invalid notifications": func(c *Config) {
+			c.Notifications.Webhooks = []WebhookConfig{{URL: "http://127.0.0.1:1/hook", Format: "irc"}}
+		},
+	} {
+		config, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		config.CheckIntervalSecs = 1
+		mutate(config)
+		if err := dm.Reload(config); err == nil {
+			t.Errorf("%s: expected the reload to be rejected", name)
+		}
+		if d.currentConfig() != running {
+			t.Fatalf("%s: expected the running config to be kept", name)
+		}
+	}
+}
+
+func TestReloadFileKeepsConfigWhenInvalid(t *testing.T) {
+	dm, path := newReloadTestDaemon(t, "")
+	d := dm.apps[0]
+	running := d.currentConfig()
+
+	if err := os.WriteFile(path, []byte("repo: test/repo\ninstall_dir: [oops\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dm.reloadFile(path, "")
+	if d.currentConfig() != running {
+		t.Error("Expected an unparseable config to be rejected")
+	}
+
+	writeReloadConfig(t, path, running, "check_interval_seconds: 60\n")
+	dm.reloadFile(path, "")
+	if d.currentConfig().CheckIntervalSecs != 60 {
+		t.Error("Expected a valid config to be applied")
+	}
+}
+
+func TestReloadWaitsForRunningOperation(t *testing.T) {
+	dm, path := newReloadTestDaemon(t, "")
+	d := dm.apps[0]
+	writeReloadConfig(t, path, d.config, "check_interval_seconds: 60\n")
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	d.opMu.Lock()
+	done := make(chan error, 1)
+	go func() { done <- dm.Reload(config) }()
+	time.Sleep(100 * time.Millisecond)
+	if d.currentConfig().CheckIntervalSecs == 60 {
+		t.Error("Expected the reload to wait for the operation in progress")
+	}
+	d.opMu.Unlock()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if d.currentConfig().CheckIntervalSecs != 60 {
+		t.Error("Expected the reload to be applied after the operation")
+	}
+}
+
+func TestRunUsesReloadedInterval(t *testing.T) {
+	dm, path := newReloadTestDaemon(t, "check_interval_seconds: 3600\n")
+	d := dm.apps[0]
+	checks := func() uint64 {
+		d.metrics.mu.Lock()
+		defer d.metrics.mu.Unlock()
+		return d.metrics.checks
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- dm.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	if !waitFor(t, 5*time.Second, func() bool { return checks() == 1 }) {
+		t.Fatalf("Expected the initial check, got %d checks", checks())
+	}
+
+	writeReloadConfig(t, path, d.currentConfig(), "check_interval_seconds: 1\n")
+	dm.reloadFile(path, "")
+	if !waitFor(t, 5*time.Second, func() bool { return checks() >= 2 }) {
+		t.Errorf("Expected a check on the reloaded interval, got %d checks", checks())
+	}
+}
+
+func TestWatchConfigReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("repo: test/repo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchConfig(ctx, path, 10*time.Millisecond, func() { reloads <- struct{}{} })
+
+	time.Sleep(50 * time.Millisecond)
+	if len(reloads) != 0 {
+		t.Fatal("Expected no reload while the file is unchanged")
+	}
+
+	// Replace the file the way editors and config management do
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte("repo: test/other\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a reload after the file changed")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(reloads) != 0 {
+		t.Error("Expected a single reload per change")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// rolloutBuckets is the number of buckets hosts are spread over, so that a
+// stage's percent is also the number of buckets it covers
+const rolloutBuckets = 100
+
+// maxScheduleAssetSize bounds the rollout schedule downloaded with a release
+const maxScheduleAssetSize = 64 << 10
+
+// machineIDFiles hold a stable identifier of the host, tried in order
+var machineIDFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// rolloutSchedule is the content of a rollout schedule asset
+type rolloutSchedule struct {
+	Stages []RolloutStage `yaml:"stages"`
+	// Halt stops the rollout after its stages, which then need not reach
+	// every host
+	Halt bool `yaml:"halt"`
+}
+
+// rolloutStage is a parsed RolloutStage
+type rolloutStage struct {
+	percent int
+	after   time.Duration
+}
+
+// parseRolloutStages checks that stages cover growing shares of the fleet
+// at growing release ages, and unless the rollout is halted, end up
+// covering all of it
+func parseRolloutStages(stages []RolloutStage, halt bool) ([]rolloutStage, error) {
+	parsed := make([]rolloutStage, 0, len(stages))
+	for i, stage := range stages {
+		after, err := time.ParseDuration(stage.After)
+		if err != nil || after < 0 {
+			return nil, fmt.Errorf("stages[%d].after must be a duration like 6h, got %q", i, stage.After)
+		}
+		if stage.Percent < 1 || stage.Percent > rolloutBuckets {
+			return nil, fmt.Errorf("stages[%d].percent must be from 1 to 100, got %d", i, stage.Percent)
+		}
+		if i > 0 {
+			previous := parsed[i-1]
+			if stage.Percent <= previous.percent || after < previous.after {
+				return nil, fmt.Errorf("stages[%d] must cover more hosts than the stage before it, no sooner", i)
+			}
+		}
+		parsed = append(parsed, rolloutStage{percent: stage.Percent, after: after})
+	}
+	if last := len(parsed) - 1; last >= 0 && !halt && parsed[last].percent != rolloutBuckets {
+		return nil, fmt.Errorf("stages[%d].percent must be 100 so that every host deploys in the end, got %d", last, parsed[last].percent)
+	}
+	return parsed, nil
+}
+
+// rolloutDelay returns how old a release must be before hosts in bucket
+// deploy it, or false if no stage covers the bucket yet
+func rolloutDelay(stages []rolloutStage, bucket int) (time.Duration, bool) {
+	for _, stage := range stages {
+		if bucket < stage.percent {
+			return stage.after, true
+		}
+	}
+	return 0, false
+}
+
+// rolloutHostID returns the configured host ID, or the machine ID, or the
+// hostname
+func rolloutHostID(configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	for _, path := range machineIDFiles {
+		if data, err := os.ReadFile(path); err == nil {
+			if id := strings.TrimSpace(string(data)); id != "" {
+				return id, nil
+			}
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("no machine ID or hostname to place this host in a rollout bucket: %w", err)
+	}
+	return hostname, nil
+}
+
+// hostBucket places a host ID in a bucket from 0 to 99. The same host is
+// always in the same bucket, and hosts spread evenly over the buckets.
+func hostBucket(hostID string) int {
+	sum := sha256.Sum256([]byte(hostID))
+	return int(binary.BigEndian.Uint64(sum[:8]) % rolloutBuckets)
+}
+
+// rolloutStages returns the stages for release: those in its schedule
+// asset if it has one, and the configured ones otherwise, and whether the
+// schedule halts the rollout. A schedule is downloaded again only once the
+// asset has been replaced.
+func (d *Deployer) rolloutStages(ctx context.Context, release *Release) ([]rolloutStage, bool, error) {
+	cfg := d.config.Rollout
+	if cfg.ScheduleAsset != "" {
+		for _, asset := range release.Assets {
+			if asset.Name != cfg.ScheduleAsset {
+				continue
+			}
+			key := fmt.Sprintf("%s\x00%d\x00%s", release.TagName, asset.ID, asset.UpdatedAt.Format(time.RFC3339Nano))
+			if key == d.scheduleKey {
+				return d.scheduleStages, d.scheduleHalted, nil
+			}
+			data, err := d.github.FetchAsset(ctx, &asset, maxScheduleAssetSize)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to download rollout schedule: %w", err)
+			}
+			var schedule rolloutSchedule
+			dec := yaml.NewDecoder(bytes.NewReader(data))
+			dec.KnownFields(true)
+			if err := dec.Decode(&schedule); err != nil {
+				return nil, false, fmt.Errorf("invalid rollout schedule %s: %w", asset.Name, err)
+			}
+			stages, err := parseRolloutStages(schedule.Stages, schedule.Halt)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid rollout schedule %s: %w", asset.Name, err)
+			}
+			d.scheduleKey, d.scheduleStages, d.scheduleHalted = key, stages, schedule.Halt
+			return stages, schedule.Halt, nil
+		}
+	}
+	stages, err := parseRolloutStages(cfg.Stages, false)
+	return stages, false, err
+}
+
+// rolloutWait explains why this host should not deploy release yet under
+// the rollout settings, or returns "" if it may
+func (d *Deployer) rolloutWait(ctx context.Context, release *Release, now time.Time) (string, error) {
+	cfg := d.config.Rollout
+	if len(cfg.Stages) == 0 && cfg.ScheduleAsset == "" || release.PublishedAt.IsZero() {
+		return "", nil
+	}
+	stages, halted, err := d.rolloutStages(ctx, release)
+	if err != nil {
+		return "", err
+	}
+	if len(stages) == 0 && !halted {
+		// A schedule_asset without stages: releases without one deploy everywhere
+		return "", nil
+	}
+	hostID, err := rolloutHostID(cfg.HostID)
+	if err != nil {
+		return "", err
+	}
+	bucket := hostBucket(hostID)
+	delay, ok := rolloutDelay(stages, bucket)
+	if !ok {
+		// Only a halted rollout stops short of a bucket
+		return fmt.Sprintf("the rollout is halted before bucket %d", bucket), nil
+	}
+	if startsAt := release.PublishedAt.Add(delay); now.Before(startsAt) {
+		return fmt.Sprintf("the rollout reaches bucket %d at %s", bucket, startsAt.Format(time.RFC3339)), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hostInBucket returns a host ID whose bucket is within [from, to)
+func hostInBucket(t *testing.T, from, to int) string {
+	t.Helper()
+	for i := 0; i < 10000; i++ {
+		id := fmt.Sprintf("device-%d", i)
+		if b := hostBucket(id); b >= from && b < to {
+			return id
+		}
+	}
+	t.Fatalf("No host ID found in buckets %d to %d", from, to-1)
+	return ""
+}
+
+func TestHostBucketIsStableAndEven(t *testing.T) {
+	if hostBucket("device-1") != hostBucket("device-1") {
+		t.Error("Expected the same host to land in the same bucket")
+	}
+	counts := make([]int, rolloutBuckets)
+	for i := 0; i < 100*rolloutBuckets; i++ {
+		counts[hostBucket(fmt.Sprintf("host-%d", i))]++
+	}
+	for bucket, n := range counts {
+		if n < 50 || n > 150 {
+			t.Errorf("Bucket %d holds %d of %d hosts, expected about 100", bucket, n, 100*rolloutBuckets)
+		}
+	}
+}
+
+func TestRolloutDelay(t *testing.T) {
+	stages, err := parseRolloutStages([]RolloutStage{{Percent: 5, After: "0s"}, {Percent: 50, After: "6h"}, {Percent: 90, After: "1d"}}, true)
+	if err == nil {
+		t.Fatal("Expected 1d to be rejected")
+	}
+	stages, err = parseRolloutStages([]RolloutStage{{Percent: 5, After: "0s"}, {Percent: 50, After: "6h"}, {Percent: 90, After: "24h"}}, true)
+	if err != nil {
+		t.Fatalf("Failed to parse stages: %v", err)
+	}
+	for _, tc := range []struct {
+		bucket int
+		delay  time.Duration
+		ok     bool
+	}{
+		{0, 0, true},
+		{4, 0, true},
+		{5, 6 * time.Hour, true},
+		{89, 24 * time.Hour, true},
+		{90, 0, false},
+	} {
+		delay, ok := rolloutDelay(stages, tc.bucket)
+		if delay != tc.delay || ok != tc.ok {
+			t.Errorf("rolloutDelay(%d) = %s, %t, want %s, %t", tc.bucket, delay, ok, tc.delay, tc.ok)
+		}
+	}
+
+	for _, bad := range [][]RolloutStage{
+		{{Percent: 0, After: "0s"}},
+		{{Percent: 101, After: "0s"}},
+		{{Percent: 50, After: "1h"}, {Percent: 50, After: "2h"}},
+		{{Percent: 10, After: "2h"}, {Percent: 50, After: "1h"}},
+		// Only a halted rollout may leave hosts out
+		{{Percent: 5, After: "0s"}, {Percent: 50, After: "6h"}},
+	} {
+		if _, err := parseRolloutStages(bad, false); err == nil {
+			t.Errorf("Expected stages %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestRolloutWaitsForHostStage(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		host     string
+		deployed bool
+	}{
+		{"canary", hostInBucket(t, 0, 10), true},
+		{"rest of fleet", hostInBucket(t, 10, 100), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+			config.Repo = "test/repo"
+			config.AssetSuffix = ".tar.gz"
+			config.Rollout = RolloutConfig{
+				HostID: tc.host,
+				Stages: []RolloutStage{{Percent: 10, After: "0s"}, {Percent: 100, After: "12h"}},
+			}
+			server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+			deployer := newTestDeployer(t, config, server)
+
+			if err := deployer.checkAndDeploy(context.Background()); err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+			if deployed := deployer.getCurrentVersion() == "v2.0.0"; deployed != tc.deployed {
+				t.Errorf("Expected deployed to be %t, got %+v", tc.deployed, deployer.state)
+			}
+			if waiting := deployer.soakingTag == "v2.0.0"; waiting == tc.deployed {
+				t.Errorf("Expected waiting to be %t", !tc.deployed)
+			}
+		})
+	}
+}
+
+func TestRolloutScheduleAsset(t *testing.T) {
+	schedule := "stages:\n  - percent: 100\n    after: 0s\n"
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write([]byte(schedule))
+	}))
+	t.Cleanup(server.Close)
+
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue"})
+	config.Rollout = RolloutConfig{
+		HostID:        hostInBucket(t, 50, 100),
+		Stages:        []RolloutStage{{Percent: 50, After: "0s"}, {Percent: 100, After: "24h"}},
+		ScheduleAsset: "rollout.yaml",
+	}
+	deployer := newTestDeployer(t, config, server)
+	release := &Release{
+		TagName:     "v2.0.0",
+		PublishedAt: time.Now(),
+		Assets:      []Asset{{ID: 1, Name: "rollout.yaml", BrowserDownloadURL: server.URL + "/rollout.yaml"}},
+	}
+
+	// The published schedule covers the whole fleet, and is only
+	// downloaded once per release
+	for i := 0; i < 3; i++ {
+		if wait, err := deployer.rolloutWait(context.Background(), release, time.Now()); err != nil || wait != "" {
+			t.Errorf("Expected the schedule asset to let the host deploy, got %q, %v", wait, err)
+		}
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("Expected the schedule to be downloaded once, got %d", got)
+	}
+
+	// A replaced asset is downloaded again, holding the rollout back
+	schedule = "stages:\n  - percent: 100\n    after: 24h\n"
+	release.Assets[0].ID = 2
+	if wait, err := deployer.rolloutWait(context.Background(), release, time.Now()); err != nil || !strings.Contains(wait, "reaches bucket") {
+		t.Errorf("Expected the replaced schedule to make the host wait, got %q, %v", wait, err)
+	}
+	release.Assets[0].UpdatedAt = time.Now()
+	schedule = "stages:\n  - percent: 100\n    after: 0s\n"
+	if wait, err := deployer.rolloutWait(context.Background(), release, time.Now()); err != nil || wait != "" {
+		t.Errorf("Expected the updated schedule to let the host deploy, got %q, %v", wait, err)
+	}
+	if got := fetches.Load(); got != 3 {
+		t.Errorf("Expected each version of the schedule to be downloaded once, got %d", got)
+	}
+
+	// Without the asset the configured stages leave the host out
+	withoutAsset := &Release{TagName: "v2.0.0", PublishedAt: time.Now()}
+	if wait, _ := deployer.rolloutWait(context.Background(), withoutAsset, time.Now()); !strings.Contains(wait, "reaches bucket") {
+		t.Errorf("Expected the host to wait for the configured stages, got %q", wait)
+	}
+
+	// A halted schedule may stop short of the host's bucket
+	schedule = "halt: true\nstages:\n  - percent: 50\n    after: 0s\n"
+	release.Assets[0].ID = 3
+	if wait, err := deployer.rolloutWait(context.Background(), release, time.Now()); err != nil || !strings.Contains(wait, "halted before bucket") {
+		t.Errorf("Expected the halted rollout to leave the host out, got %q, %v", wait, err)
+	}
+
+	// A broken schedule fails the check instead of deploying everywhere
+	for _, broken := range []string{
+		"stages:\n  - percent: 100\n    after: soon\n",
+		"stages:\n  - percent: 50\n    after: 0s\n",
+	} {
+		schedule = broken
+		release.Assets[0].ID++
+		if _, err := deployer.rolloutWait(context.Background(), release, time.Now()); err == nil {
+			t.Errorf("Expected schedule %q to be an error", broken)
+		}
+	}
+}
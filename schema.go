@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// jsonSchema is the subset of JSON Schema (draft-07) used to describe the
+// config file
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Default              any                    `json:"default,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties any                    `json:"additionalProperties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AllOf                []*jsonSchema          `json:"allOf,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Definitions          map[string]*jsonSchema `json:"definitions,omitempty"`
+}
+
+// settingDoc documents a setting in the schema
+type settingDoc struct {
+	description string
+	def         any
+	enum        []string
+}
+
+// settingDocs documents every setting, keyed by the struct declaring it and
+// its YAML key. A test checks that none is missing.
+var settingDocs = map[string]settingDoc{
+	"Config.apps":                   {description: "Applications deployed by this process, instead of the top-level repo, install_dir and current_symlink. Each takes the per-application settings"},
+	"Config.check_interval_seconds": {description: "How often to check for new releases, in seconds", def: 300},
+	"Config.github_token":           {description: "GitHub API token; GITHUB_TOKEN in the environment takes precedence"},
+	"Config.github_token_file":      {description: "File holding github_token, relative to $CREDENTIALS_DIRECTORY or the config file"},
+	"Config.admin":                  {description: "Local admin HTTP API for status, history and control"},
+	"Config.github_webhook":         {description: "Receiver for GitHub release webhooks that trigger immediate checks"},
+	"Config.metrics":                {description: "Prometheus metrics endpoint and textfile output"},
+	"Config.notifications":          {description: "Deployment notifications by webhook and email"},
+	"Config.logging":                {description: "Log level, format and file"},
+	"Config.watch_config":           {description: "Reload the config whenever the file changes, as on SIGHUP", def: false},
+
+	"AppConfig.name":                 {description: "Name identifying the app on the command line, in the admin API and in metrics; required for each entry in apps"},
+	"AppConfig.repo":                 {description: "GitHub repository to deploy releases from, as owner/repo"},
+	"AppConfig.asset_suffix":         {description: "Suffix of the release asset to deploy, e.g. .tar.gz"},
+	"AppConfig.install_dir":          {description: "Absolute path of the directory holding the blue and green slots"},
+	"AppConfig.current_symlink":      {description: "Absolute path of the symlink pointing at the active slot"},
+	"AppConfig.run_command":          {description: "Command run in the new slot after extraction, e.g. to install dependencies"},
+	"AppConfig.post_deploy_script":   {description: "Script run after a successful deployment"},
+	"AppConfig.state_file":           {description: "Where deployment state is kept; defaults to <install_dir>/state.yaml"},
+	"AppConfig.health_check_url":     {description: "http(s) URL that must respond successfully before the new slot is activated"},
+	"AppConfig.health_check_timeout": {description: "Health check timeout in seconds", def: 30},
+	"AppConfig.verify_checksums":     {description: "Verify release assets against their SHA256 checksums", def: false},
+	"AppConfig.shared_dir":           {description: "Absolute path of the directory holding shared paths; defaults to <install_dir>/shared"},
+	"AppConfig.shared_paths":         {description: "Persistent files and directories linked or copied into every new slot"},
+	"AppConfig.hooks":                {description: "Commands run at each phase of a deployment"},
+	"AppConfig.hook_defaults":        {description: "Identity and environment for every hook, run_command and post_deploy_script"},
+	"AppConfig.supervise":            {description: "Run the application from the current symlink inside gh-deployer"},
+	"AppConfig.restart":              {description: "systemd units restarted after every switch"},
+	"AppConfig.deploy_window":        {description: "When automatic deployments may switch slots; new releases are staged outside it"},
+	"AppConfig.min_release_age":      {description: "How long a release must have been published before it is deployed automatically, e.g. 24h"},
+	"AppConfig.rollout":              {description: "Spread automatic deployments of each release over the fleet in stages"},
+	"AppConfig.freeze_file":          {description: "While this file exists, automatic deployments are only staged and manual ones refused; defaults to <install_dir>/freeze"},
+
+	"SharedPath.path":     {description: "Path relative to the slot and shared_dir; a trailing slash marks a directory"},
+	"SharedPath.type":     {description: "Whether the path is a file or a directory", enum: []string{"file", "dir"}},
+	"SharedPath.mode":     {description: "Link the shared path into the slot or copy it", def: "symlink", enum: []string{"symlink", "copy"}},
+	"SharedPath.required": {description: "Fail the deployment if the shared path is missing", def: false},
+
+	"HooksConfig.pre_download":  {description: "Run before the release is downloaded; failure aborts the deployment"},
+	"HooksConfig.post_extract":  {description: "Run after the release is extracted; failure aborts the deployment"},
+	"HooksConfig.install":       {description: "Run after post_extract to install the release, with run_command as its default command; failure aborts the deployment"},
+	"HooksConfig.pre_switch":    {description: "Run before the symlink is switched; failure aborts the deployment"},
+	"HooksConfig.post_switch":   {description: "Run after the symlink is switched"},
+	"HooksConfig.post_deploy":   {description: "Run after a successful deployment, with post_deploy_script as its default command"},
+	"HooksConfig.on_failure":    {description: "Run when a deployment fails"},
+	"HooksConfig.post_rollback": {description: "Run after a rollback"},
+
+	"HookConfig.command":         {description: "Command run with sh -c"},
+	"HookConfig.timeout_seconds": {description: "Timeout in seconds; 0 means none", def: 0},
+
+	"ExecConfig.user":            {description: "User to run as"},
+	"ExecConfig.group":           {description: "Group to run as"},
+	"ExecConfig.env":             {description: "Environment variables to set"},
+	"ExecConfig.env_allow":       {description: "Variables, or name* patterns, passed through from gh-deployer's environment"},
+	"ExecConfig.umask":           {description: "Octal umask, e.g. \"027\""},
+	"ExecConfig.working_dir":     {description: "Working directory; relative paths are resolved against the slot"},
+	"ExecConfig.inherit_secrets": {description: "Pass GITHUB_TOKEN and other secrets through", def: false},
+
+	"SuperviseConfig.enabled":                     {description: "Supervise the application", def: false},
+	"SuperviseConfig.command":                     {description: "Command starting the application, run with sh -c in the active slot"},
+	"SuperviseConfig.stop_timeout_seconds":        {description: "How long to wait after SIGTERM before killing the application", def: int(defaultStopTimeout / time.Second)},
+	"SuperviseConfig.restart_backoff_seconds":     {description: "Delay before the first restart after a crash", def: int(defaultRestartBackoff / time.Second)},
+	"SuperviseConfig.max_restart_backoff_seconds": {description: "Longest delay between restarts", def: int(defaultMaxRestartBackoff / time.Second)},
+
+	"DeployWindowConfig.timezone":       {description: "IANA timezone of allow and blackout_dates, e.g. Europe/London; the local timezone if empty"},
+	"DeployWindowConfig.allow":          {description: "Cron expressions (minute hour day-of-month month day-of-week) of the minutes deployments are allowed; any time if empty"},
+	"DeployWindowConfig.blackout_dates": {description: "Dates, or inclusive ranges like 2024-12-24..2024-12-26, on which no deployments are allowed"},
+	"RolloutConfig.stages":              {description: "Stages in order, each covering more buckets of hosts no sooner than the last, ending at 100 percent"},
+	"RolloutConfig.schedule_asset":      {description: "Name of a release asset whose stages replace the configured ones for that release"},
+	"RolloutConfig.host_id":             {description: "Identifies this host when choosing its bucket; defaults to the machine ID, or the hostname"},
+	"RolloutStage.percent":              {description: "Share of buckets (0 to percent-1 of 100) that deploy in this stage"},
+	"RolloutStage.after":                {description: "How long after publication the stage starts, e.g. 6h"},
+	"RestartConfig.mode":                {description: "How services are restarted after a switch", enum: []string{"systemd"}},
+	"RestartConfig.backend":             {description: "How systemd is reached", def: "auto", enum: []string{"auto", "dbus", "systemctl"}},
+	"RestartConfig.units":               {description: "Units to restart"},
+	"RestartConfig.reload_units":        {description: "Units to reload"},
+	"RestartConfig.timeout_seconds":     {description: "How long to wait for units to become active", def: int(defaultRestartTimeout / time.Second)},
+	"RestartConfig.rollback_on_failure": {description: "Roll back when a unit fails to become active", def: false},
+	"RestartConfig.systemctl":           {description: "Path of systemctl", def: defaultSystemctl},
+
+	"AdminConfig.listen":     {description: "host:port or unix:/path to serve the admin API on; empty disables it"},
+	"AdminConfig.token":      {description: "Bearer token required on every request"},
+	"AdminConfig.token_file": {description: "File holding token, relative to $CREDENTIALS_DIRECTORY or the config file"},
+
+	"GitHubWebhookConfig.listen":      {description: "host:port or unix:/path to receive webhooks on; empty disables the receiver"},
+	"GitHubWebhookConfig.path":        {description: "Path webhooks are delivered to", def: "/webhook"},
+	"GitHubWebhookConfig.secret":      {description: "Webhook secret configured on GitHub"},
+	"GitHubWebhookConfig.secret_file": {description: "File holding secret, relative to $CREDENTIALS_DIRECTORY or the config file"},
+	"GitHubWebhookConfig.actions":     {description: "Release actions that trigger a check", def: defaultWebhookActions},
+
+	"MetricsConfig.listen":   {description: "host:port or unix:/path serving /metrics; empty disables it"},
+	"MetricsConfig.textfile": {description: "node_exporter textfile collector path written after every check"},
+
+	"NotificationsConfig.events":             {description: "Events to notify about", def: allEvents},
+	"NotificationsConfig.templates":          {description: "Message template per event, over .Tag, .PreviousTag, .Slot, .Host, .Repo, .Error and .ReleaseNotes"},
+	"NotificationsConfig.attempts":           {description: "Tries per notification", def: defaultNotifyAttempts},
+	"NotificationsConfig.rate_limit_seconds": {description: "Minimum time between notifications of the same event for the same app and tag; negative disables", def: int(defaultNotifyRateLimit / time.Second)},
+	"NotificationsConfig.webhooks":           {description: "Webhook destinations"},
+	"NotificationsConfig.email":              {description: "Email through an SMTP relay"},
+
+	"WebhookConfig.url":     {description: "URL notifications are posted to"},
+	"WebhookConfig.format":  {description: "Payload format", def: "json", enum: []string{"json", "slack", "teams"}},
+	"WebhookConfig.events":  {description: "Events sent to this webhook, overriding notifications.events"},
+	"WebhookConfig.headers": {description: "Extra request headers"},
+
+	"EmailConfig.host":          {description: "SMTP relay host; empty disables email"},
+	"EmailConfig.port":          {description: "SMTP port", def: 587},
+	"EmailConfig.tls":           {description: "How the connection is secured", def: "starttls", enum: []string{"starttls", "tls", "none"}},
+	"EmailConfig.username":      {description: "SMTP username"},
+	"EmailConfig.password":      {description: "SMTP password"},
+	"EmailConfig.password_file": {description: "File holding password, relative to $CREDENTIALS_DIRECTORY or the config file"},
+	"EmailConfig.from":          {description: "Sender address"},
+	"EmailConfig.to":            {description: "Recipient addresses"},
+	"EmailConfig.subject":       {description: "Subject template", def: defaultEmailSubject},
+	"EmailConfig.body":          {description: "Body template", def: defaultEmailBody},
+	"EmailConfig.events":        {description: "Events sent by email, overriding notifications.events"},
+
+	"LoggingConfig.level":       {description: "Minimum level logged", def: "info", enum: []string{"debug", "info", "warn", "error"}},
+	"LoggingConfig.format":      {description: "Log line format", def: "text", enum: []string{"text", "json"}},
+	"LoggingConfig.file":        {description: "File to log to instead of standard output"},
+	"LoggingConfig.max_size":    {description: "Rotate the log file at this size, e.g. 100MB"},
+	"LoggingConfig.max_backups": {description: "Rotated files to keep; 0 keeps all", def: 0},
+	"LoggingConfig.max_age":     {description: "Days to keep rotated files; 0 keeps them forever", def: 0},
+	"LoggingConfig.compress":    {description: "Gzip rotated files", def: false},
+}
+
+// envReferencePattern matches a value that is a single ${VAR} reference,
+// which may stand for a number or boolean until it is expanded
+const envReferencePattern = `^\$\{[A-Za-z_][A-Za-z0-9_]*(:-[^}]*)?\}$`
+
+// schemaGenerator builds a schema from the config structs
+type schemaGenerator struct {
+	definitions map[string]*jsonSchema
+	// undocumented lists the settings missing from settingDocs
+	undocumented []string
+}
+
+// configSchema returns the JSON Schema of the config file
+func configSchema() (*jsonSchema, []string) {
+	g := &schemaGenerator{definitions: map[string]*jsonSchema{
+		"envReference": {Type: "string", Pattern: envReferencePattern, Description: "An environment variable reference, expanded when the config is loaded"},
+	}}
+	root := g.object(reflect.TypeOf(Config{}))
+	root.Schema = "http://json-schema.org/draft-07/schema#"
+	root.Title = "gh-deployer configuration"
+	root.AnyOf = []*jsonSchema{
+		{Required: []string{"apps"}},
+		{Required: []string{"repo", "install_dir", "current_symlink"}},
+	}
+	app := g.definitions["AppConfig"]
+	app.Required = []string{"name", "repo", "install_dir", "current_symlink"}
+	root.Definitions = g.definitions
+	return root, g.undocumented
+}
+
+// object describes struct type t, including the settings of inlined structs
+func (g *schemaGenerator) object(t reflect.Type) *jsonSchema {
+	s := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema), AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || key == "-" {
+			continue
+		}
+		if slices.Contains(strings.Split(opts, ","), "inline") {
+			for name, property := range g.object(field.Type).Properties {
+				s.Properties[name] = property
+			}
+			continue
+		}
+
+		property := g.schemaFor(field.Type)
+		doc, ok := settingDocs[t.Name()+"."+key]
+		if !ok || doc.description == "" {
+			g.undocumented = append(g.undocumented, t.Name()+"."+key)
+		}
+		if property.Ref != "" && (doc.description != "" || doc.def != nil) {
+			// Keywords beside $ref are ignored, so wrap it
+			property = &jsonSchema{AllOf: []*jsonSchema{property}}
+		}
+		property.Description = doc.description
+		property.Default = doc.def
+		property.Enum = doc.enum
+		s.Properties[key] = property
+	}
+	return s
+}
+
+// schemaFor describes a value of type t
+func (g *schemaGenerator) schemaFor(t reflect.Type) *jsonSchema {
+	switch t.Kind() {
+	case reflect.Struct:
+		if _, ok := g.definitions[t.Name()]; !ok {
+			g.definitions[t.Name()] = nil
+			g.definitions[t.Name()] = g.object(t)
+		}
+		return &jsonSchema{Ref: "#/definitions/" + t.Name()}
+	case reflect.Slice:
+		return &jsonSchema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Int:
+		return &jsonSchema{AnyOf: []*jsonSchema{{Type: "integer"}, {Ref: "#/definitions/envReference"}}}
+	case reflect.Bool:
+		return &jsonSchema{AnyOf: []*jsonSchema{{Type: "boolean"}, {Ref: "#/definitions/envReference"}}}
+	}
+	panic(fmt.Sprintf("no JSON Schema for config type %s", t))
+}
+
+// schemaCommand prints the JSON Schema of the config file
+func schemaCommand(out io.Writer) error {
+	schema, _ := configSchema()
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(schema)
+}
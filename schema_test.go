@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfigSchemaDocumentsEverySetting(t *testing.T) {
+	schema, undocumented := configSchema()
+	for _, setting := range undocumented {
+		t.Errorf("Setting %s has no description in settingDocs", setting)
+	}
+
+	// Every entry in settingDocs must still describe a setting
+	for key := range settingDocs {
+		typeName, setting, _ := strings.Cut(key, ".")
+		object := schema
+		if typeName != "Config" {
+			object = schema.Definitions[typeName]
+		}
+		if object == nil || object.Properties[setting] == nil {
+			t.Errorf("settingDocs describes %s, which is not a setting", key)
+		}
+	}
+}
+
+func TestConfigSchemaIsUpToDate(t *testing.T) {
+	var generated bytes.Buffer
+	if err := schemaCommand(&generated); err != nil {
+		t.Fatalf("schema failed: %v", err)
+	}
+	committed, err := os.ReadFile("config.schema.json")
+	if err != nil {
+		t.Fatalf("Failed to read config.schema.json: %v", err)
+	}
+	if !bytes.Equal(committed, generated.Bytes()) {
+		t.Error("config.schema.json is out of date, regenerate it with: make schema")
+	}
+}
+
+func TestConfigSchemaEnumsAreValid(t *testing.T) {
+	// Each enumerated value must pass validation
+	schema, _ := configSchema()
+	for _, level := range schema.Definitions["LoggingConfig"].Properties["level"].Enum {
+		config, err := LoadConfig(writeConfig(t, validConfig+"logging:\n  level: "+level+"\n"))
+		if err != nil || config.Logging.Level != level {
+			t.Errorf("Expected logging.level %s to be valid, got %v", level, err)
+		}
+	}
+	for _, format := range schema.Definitions["WebhookConfig"].Properties["format"].Enum {
+		content := validConfig + "notifications:\n  webhooks:\n    - url: http://example.com/hook\n      format: " + format + "\n"
+		if _, err := LoadConfig(writeConfig(t, content)); err != nil {
+			t.Errorf("Expected webhook format %s to be valid, got %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotifier sends service state notifications to systemd when running
+// under a Type=notify unit. A nil notifier ignores all calls, so callers do
+// not need to check whether systemd is present.
+type sdNotifier struct {
+	socket   string
+	watchdog time.Duration
+}
+
+// newSDNotifier returns a notifier for $NOTIFY_SOCKET, or nil if the
+// process was not started by systemd with notification support
+func newSDNotifier() *sdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Abstract namespace sockets are given with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	n := &sdNotifier{socket: socket}
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		pid := os.Getenv("WATCHDOG_PID")
+		if pid == "" || pid == strconv.Itoa(os.Getpid()) {
+			n.watchdog = time.Duration(usec) * time.Microsecond
+		}
+	}
+	return n
+}
+
+// notify sends a raw notification such as "READY=1"
+func (n *sdNotifier) notify(state string) error {
+	if n == nil {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: n.socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Ready tells systemd that start-up has finished
+func (n *sdNotifier) Ready(status string) error {
+	return n.notify("READY=1\nSTATUS=" + status)
+}
+
+// Status reports what the deployer is doing. Progressing to a new phase is
+// also proof of life, so it pets the watchdog.
+func (n *sdNotifier) Status(status string) error {
+	if n == nil {
+		return nil
+	}
+	state := "STATUS=" + status
+	if n.watchdog > 0 {
+		state += "\nWATCHDOG=1"
+	}
+	return n.notify(state)
+}
+
+// Watchdog pets the systemd watchdog
+func (n *sdNotifier) Watchdog() error {
+	return n.notify("WATCHDOG=1")
+}
+
+// Stopping tells systemd that shutdown has begun
+func (n *sdNotifier) Stopping() error {
+	return n.notify("STOPPING=1")
+}
+
+// WatchdogInterval returns how often to pet the watchdog: half the
+// configured WatchdogSec, or 0 if the watchdog is disabled
+func (n *sdNotifier) WatchdogInterval() time.Duration {
+	if n == nil {
+		return 0
+	}
+	return n.watchdog / 2
+}
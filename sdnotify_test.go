@@ -0,0 +1,175 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenNotifySocket points NOTIFY_SOCKET at a fresh datagram socket and
+// returns it for reading notifications
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen on notify socket: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// readNotification reads one datagram from the notify socket
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("No notification received: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestSDNotifierDisabledWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	n := newSDNotifier()
+	if n != nil {
+		t.Fatalf("Expected nil notifier without NOTIFY_SOCKET")
+	}
+	if err := n.Ready("ok"); err != nil {
+		t.Errorf("Nil notifier should ignore Ready, got %v", err)
+	}
+	if n.WatchdogInterval() != 0 {
+		t.Errorf("Nil notifier should have no watchdog")
+	}
+}
+
+func TestSDNotifierSendsReadyAndStatus(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "")
+
+	n := newSDNotifier()
+	if err := n.Ready("Idle"); err != nil {
+		t.Fatalf("Ready failed: %v", err)
+	}
+	if got := readNotification(t, conn); got != "READY=1\nSTATUS=Idle" {
+		t.Errorf("Unexpected ready notification %q", got)
+	}
+
+	if err := n.Status("Downloading v1.0.0"); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if got := readNotification(t, conn); got != "STATUS=Downloading v1.0.0" {
+		t.Errorf("Status without watchdog should not pet it, got %q", got)
+	}
+}
+
+func TestSDNotifierWatchdog(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	n := newSDNotifier()
+	if got := n.WatchdogInterval(); got != 15*time.Second {
+		t.Errorf("Expected watchdog interval of 15s, got %s", got)
+	}
+
+	if err := n.Status("Extracting"); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if got := readNotification(t, conn); !strings.Contains(got, "WATCHDOG=1") {
+		t.Errorf("Status should pet the watchdog, got %q", got)
+	}
+
+	// A watchdog meant for another process is ignored
+	t.Setenv("WATCHDOG_PID", "1")
+	if newSDNotifier().WatchdogInterval() != 0 {
+		t.Errorf("Watchdog for another PID should be disabled")
+	}
+}
+
+func TestRunNotifiesReadyStatusAndStopping(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "")
+
+	server := newTestReleaseServer(t, "v1.0.0", map[string]string{"app.txt": "v1"})
+	defer server.Close()
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.CheckIntervalSecs = 3600
+	d := newTestDeployer(t, config, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- newTestDaemon(d).Run(ctx) }()
+
+	if got := readNotification(t, conn); got != "READY=1\nSTATUS=Idle, nothing deployed" {
+		t.Errorf("Expected READY=1 first, got %q", got)
+	}
+
+	var statuses []string
+	for {
+		got := readNotification(t, conn)
+		statuses = append(statuses, got)
+		if strings.HasPrefix(got, "STATUS=Idle") {
+			break
+		}
+	}
+	joined := strings.Join(statuses, "\n")
+	for _, want := range []string{"STATUS=Downloading v1.0.0", "STATUS=Switching to v1.0.0", "STATUS=Idle, running v1.0.0 from green slot"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("Expected status %q, got %q", want, statuses)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := readNotification(t, conn); got != "STOPPING=1" {
+		t.Errorf("Expected STOPPING=1 on shutdown, got %q", got)
+	}
+}
+
+func TestStallDetectorFollowsProgress(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue"})
+	config.Hooks.PreSwitch = HookConfig{Command: "echo working"}
+	deployer := newTestDeployer(t, config, newTestReleaseServer(t, "v1.0.0", nil))
+
+	// Admin operations are watched like scheduled checks
+	var during time.Duration
+	handler := deployer.adminOperation(func(*http.Request) error {
+		deployer.checkStarted.Store(time.Now().Add(-time.Hour).UnixNano())
+		deployer.lastProgress.Store(0)
+		during = deployer.stalledFor()
+		return nil
+	})
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/check", nil))
+	if during < time.Hour {
+		t.Errorf("Expected a wedged admin operation to count as stalled, got %s", during)
+	}
+	if got := deployer.stalledFor(); got != 0 {
+		t.Errorf("Expected no stall once the operation finished, got %s", got)
+	}
+
+	// A hook writing output is making progress
+	finished := deployer.beginOperation()
+	defer finished()
+	deployer.checkStarted.Store(time.Now().Add(-time.Hour).UnixNano())
+	if err := deployer.runHook(context.Background(), hookPreSwitch, &HookContext{}); err != nil {
+		t.Fatalf("Hook failed: %v", err)
+	}
+	if got := deployer.stalledFor(); got > time.Minute {
+		t.Errorf("Expected hook output to count as progress, stalled for %s", got)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sharedDir returns the directory holding persistent shared paths
+func (d *Deployer) sharedDir() string {
+	if d.config.SharedDir != "" {
+		return d.config.SharedDir
+	}
+	return filepath.Join(d.config.InstallDir, "shared")
+}
+
+// cleanSharedPath validates a shared path and returns it in clean relative
+// form. Shared paths may not be absolute or escape the slot.
+func cleanSharedPath(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("shared path is empty")
+	}
+	if filepath.IsAbs(p) {
+		return "", fmt.Errorf("shared path %q must be relative", p)
+	}
+	clean := filepath.Clean(p)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("shared path %q escapes the slot directory", p)
+	}
+	return clean, nil
+}
+
+// isDir reports whether the shared path describes a directory
+func (sp SharedPath) isDir() bool {
+	if sp.Type != "" {
+		return sp.Type == "dir"
+	}
+	return strings.HasSuffix(sp.Path, "/")
+}
+
+// linkSharedPaths links or copies every configured shared path from the
+// shared directory into slotDir, replacing anything the release shipped at
+// the same location
+func (d *Deployer) linkSharedPaths(ctx context.Context, slotDir string) error {
+	log := d.log(ctx).With("phase", "shared_paths")
+	for _, sp := range d.config.SharedPaths {
+		rel, err := cleanSharedPath(sp.Path)
+		if err != nil {
+			return err
+		}
+		src := filepath.Join(d.sharedDir(), rel)
+		dst := filepath.Join(slotDir, rel)
+
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			switch {
+			case sp.Required:
+				return fmt.Errorf("required shared path %s is missing", src)
+			case sp.isDir():
+				// Create it so data written by the app persists across slots
+				if err := os.MkdirAll(src, 0o755); err != nil {
+					return fmt.Errorf("failed to create shared directory %s: %w", src, err)
+				}
+			default:
+				log.Info("Shared file does not exist, skipping", "file", src)
+				continue
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to stat shared path %s: %w", src, err)
+		}
+
+		if err := os.RemoveAll(dst); err != nil {
+			return fmt.Errorf("failed to replace %s with shared path: %w", dst, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return fmt.Errorf("failed to create parent directory for %s: %w", dst, err)
+		}
+
+		mode := sp.Mode
+		if mode == "" {
+			mode = "symlink"
+		}
+		switch mode {
+		case "symlink":
+			err = os.Symlink(src, dst)
+		case "copy":
+			err = copyPath(src, dst)
+		default:
+			return fmt.Errorf("unknown mode %q for shared path %s", sp.Mode, sp.Path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to link shared path %s: %w", rel, err)
+		}
+		log.Info("Linked shared path into slot", "path", rel, "mode", mode)
+	}
+	return nil
+}
+
+// copyPath copies a file or directory tree, preserving permissions
+func copyPath(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target, info.Mode().Perm())
+		}
+	})
+}
+
+// copyFile copies a regular file
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkSharedPaths(t *testing.T) {
+	installDir := t.TempDir()
+	sharedDir := filepath.Join(installDir, "shared")
+	slotDir := filepath.Join(installDir, "green")
+	if err := os.MkdirAll(filepath.Join(sharedDir, "config"), 0o755); err != nil {
+		t.Fatalf("Failed to create shared dir: %v", err)
+	}
+	if err := os.MkdirAll(slotDir, 0o755); err != nil {
+		t.Fatalf("Failed to create slot dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sharedDir, ".env"), []byte("KEY=value\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write shared .env: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sharedDir, "config", "app.ini"), []byte("[app]\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write shared config: %v", err)
+	}
+	// The release ships its own .env which must be replaced
+	if err := os.WriteFile(filepath.Join(slotDir, ".env"), []byte("KEY=release\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write release .env: %v", err)
+	}
+
+	deployer := &Deployer{
+		config: &Config{AppConfig: AppConfig{
+			InstallDir: installDir,
+			SharedPaths: []SharedPath{
+				{Path: ".env", Required: true},
+				{Path: "config", Type: "dir", Mode: "copy"},
+				{Path: "data/"},
+				{Path: "optional.txt"},
+			},
+		}},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	if err := deployer.linkSharedPaths(context.Background(), slotDir); err != nil {
+		t.Fatalf("Failed to link shared paths: %v", err)
+	}
+
+	if target, err := os.Readlink(filepath.Join(slotDir, ".env")); err != nil || target != filepath.Join(sharedDir, ".env") {
+		t.Errorf("Expected .env symlink to shared copy, got %q (%v)", target, err)
+	}
+	if info, err := os.Lstat(filepath.Join(slotDir, "config")); err != nil || !info.IsDir() {
+		t.Errorf("Expected config to be copied as a real directory, got %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(slotDir, "config", "app.ini")); err != nil || string(data) != "[app]\n" {
+		t.Errorf("Expected copied config file, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(sharedDir, "data")); err != nil {
+		t.Errorf("Expected missing shared directory to be created: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(slotDir, "optional.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected optional missing file to be skipped, got %v", err)
+	}
+}
+
+func TestLinkSharedPathsRequiredMissing(t *testing.T) {
+	installDir := t.TempDir()
+	deployer := &Deployer{
+		config: &Config{AppConfig: AppConfig{
+			InstallDir:  installDir,
+			SharedPaths: []SharedPath{{Path: ".env", Required: true}},
+		}},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	if err := deployer.linkSharedPaths(context.Background(), t.TempDir()); err == nil {
+		t.Error("Expected error for missing required shared file")
+	}
+}
+
+func TestCleanSharedPath(t *testing.T) {
+	for _, p := range []string{"", "/etc/passwd", "..", "../outside", "data/../../outside"} {
+		if _, err := cleanSharedPath(p); err == nil {
+			t.Errorf("Expected %q to be rejected", p)
+		}
+	}
+	if clean, err := cleanSharedPath("data/./logs/"); err != nil || clean != filepath.Join("data", "logs") {
+		t.Errorf("Expected clean relative path, got %q (%v)", clean, err)
+	}
+}
+
+func TestDeployLinksSharedPathsBeforeInstall(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.SharedPaths = []SharedPath{{Path: ".env", Required: true}}
+	config.RunCommand = "test -L .env && grep -q KEY=shared .env"
+
+	sharedDir := filepath.Join(config.InstallDir, "shared")
+	if err := os.MkdirAll(sharedDir, 0o755); err != nil {
+		t.Fatalf("Failed to create shared dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sharedDir, ".env"), []byte("KEY=shared\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write shared .env: %v", err)
+	}
+
+	server := newTestReleaseServer(t, "v1.0.0", map[string]string{"app.py": "print('hi')\n"})
+	deployer := newTestDeployer(t, config, server)
+
+	if err := deployer.checkAndDeploy(context.Background()); err != nil {
+		t.Fatalf("Deployment failed: %v", err)
+	}
+}
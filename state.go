@@ -1,46 +1,163 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// currentStateSchemaVersion is the schema version written by SaveState.
+// Bump it and append a migration to stateMigrations whenever a state field
+// is added or changes meaning.
+const currentStateSchemaVersion = 2
+
+// stateMigrations upgrades a state document one schema version at a time.
+// stateMigrations[i] migrates a version i document to version i+1.
+var stateMigrations = []func(*DeploymentState) error{
+	// 0 -> 1: files written before schema versioning may lack an active slot
+	func(s *DeploymentState) error {
+		if s.ActiveSlot == "" {
+			s.ActiveSlot = "blue"
+		}
+		return nil
+	},
+	// 1 -> 2: adds the paused flag and deployment history, both empty by default
+	func(s *DeploymentState) error {
+		return nil
+	},
+}
+
+// maxStateHistory is the number of history entries kept in the state file
+const maxStateHistory = 50
+
 // DeploymentState represents the current deployment state
 type DeploymentState struct {
-	ActiveSlot   string `yaml:"active_slot"`
-	BlueVersion  string `yaml:"blue_version"`
-	GreenVersion string `yaml:"green_version"`
+	SchemaVersion int    `yaml:"schema_version"`
+	ActiveSlot    string `yaml:"active_slot"`
+	BlueVersion   string `yaml:"blue_version"`
+	GreenVersion  string `yaml:"green_version"`
+
+	// Paused stops automatic deployments; explicit deploys and rollbacks still run
+	Paused  bool           `yaml:"paused,omitempty"`
+	History []HistoryEntry `yaml:"history,omitempty"`
+
+	// RecoveredFromBackup is set when the primary state file was unreadable
+	// and the state was loaded from the backup copy instead.
+	RecoveredFromBackup bool `yaml:"-"`
+}
+
+// HistoryEntry records one deployment or rollback
+type HistoryEntry struct {
+	Time        time.Time `yaml:"time" json:"time"`
+	Action      string    `yaml:"action" json:"action"` // "deploy" or "rollback"
+	Tag         string    `yaml:"tag" json:"tag"`
+	Slot        string    `yaml:"slot" json:"slot"`
+	PreviousTag string    `yaml:"previous_tag,omitempty" json:"previous_tag,omitempty"`
+	Success     bool      `yaml:"success" json:"success"`
+	Error       string    `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// AddHistory appends an entry, dropping the oldest beyond maxStateHistory
+func (s *DeploymentState) AddHistory(entry HistoryEntry) {
+	s.History = append(s.History, entry)
+	if extra := len(s.History) - maxStateHistory; extra > 0 {
+		s.History = s.History[extra:]
+	}
+}
+
+// backupStatePath returns the path of the backup copy kept next to the state file
+func backupStatePath(path string) string {
+	return path + ".bak"
 }
 
-// LoadState loads the deployment state from file
+// LoadState loads the deployment state from file, falling back to the
+// backup copy if the primary file is missing or corrupt
 func LoadState(path string) (*DeploymentState, error) {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		// Return default state if file doesn't exist
+	state, err := readStateFile(path)
+	if err == nil {
+		return state, nil
+	}
+
+	primaryMissing := os.IsNotExist(err)
+	backup, backupErr := readStateFile(backupStatePath(path))
+	if backupErr == nil {
+		backup.RecoveredFromBackup = true
+		return backup, nil
+	}
+
+	if primaryMissing && os.IsNotExist(backupErr) {
+		// Return default state if neither file exists
 		return &DeploymentState{
-			ActiveSlot:   "blue",
-			BlueVersion:  "",
-			GreenVersion: "",
+			SchemaVersion: currentStateSchemaVersion,
+			ActiveSlot:    "blue",
+			BlueVersion:   "",
+			GreenVersion:  "",
 		}, nil
 	}
 
+	return nil, err
+}
+
+// readStateFile reads and parses a single state file
+func readStateFile(path string) (*DeploymentState, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to read state file: %w", err)
 	}
+	return parseState(path, data)
+}
 
+// parseState parses, migrates and sanity-checks state file contents
+func parseState(path string, data []byte) (*DeploymentState, error) {
 	var state DeploymentState
 	if err := yaml.Unmarshal(data, &state); err != nil {
-		return nil, fmt.Errorf("failed to parse state file: %w", err)
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	// An empty or truncated-to-nothing file parses cleanly but is not a state
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("state file %s is empty or truncated", path)
+	}
+
+	if err := state.migrate(); err != nil {
+		return nil, fmt.Errorf("failed to migrate state file %s: %w", path, err)
+	}
+	if state.ActiveSlot != "blue" && state.ActiveSlot != "green" {
+		return nil, fmt.Errorf("state file %s has invalid active slot %q", path, state.ActiveSlot)
 	}
 
 	return &state, nil
 }
 
-// SaveState saves the deployment state to file
+// migrate upgrades the state to currentStateSchemaVersion
+func (s *DeploymentState) migrate() error {
+	if s.SchemaVersion > currentStateSchemaVersion {
+		return fmt.Errorf("state schema version %d is newer than supported version %d",
+			s.SchemaVersion, currentStateSchemaVersion)
+	}
+	for s.SchemaVersion < currentStateSchemaVersion {
+		if err := stateMigrations[s.SchemaVersion](s); err != nil {
+			return fmt.Errorf("migration from schema version %d failed: %w", s.SchemaVersion, err)
+		}
+		s.SchemaVersion++
+	}
+	return nil
+}
+
+// SaveState saves the deployment state to file. The write is crash-safe: the
+// new state goes to a temporary file that is fsynced and renamed over the
+// old one, and the previous good state is kept as a .bak copy.
 func (s *DeploymentState) SaveState(path string) error {
+	s.SchemaVersion = currentStateSchemaVersion
 	data, err := yaml.Marshal(s)
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %w", err)
@@ -54,13 +171,79 @@ func (s *DeploymentState) SaveState(path string) error {
 		}
 	}
 
-	if err := os.WriteFile(path, data, 0o644); err != nil {
+	// Preserve the current state as a backup, but only if it is itself valid;
+	// a corrupt primary must never overwrite a good backup.
+	if previous, err := os.ReadFile(path); err == nil {
+		if _, err := parseState(path, previous); err == nil {
+			if err := writeFileAtomic(backupStatePath(path), previous, 0o644); err != nil {
+				return fmt.Errorf("failed to write state backup: %w", err)
+			}
+		}
+	}
+
+	if err := writeFileAtomic(path, data, 0o644); err != nil {
 		return fmt.Errorf("failed to write state file: %w", err)
 	}
 
 	return nil
 }
 
+// writeFileAtomic writes data to path via a temporary file in the same
+// directory, fsyncing the file before the rename and the directory after it
+// so the update survives a power loss.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	cleanup := func() { _ = os.Remove(tmpPath) }
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		cleanup()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		cleanup()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		cleanup()
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		cleanup()
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir fsyncs a directory so that a preceding rename is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = d.Close() }()
+	if err := d.Sync(); err != nil && !isSyncUnsupported(err) {
+		return err
+	}
+	return nil
+}
+
+// isSyncUnsupported reports whether a directory fsync error only means the
+// platform or filesystem cannot sync directories
+func isSyncUnsupported(err error) bool {
+	return runtime.GOOS == "windows" || errors.Is(err, syscall.EINVAL)
+}
+
 // GetInactiveSlot returns the inactive deployment slot
 func (s *DeploymentState) GetInactiveSlot() string {
 	if s.ActiveSlot == "blue" {
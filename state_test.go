@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -93,3 +94,122 @@ func TestSwitchSlot(t *testing.T) {
 		t.Errorf("Expected active slot 'blue' after second switch, got '%s'", state.ActiveSlot)
 	}
 }
+
+func TestSaveStateKeepsBackup(t *testing.T) {
+	tempDir := t.TempDir()
+	statePath := filepath.Join(tempDir, "state.yaml")
+
+	first := &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"}
+	if err := first.SaveState(statePath); err != nil {
+		t.Fatalf("Failed to save first state: %v", err)
+	}
+	second := &DeploymentState{ActiveSlot: "green", BlueVersion: "v1.0.0", GreenVersion: "v1.1.0"}
+	if err := second.SaveState(statePath); err != nil {
+		t.Fatalf("Failed to save second state: %v", err)
+	}
+
+	backup, err := readStateFile(backupStatePath(statePath))
+	if err != nil {
+		t.Fatalf("Failed to read backup state: %v", err)
+	}
+	if backup.ActiveSlot != "blue" {
+		t.Errorf("Expected backup to hold previous active slot 'blue', got '%s'", backup.ActiveSlot)
+	}
+
+	// No temporary files should be left behind
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read temp dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected only state and backup files, got %d entries", len(entries))
+	}
+}
+
+func TestLoadStateRecoversFromBackup(t *testing.T) {
+	tempDir := t.TempDir()
+	statePath := filepath.Join(tempDir, "state.yaml")
+
+	good := &DeploymentState{ActiveSlot: "green", GreenVersion: "v2.0.0"}
+	if err := good.SaveState(statePath); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	if err := good.SaveState(statePath); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	// Simulate a write torn by a power cut
+	if err := os.WriteFile(statePath, []byte("active_slot: \"gre"), 0o644); err != nil {
+		t.Fatalf("Failed to corrupt state file: %v", err)
+	}
+
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatalf("Expected recovery from backup, got error: %v", err)
+	}
+	if !state.RecoveredFromBackup {
+		t.Error("Expected state to be flagged as recovered from backup")
+	}
+	if state.ActiveSlot != "green" || state.GreenVersion != "v2.0.0" {
+		t.Errorf("Unexpected recovered state: %+v", state)
+	}
+
+	// A corrupt primary must not overwrite the good backup on the next save
+	if err := os.WriteFile(statePath, []byte{}, 0o644); err != nil {
+		t.Fatalf("Failed to truncate state file: %v", err)
+	}
+	if err := state.SaveState(statePath); err != nil {
+		t.Fatalf("Failed to save recovered state: %v", err)
+	}
+	if _, err := readStateFile(backupStatePath(statePath)); err != nil {
+		t.Errorf("Expected backup to remain valid, got: %v", err)
+	}
+}
+
+func TestLoadStateCorruptWithoutBackup(t *testing.T) {
+	tempDir := t.TempDir()
+	statePath := filepath.Join(tempDir, "state.yaml")
+
+	if err := os.WriteFile(statePath, []byte{}, 0o644); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+
+	if _, err := LoadState(statePath); err == nil {
+		t.Error("Expected error for corrupt state without backup")
+	}
+}
+
+func TestLoadStateMigratesLegacyFile(t *testing.T) {
+	tempDir := t.TempDir()
+	statePath := filepath.Join(tempDir, "state.yaml")
+
+	legacy := "active_slot: \"green\"\nblue_version: \"v1.0.0\"\ngreen_version: \"v1.1.0\"\n"
+	if err := os.WriteFile(statePath, []byte(legacy), 0o644); err != nil {
+		t.Fatalf("Failed to write legacy state: %v", err)
+	}
+
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatalf("Failed to load legacy state: %v", err)
+	}
+	if state.SchemaVersion != currentStateSchemaVersion {
+		t.Errorf("Expected schema version %d, got %d", currentStateSchemaVersion, state.SchemaVersion)
+	}
+	if state.ActiveSlot != "green" {
+		t.Errorf("Expected active slot 'green', got '%s'", state.ActiveSlot)
+	}
+}
+
+func TestLoadStateRejectsNewerSchema(t *testing.T) {
+	tempDir := t.TempDir()
+	statePath := filepath.Join(tempDir, "state.yaml")
+
+	future := "schema_version: 999\nactive_slot: \"blue\"\n"
+	if err := os.WriteFile(statePath, []byte(future), 0o644); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+
+	if _, err := LoadState(statePath); err == nil {
+		t.Error("Expected error for state written by a newer schema version")
+	}
+}
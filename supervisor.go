@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Supervisor defaults, used when the corresponding setting is zero
+const (
+	defaultStopTimeout       = 10 * time.Second
+	defaultRestartBackoff    = 1 * time.Second
+	defaultMaxRestartBackoff = 60 * time.Second
+
+	// supervisorStableRun is how long the app must stay up before a crash
+	// restarts with the initial backoff again
+	supervisorStableRun = time.Minute
+)
+
+// Supervisor runs the application from the current symlink, restarts it
+// with exponential backoff when it exits, and stops it gracefully around
+// slot switches
+type Supervisor struct {
+	d   *Deployer
+	cfg SuperviseConfig
+
+	stopTimeout    time.Duration
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu     sync.Mutex
+	proc   *os.Process
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewSupervisor creates a supervisor for the deployer's supervise settings
+func NewSupervisor(d *Deployer, cfg SuperviseConfig) *Supervisor {
+	s := &Supervisor{
+		d:              d,
+		cfg:            cfg,
+		stopTimeout:    defaultStopTimeout,
+		initialBackoff: defaultRestartBackoff,
+		maxBackoff:     defaultMaxRestartBackoff,
+	}
+	if cfg.StopTimeoutSecs > 0 {
+		s.stopTimeout = time.Duration(cfg.StopTimeoutSecs) * time.Second
+	}
+	if cfg.RestartBackoffSecs > 0 {
+		s.initialBackoff = time.Duration(cfg.RestartBackoffSecs) * time.Second
+	}
+	if cfg.MaxRestartBackoffSecs > 0 {
+		s.maxBackoff = time.Duration(cfg.MaxRestartBackoffSecs) * time.Second
+	}
+	return s
+}
+
+// Start launches the application if it is not already running. It is safe
+// to call on a nil supervisor.
+func (s *Supervisor) Start() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.doneCh != nil {
+		return
+	}
+	s.stopCh = make(chan struct{})
+	s.doneCh = make(chan struct{})
+	// Snapshot the deployment now; the loop must not read live state
+	env := []string{
+		"DEPLOY_TAG=" + s.d.getCurrentVersion(),
+		"DEPLOY_SLOT=" + s.d.state.ActiveSlot,
+	}
+	go s.loop(env, s.stopCh, s.doneCh)
+}
+
+// Stop sends SIGTERM to the application, waits up to the stop timeout for it
+// to exit and then kills it. It is safe to call on a nil supervisor.
+func (s *Supervisor) Stop() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.doneCh == nil {
+		s.mu.Unlock()
+		return
+	}
+	done := s.doneCh
+	close(s.stopCh)
+	proc := s.proc
+	s.mu.Unlock()
+
+	if proc != nil {
+		s.d.logger.Info("Stopping application", "pid", proc.Pid)
+		_ = terminateProcessGroup(proc)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(s.stopTimeout):
+		s.mu.Lock()
+		proc = s.proc
+		s.mu.Unlock()
+		if proc != nil {
+			s.d.logger.Warn("Application did not exit in time, killing it", "timeout", s.stopTimeout)
+			_ = killProcessGroup(proc)
+		}
+		<-done
+	}
+
+	s.mu.Lock()
+	s.stopCh, s.doneCh = nil, nil
+	s.mu.Unlock()
+}
+
+// Running reports whether the supervisor loop is active
+func (s *Supervisor) Running() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.doneCh != nil
+}
+
+// loop runs the application until stop is closed, restarting it after each exit
+func (s *Supervisor) loop(env []string, stop, done chan struct{}) {
+	defer close(done)
+	backoff := s.initialBackoff
+	for {
+		started := time.Now()
+		err := s.runOnce(env, stop)
+
+		select {
+		case <-stop:
+			s.d.logger.Info("Application stopped")
+			return
+		default:
+		}
+
+		if time.Since(started) >= supervisorStableRun {
+			backoff = s.initialBackoff
+		}
+		if err != nil {
+			s.d.logger.Warn("Application exited, restarting", "error", err, "backoff", backoff)
+		} else {
+			s.d.logger.Warn("Application exited, restarting", "backoff", backoff)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, s.maxBackoff)
+	}
+}
+
+// runOnce starts the application in the slot the current symlink points to
+// and waits for it to exit
+func (s *Supervisor) runOnce(env []string, stop chan struct{}) error {
+	symlink := s.d.currentConfig().CurrentSymlink
+	dir, err := filepath.EvalSymlinks(symlink)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s: %w", symlink, err)
+	}
+
+	env = append(env[:len(env):len(env)], "DEPLOY_DIR="+dir)
+	cmd, err := s.d.buildCommand(context.Background(), s.cfg.Command, s.cfg.ExecConfig, dir, env)
+	if err != nil {
+		return err
+	}
+	setProcessGroup(cmd)
+	stdout := newLogLineWriter(s.d.logger.With("source", "app"), "stdout")
+	stderr := newLogLineWriter(s.d.logger.With("source", "app"), "stderr")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = hookKillGrace
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start application: %w", err)
+	}
+
+	// Stop either sees this process or we see that Stop has been called
+	s.mu.Lock()
+	s.proc = cmd.Process
+	stopped := isClosed(stop)
+	s.mu.Unlock()
+	if stopped {
+		_ = terminateProcessGroup(cmd.Process)
+	}
+	s.d.logger.Info("Started application", "dir", dir, "pid", cmd.Process.Pid)
+
+	err = cmd.Wait()
+	stdout.Flush()
+	stderr.Flush()
+
+	s.mu.Lock()
+	s.proc = nil
+	s.mu.Unlock()
+	return err
+}
+
+// isClosed reports whether ch has been closed
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
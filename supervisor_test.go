@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent logging
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor polls cond until it is true or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return cond()
+}
+
+// newSupervisedDeployer creates a deployer whose current symlink points at
+// the blue slot
+func newSupervisedDeployer(t *testing.T, command string, logs *syncBuffer) *Deployer {
+	t.Helper()
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	if err := os.Symlink(filepath.Join(config.InstallDir, "blue"), config.CurrentSymlink); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	config.Supervise = SuperviseConfig{Enabled: true, Command: command}
+
+	deployer, err := NewDeployer(config, slog.New(slog.NewTextHandler(logs, nil)), false)
+	if err != nil {
+		t.Fatalf("Failed to create deployer: %v", err)
+	}
+	deployer.supervisor.initialBackoff = 10 * time.Millisecond
+	deployer.supervisor.maxBackoff = 50 * time.Millisecond
+	deployer.supervisor.stopTimeout = 500 * time.Millisecond
+	return deployer
+}
+
+func TestSupervisorRestartsOnCrash(t *testing.T) {
+	logs := &syncBuffer{}
+	deployer := newSupervisedDeployer(t, `echo "started $DEPLOY_TAG in $(basename "$PWD")"; exit 1`, logs)
+
+	deployer.supervisor.Start()
+	defer deployer.supervisor.Stop()
+
+	if !waitFor(t, 5*time.Second, func() bool {
+		return strings.Count(logs.String(), `msg="started v1.0.0 in blue" source=app`) >= 3
+	}) {
+		t.Fatalf("Expected the application to be restarted after crashing, logs:\n%s", logs.String())
+	}
+}
+
+func TestSupervisorStopKillsUnresponsiveApp(t *testing.T) {
+	logs := &syncBuffer{}
+	deployer := newSupervisedDeployer(t, `trap '' TERM; echo ready; while true; do sleep 1; done`, logs)
+
+	deployer.supervisor.Start()
+	if !waitFor(t, 5*time.Second, func() bool { return strings.Contains(logs.String(), `msg=ready source=app`) }) {
+		t.Fatalf("Application did not start, logs:\n%s", logs.String())
+	}
+
+	start := time.Now()
+	deployer.supervisor.Stop()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Stop took too long: %s", elapsed)
+	}
+	if !strings.Contains(logs.String(), "killing it") {
+		t.Errorf("Expected SIGKILL after stop timeout, logs:\n%s", logs.String())
+	}
+	if deployer.supervisor.Running() {
+		t.Error("Expected supervisor to be stopped")
+	}
+}
+
+func TestSupervisorRestartsAroundRollback(t *testing.T) {
+	logs := &syncBuffer{}
+	deployer := newSupervisedDeployer(t, `echo "running in $(basename "$PWD")"; exec sleep 30`, logs)
+
+	deployer.supervisor.Start()
+	defer deployer.supervisor.Stop()
+	if !waitFor(t, 5*time.Second, func() bool { return strings.Contains(logs.String(), `msg="running in blue" source=app`) }) {
+		t.Fatalf("Application did not start in blue, logs:\n%s", logs.String())
+	}
+
+	if err := deployer.Rollback(context.Background()); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	if !waitFor(t, 5*time.Second, func() bool { return strings.Contains(logs.String(), `msg="running in green" source=app`) }) {
+		t.Fatalf("Application was not restarted in green, logs:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "Application stopped") {
+		t.Errorf("Expected graceful stop before switch, logs:\n%s", logs.String())
+	}
+}
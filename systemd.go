@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Restart defaults, used when the corresponding setting is empty
+const (
+	defaultRestartTimeout = 60 * time.Second
+	defaultSystemctl      = "systemctl"
+	unitPollInterval      = 250 * time.Millisecond
+)
+
+// systemd D-Bus names
+const (
+	systemdDest         = "org.freedesktop.systemd1"
+	systemdPath         = "/org/freedesktop/systemd1"
+	systemdManagerIface = "org.freedesktop.systemd1.Manager"
+	systemdUnitIface    = "org.freedesktop.systemd1.Unit"
+	systemdJobIface     = "org.freedesktop.systemd1.Job"
+	dbusPropertiesIface = "org.freedesktop.DBus.Properties"
+
+	// dbusUnknownObject and dbusUnknownMethod are what systemd replies about
+	// a job object it has dropped
+	dbusUnknownObject = "org.freedesktop.DBus.Error.UnknownObject"
+	dbusUnknownMethod = "org.freedesktop.DBus.Error.UnknownMethod"
+)
+
+// unitManager controls systemd units
+type unitManager interface {
+	// RestartUnit restarts a unit and returns once systemd has finished the job
+	RestartUnit(ctx context.Context, name string) error
+	// ReloadUnit reloads a unit and returns once systemd has finished the job
+	ReloadUnit(ctx context.Context, name string) error
+	// ActiveState returns the unit's ActiveState, e.g. "active" or "failed"
+	ActiveState(ctx context.Context, name string) (string, error)
+	Close() error
+}
+
+// dbusUnitManager talks to systemd over the system bus
+type dbusUnitManager struct {
+	conn *dbusConn
+}
+
+func (m *dbusUnitManager) RestartUnit(ctx context.Context, name string) error {
+	return m.runJob(ctx, "RestartUnit", name)
+}
+
+func (m *dbusUnitManager) ReloadUnit(ctx context.Context, name string) error {
+	return m.runJob(ctx, "ReloadUnit", name)
+}
+
+// runJob queues a unit job and waits for systemd to drop it, which happens
+// when the job has completed
+func (m *dbusUnitManager) runJob(ctx context.Context, method, name string) error {
+	reply, err := m.conn.Call(ctx, systemdDest, systemdPath, systemdManagerIface, method, name, "replace")
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, name, err)
+	}
+	if len(reply) == 0 {
+		return fmt.Errorf("%s %s: empty reply", method, name)
+	}
+	job := reply[0]
+
+	for {
+		_, err := m.conn.Call(ctx, systemdDest, job, dbusPropertiesIface, "Get", systemdJobIface, "State")
+		var dbusErr *DBusError
+		if errors.As(err, &dbusErr) && (dbusErr.Name == dbusUnknownObject || dbusErr.Name == dbusUnknownMethod) {
+			// The job object is gone, so the job has finished
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("waiting for %s of %s: %w", method, name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s of %s: %w", method, name, ctx.Err())
+		case <-time.After(unitPollInterval):
+		}
+	}
+}
+
+func (m *dbusUnitManager) ActiveState(ctx context.Context, name string) (string, error) {
+	reply, err := m.conn.Call(ctx, systemdDest, systemdPath, systemdManagerIface, "LoadUnit", name)
+	if err != nil || len(reply) == 0 {
+		return "", fmt.Errorf("failed to load unit %s: %w", name, err)
+	}
+	state, err := m.conn.Call(ctx, systemdDest, reply[0], dbusPropertiesIface, "Get", systemdUnitIface, "ActiveState")
+	if err != nil || len(state) == 0 {
+		return "", fmt.Errorf("failed to read state of %s: %w", name, err)
+	}
+	return state[0], nil
+}
+
+func (m *dbusUnitManager) Close() error {
+	return m.conn.Close()
+}
+
+// systemctlUnitManager drives systemd through the systemctl command
+type systemctlUnitManager struct {
+	path string
+}
+
+func (m *systemctlUnitManager) run(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, m.path, args...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+func (m *systemctlUnitManager) RestartUnit(ctx context.Context, name string) error {
+	if out, err := m.run(ctx, "restart", name); err != nil {
+		return fmt.Errorf("systemctl restart %s: %w: %s", name, err, out)
+	}
+	return nil
+}
+
+func (m *systemctlUnitManager) ReloadUnit(ctx context.Context, name string) error {
+	if out, err := m.run(ctx, "reload", name); err != nil {
+		return fmt.Errorf("systemctl reload %s: %w: %s", name, err, out)
+	}
+	return nil
+}
+
+func (m *systemctlUnitManager) ActiveState(ctx context.Context, name string) (string, error) {
+	// is-active exits non-zero for anything but active, so only the
+	// absence of output is an error
+	out, err := m.run(ctx, "is-active", name)
+	if out == "" {
+		return "", fmt.Errorf("systemctl is-active %s: %w", name, err)
+	}
+	return out, nil
+}
+
+func (m *systemctlUnitManager) Close() error {
+	return nil
+}
+
+// newUnitManager connects to systemd using the configured backend. The
+// default tries D-Bus first and falls back to systemctl.
+func (d *Deployer) newUnitManager(ctx context.Context) (unitManager, error) {
+	cfg := d.config.Restart
+	systemctl := cfg.Systemctl
+	if systemctl == "" {
+		systemctl = defaultSystemctl
+	}
+
+	switch cfg.Backend {
+	case "systemctl":
+		return &systemctlUnitManager{path: systemctl}, nil
+	case "dbus":
+		conn, err := dialSystemBus(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &dbusUnitManager{conn: conn}, nil
+	case "", "auto":
+		conn, err := dialSystemBus(ctx)
+		if err != nil {
+			d.log(ctx).Warn("D-Bus unavailable, falling back to systemctl", "error", err, "systemctl", systemctl)
+			return &systemctlUnitManager{path: systemctl}, nil
+		}
+		return &dbusUnitManager{conn: conn}, nil
+	}
+	return nil, fmt.Errorf("unknown restart backend %q", cfg.Backend)
+}
+
+// restartUnits restarts and reloads the configured systemd units and waits
+// for each of them to become active. A unit that fails is reported as an error.
+func (d *Deployer) restartUnits(ctx context.Context) error {
+	cfg := d.config.Restart
+	if cfg.Mode != "systemd" || len(cfg.Units)+len(cfg.ReloadUnits) == 0 {
+		return nil
+	}
+
+	timeout := defaultRestartTimeout
+	if cfg.TimeoutSecs > 0 {
+		timeout = time.Duration(cfg.TimeoutSecs) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log := d.log(ctx).With("phase", "restart")
+	start := time.Now()
+	mgr, err := d.newUnitManager(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	defer func() { _ = mgr.Close() }()
+
+	for _, unit := range cfg.Units {
+		log.Info("Restarting systemd unit", "unit", unit)
+		if err := mgr.RestartUnit(ctx, unit); err != nil {
+			return err
+		}
+	}
+	for _, unit := range cfg.ReloadUnits {
+		log.Info("Reloading systemd unit", "unit", unit)
+		if err := mgr.ReloadUnit(ctx, unit); err != nil {
+			return err
+		}
+	}
+
+	for _, unit := range append(cfg.Units[:len(cfg.Units):len(cfg.Units)], cfg.ReloadUnits...) {
+		if err := waitUnitActive(ctx, mgr, unit); err != nil {
+			return err
+		}
+		log.Info("Systemd unit is active", "unit", unit)
+	}
+	log.Info("Systemd units restarted", durationMS(time.Since(start)))
+	return nil
+}
+
+// waitUnitActive polls a unit until it is active, has failed, or ctx expires
+func waitUnitActive(ctx context.Context, mgr unitManager, unit string) error {
+	state := "unknown"
+	for {
+		s, err := mgr.ActiveState(ctx, unit)
+		if err == nil {
+			state = s
+			switch state {
+			case "active":
+				return nil
+			case "failed":
+				return fmt.Errorf("systemd unit %s failed", unit)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("systemd unit %s did not become active (state %s): %w", unit, state, ctx.Err())
+		case <-time.After(unitPollInterval):
+		}
+	}
+}
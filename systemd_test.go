@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSystemctl writes a systemctl stand-in that records its arguments in
+// callLog. Each restart moves the unit to the next of states; is-active
+// reports the current one.
+func fakeSystemctl(t *testing.T, states ...string) (script, callLog string) {
+	t.Helper()
+	dir := t.TempDir()
+	script = filepath.Join(dir, "systemctl")
+	stateFile := filepath.Join(dir, "state")
+	queueFile := filepath.Join(dir, "queue")
+	callLog = filepath.Join(dir, "calls")
+	content := fmt.Sprintf(`#!/bin/sh
+echo "$@" >> %[1]s
+if [ "$1" = "restart" ] && [ -s %[3]s ]; then
+  head -n 1 %[3]s > %[2]s
+  tail -n +2 %[3]s > %[3]s.new && mv %[3]s.new %[3]s
+fi
+if [ "$1" = "is-active" ]; then
+  s=$(cat %[2]s)
+  echo "$s"
+  [ "$s" = "active" ]
+fi
+`, callLog, stateFile, queueFile)
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatalf("Failed to write fake systemctl: %v", err)
+	}
+	if err := os.WriteFile(stateFile, []byte(states[0]+"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write unit state: %v", err)
+	}
+	if err := os.WriteFile(queueFile, []byte(strings.Join(states, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write unit state queue: %v", err)
+	}
+	return script, callLog
+}
+
+func TestRestartUnitsWithSystemctl(t *testing.T) {
+	script, callLog := fakeSystemctl(t, "active")
+	deployer := &Deployer{
+		config: &Config{AppConfig: AppConfig{Restart: RestartConfig{
+			Mode:        "systemd",
+			Backend:     "systemctl",
+			Systemctl:   script,
+			Units:       []string{"app.service"},
+			ReloadUnits: []string{"nginx.service"},
+		}}},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	if err := deployer.restartUnits(context.Background()); err != nil {
+		t.Fatalf("restartUnits failed: %v", err)
+	}
+
+	calls, err := os.ReadFile(callLog)
+	if err != nil {
+		t.Fatalf("Failed to read calls: %v", err)
+	}
+	for _, expected := range []string{"restart app.service", "reload nginx.service", "is-active app.service", "is-active nginx.service"} {
+		if !strings.Contains(string(calls), expected) {
+			t.Errorf("Expected systemctl call %q, got:\n%s", expected, calls)
+		}
+	}
+}
+
+func TestRestartUnitsReportsFailedUnit(t *testing.T) {
+	script, _ := fakeSystemctl(t, "failed")
+	deployer := &Deployer{
+		config: &Config{AppConfig: AppConfig{Restart: RestartConfig{
+			Mode:      "systemd",
+			Backend:   "systemctl",
+			Systemctl: script,
+			Units:     []string{"app.service"},
+		}}},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	err := deployer.restartUnits(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "app.service failed") {
+		t.Fatalf("Expected failed unit error, got %v", err)
+	}
+}
+
+func TestRestartUnitsOverDBus(t *testing.T) {
+	bus := newFakeSystemdBus(t, "active")
+	deployer := &Deployer{
+		config: &Config{AppConfig: AppConfig{Restart: RestartConfig{
+			Mode:  "systemd",
+			Units: []string{"app.service"},
+		}}},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	if err := deployer.restartUnits(context.Background()); err != nil {
+		t.Fatalf("restartUnits failed: %v", err)
+	}
+	if calls := strings.Join(bus.Calls(), "\n"); !strings.Contains(calls, "RestartUnit app.service replace") {
+		t.Errorf("Expected RestartUnit over D-Bus, got:\n%s", calls)
+	}
+}
+
+func TestDeployRollsBackWhenUnitFails(t *testing.T) {
+	// The new release fails to start; the old one comes back fine
+	script, _ := fakeSystemctl(t, "failed", "active")
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	if err := os.Symlink(filepath.Join(config.InstallDir, "blue"), config.CurrentSymlink); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.Restart = RestartConfig{
+		Mode:              "systemd",
+		Backend:           "systemctl",
+		Systemctl:         script,
+		Units:             []string{"app.service"},
+		TimeoutSecs:       2,
+		RollbackOnFailure: true,
+	}
+
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+	deployer := newTestDeployer(t, config, server)
+
+	err := deployer.checkAndDeploy(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Expected deployment to fail and roll back, got %v", err)
+	}
+	if deployer.state.ActiveSlot != "blue" {
+		t.Errorf("Expected active slot 'blue' after rollback, got '%s'", deployer.state.ActiveSlot)
+	}
+	target, err := os.Readlink(config.CurrentSymlink)
+	if err != nil || target != filepath.Join(config.InstallDir, "blue") {
+		t.Errorf("Expected symlink back on blue slot, got %q (%v)", target, err)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checkKnownFields reports every key in n that is not a setting of type t,
+// so that typos are caught instead of silently ignored
+func checkKnownFields(n *yaml.Node, t reflect.Type, path string) []error {
+	for n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var problems []error
+	switch {
+	case n.Kind == yaml.DocumentNode:
+		for _, child := range n.Content {
+			problems = append(problems, checkKnownFields(child, t, path)...)
+		}
+	case n.Kind == yaml.SequenceNode && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for i, item := range n.Content {
+			problems = append(problems, checkKnownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case n.Kind == yaml.MappingNode && t.Kind() == reflect.Map:
+		for i := 1; i < len(n.Content); i += 2 {
+			problems = append(problems, checkKnownFields(n.Content[i], t.Elem(), path+"."+n.Content[i-1].Value)...)
+		}
+	case n.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if key.Value == "<<" {
+				// A merge key: the merged mappings must hold settings of t too
+				problems = append(problems, checkMerge(value, t, path)...)
+				continue
+			}
+			setting := strings.TrimPrefix(path+"."+key.Value, ".")
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				problem := fmt.Sprintf("line %d: unknown setting %s", key.Line, setting)
+				if suggestion := closestKey(key.Value, fields); suggestion != "" {
+					problem += fmt.Sprintf(" (did you mean %s?)", suggestion)
+				}
+				problems = append(problems, fmt.Errorf("%s", problem))
+				continue
+			}
+			problems = append(problems, checkKnownFields(value, fieldType, setting)...)
+		}
+	}
+	return problems
+}
+
+// checkMerge checks the mappings merged into a mapping of type t
+func checkMerge(n *yaml.Node, t reflect.Type, path string) []error {
+	if n.Kind != yaml.SequenceNode {
+		return checkKnownFields(n, t, path)
+	}
+	var problems []error
+	for _, item := range n.Content {
+		problems = append(problems, checkKnownFields(item, t, path)...)
+	}
+	return problems
+}
+
+// yamlFields returns the type of each setting of struct type t by key,
+// including the settings of inlined structs
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		switch {
+		case key == "-":
+		case slices.Contains(strings.Split(opts, ","), "inline"):
+			for k, v := range yamlFields(field.Type) {
+				fields[k] = v
+			}
+		case key == "":
+			fields[strings.ToLower(field.Name)] = field.Type
+		default:
+			fields[key] = field.Type
+		}
+	}
+	return fields
+}
+
+// closestKey suggests the key in fields nearest to a mistyped key, if any
+// is close enough to be a likely typo
+func closestKey(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", len(key)/2+1
+	for candidate := range fields {
+		if d := editDistance(key, candidate); d < bestDistance || d == bestDistance && candidate < best {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// validate checks the values of every setting, returning all the problems
+// found
+func (c *Config) validate() []error {
+	var problems []error
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if c.CheckIntervalSecs <= 0 {
+		add("check_interval_seconds must be positive, got %d", c.CheckIntervalSecs)
+	}
+	if c.Admin.Listen != "" && c.Admin.Token == "" {
+		add("admin.token is required when admin.listen is set")
+	}
+	if c.GitHubWebhook.Listen != "" && c.GitHubWebhook.Secret == "" {
+		add("github_webhook.secret is required when github_webhook.listen is set")
+	}
+	if path := c.GitHubWebhook.Path; path != "" && !strings.HasPrefix(path, "/") {
+		add("github_webhook.path must start with /, got %q", path)
+	}
+	if _, err := parseLogLevel(c.Logging.Level); err != nil {
+		add("logging.level: %v", err)
+	}
+	if format := strings.ToLower(c.Logging.Format); format != "" && format != "text" && format != "json" {
+		add("logging.format must be text or json, got %q", c.Logging.Format)
+	}
+	if _, err := parseSize(c.Logging.MaxSize); err != nil {
+		add("logging.max_size: %v", err)
+	}
+	if c.Logging.MaxBackups < 0 {
+		add("logging.max_backups must not be negative, got %d", c.Logging.MaxBackups)
+	}
+	if c.Logging.MaxAge < 0 {
+		add("logging.max_age must not be negative, got %d", c.Logging.MaxAge)
+	}
+	if c.Notifications.Attempts < 0 {
+		add("notifications.attempts must not be negative, got %d", c.Notifications.Attempts)
+	}
+	if _, err := NewNotifier(c.Notifications, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		problems = append(problems, err)
+	}
+
+	if len(c.Apps) == 0 {
+		return append(problems, c.AppConfig.validate("")...)
+	}
+
+	if c.Repo != "" || c.InstallDir != "" || c.CurrentSymlink != "" {
+		add("repo, install_dir and current_symlink must be set on each entry in apps, not at the top level")
+	}
+	names := make(map[string]bool)
+	installDirs := make(map[string]string)
+	for i := range c.Apps {
+		app := &c.Apps[i]
+		prefix := fmt.Sprintf("apps[%d]: ", i)
+		switch {
+		case !validAppName(app.Name):
+			add("%sname is required and may only contain letters, digits, '.', '_' and '-'", prefix)
+		case names[app.Name]:
+			add("%sduplicate app name %q", prefix, app.Name)
+		default:
+			prefix = "app " + app.Name + ": "
+		}
+		names[app.Name] = true
+		problems = append(problems, app.validate(prefix)...)
+
+		// Slots, the lock and the default state file live in install_dir
+		if app.InstallDir == "" {
+			continue
+		}
+		dir := filepath.Clean(app.InstallDir)
+		if other, ok := installDirs[dir]; ok {
+			add("apps %s and %s share install_dir %s", other, app.Name, app.InstallDir)
+		}
+		installDirs[dir] = app.Name
+	}
+	return problems
+}
+
+// validate checks the app's settings, prefixing each problem found with prefix
+func (a *AppConfig) validate(prefix string) []error {
+	var problems []error
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(prefix+format, args...))
+	}
+
+	if a.Repo == "" {
+		add("repo is required in configuration")
+	} else if owner, name, ok := strings.Cut(a.Repo, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		add("repo must be in owner/repo form, got %q", a.Repo)
+	}
+	for _, setting := range []struct {
+		name, path string
+		required   bool
+	}{
+		{"install_dir", a.InstallDir, true},
+		{"current_symlink", a.CurrentSymlink, true},
+		{"shared_dir", a.SharedDir, false},
+	} {
+		if setting.path == "" {
+			if setting.required {
+				add("%s is required in configuration", setting.name)
+			}
+		} else if !filepath.IsAbs(setting.path) {
+			add("%s must be an absolute path, got %q", setting.name, setting.path)
+		}
+	}
+
+	if a.HealthCheckURL != "" {
+		if u, err := url.Parse(a.HealthCheckURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("health_check_url must be an http or https URL, got %q", a.HealthCheckURL)
+		}
+	}
+	if a.HealthCheckTimeout < 0 {
+		add("health_check_timeout must not be negative, got %d", a.HealthCheckTimeout)
+	}
+
+	for i, sp := range a.SharedPaths {
+		if _, err := cleanSharedPath(sp.Path); err != nil {
+			add("shared_paths[%d]: %v", i, err)
+		}
+		if sp.Type != "" && sp.Type != "file" && sp.Type != "dir" {
+			add("shared_paths[%d].type must be file or dir, got %q", i, sp.Type)
+		}
+		if sp.Mode != "" && sp.Mode != "symlink" && sp.Mode != "copy" {
+			add("shared_paths[%d].mode must be symlink or copy, got %q", i, sp.Mode)
+		}
+	}
+
+	checkExec := func(setting string, ec ExecConfig) {
+		if ec.Umask != "" {
+			if _, err := parseUmask(ec.Umask); err != nil {
+				add("%s.umask: %v", setting, err)
+			}
+		}
+	}
+	checkExec("hook_defaults", a.HookDefaults)
+	if a.RunCommand != "" && a.Hooks.Install.Command != "" {
+		add("set only one of run_command and hooks.install.command")
+	}
+	if a.PostDeployScript != "" && a.Hooks.PostDeploy.Command != "" {
+		add("set only one of post_deploy_script and hooks.post_deploy.command")
+	}
+	for _, hook := range []struct {
+		name string
+		cfg  HookConfig
+	}{
+		{hookPreDownload, a.Hooks.PreDownload},
+		{hookPostExtract, a.Hooks.PostExtract},
+		{hookInstall, a.Hooks.Install},
+		{hookPreSwitch, a.Hooks.PreSwitch},
+		{hookPostSwitch, a.Hooks.PostSwitch},
+		{hookPostDeploy, a.Hooks.PostDeploy},
+		{hookOnFailure, a.Hooks.OnFailure},
+		{hookPostRollback, a.Hooks.PostRollback},
+	} {
+		if hook.cfg.TimeoutSecs < 0 {
+			add("hooks.%s.timeout_seconds must not be negative, got %d", hook.name, hook.cfg.TimeoutSecs)
+		}
+		checkExec("hooks."+hook.name, hook.cfg.ExecConfig)
+	}
+
+	if a.Supervise.Enabled {
+		if a.Supervise.Command == "" {
+			add("supervise.command is required when supervise.enabled is set")
+		}
+		if a.Supervise.StopTimeoutSecs < 0 || a.Supervise.RestartBackoffSecs < 0 || a.Supervise.MaxRestartBackoffSecs < 0 {
+			add("supervise timeouts and backoffs must not be negative")
+		}
+		checkExec("supervise", a.Supervise.ExecConfig)
+	}
+
+	if a.MinReleaseAge != "" {
+		if age, err := time.ParseDuration(a.MinReleaseAge); err != nil || age < 0 {
+			add("min_release_age must be a duration like 24h, got %q", a.MinReleaseAge)
+		}
+	}
+	if _, err := parseRolloutStages(a.Rollout.Stages, false); err != nil {
+		add("rollout.%v", err)
+	}
+	if _, err := newDeployWindow(a.DeployWindow); err != nil {
+		add("deploy_window.%v", err)
+	}
+
+	switch a.Restart.Mode {
+	case "":
+	case "systemd":
+		if len(a.Restart.Units)+len(a.Restart.ReloadUnits) == 0 {
+			add("restart.units or restart.reload_units is required with restart.mode systemd")
+		}
+	default:
+		add("restart.mode must be systemd, got %q", a.Restart.Mode)
+	}
+	switch a.Restart.Backend {
+	case "", "auto", "dbus", "systemctl":
+	default:
+		add("restart.backend must be auto, dbus or systemctl, got %q", a.Restart.Backend)
+	}
+	if a.Restart.TimeoutSecs < 0 {
+		add("restart.timeout_seconds must not be negative, got %d", a.Restart.TimeoutSecs)
+	}
+	return problems
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// validConfig is a minimal config that passes validation
+const validConfig = `repo: test/repo
+install_dir: /opt/app/deployments
+current_symlink: /opt/app/current
+`
+
+// loadProblems loads a config that is expected to be invalid and returns
+// its problems
+func loadProblems(t *testing.T, content string) string {
+	t.Helper()
+	_, err := LoadConfig(writeConfig(t, content))
+	if err == nil {
+		t.Fatalf("Expected config to be rejected:\n%s", content)
+	}
+	return err.Error()
+}
+
+func TestLoadConfigRejectsUnknownSettings(t *testing.T) {
+	problems := loadProblems(t, validConfig+`check_interval_second: 60
+logging:
+  levle: debug
+hooks:
+  post_switch:
+    comand: "systemctl restart app"
+notifications:
+  webhooks:
+    - url: http://example.com/hook
+      header:
+        X-Token: abc
+`)
+	for _, want := range []string{
+		"line 4: unknown setting check_interval_second (did you mean check_interval_seconds?)",
+		"line 6: unknown setting logging.levle (did you mean level?)",
+		"line 9: unknown setting hooks.post_switch.comand (did you mean command?)",
+		"line 13: unknown setting notifications.webhooks[0].header (did you mean headers?)",
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("Expected %q in:\n%s", want, problems)
+		}
+	}
+}
+
+func TestLoadConfigAcceptsKnownSettings(t *testing.T) {
+	// Inlined, anchored and free-form map settings are all known
+	content := validConfig + `hook_defaults: &defaults
+  user: app
+  umask: "027"
+  env:
+    ANY_NAME: value
+hooks:
+  pre_switch:
+    <<: *defaults
+    command: ./smoke-test.sh
+notifications:
+  templates:
+    rollback: "Rolled back {{.Tag}}"
+`
+	if _, err := LoadConfig(writeConfig(t, content)); err != nil {
+		t.Errorf("Expected config to load, got %v", err)
+	}
+}
+
+func TestLoadConfigReportsAllProblems(t *testing.T) {
+	problems := loadProblems(t, `repo: no-owner
+install_dir: deployments
+current_symlink: ./current
+check_interval_seconds: -5
+health_check_url: localhost:8080/health
+health_check_timeout: soon
+shared_paths:
+  - path: ../outside
+    mode: hardlink
+hooks:
+  pre_switch:
+    command: ./check.sh
+    umask: "999"
+restart:
+  mode: systemd
+supervise:
+  enabled: true
+admin:
+  listen: 127.0.0.1:8089
+logging:
+  level: verbose
+  format: xml
+  max_size: lots
+notifications:
+  events: [deployed]
+rollout:
+  stages:
+    - percent: 5
+      after: 0s
+    - percent: 50
+      after: 6h
+`)
+	for _, want := range []string{
+		"cannot unmarshal !!str `soon` into int",
+		"check_interval_seconds must be positive",
+		"repo must be in owner/repo form",
+		"install_dir must be an absolute path",
+		"current_symlink must be an absolute path",
+		"health_check_url must be an http or https URL",
+		`shared_paths[0]: shared path "../outside" escapes the slot directory`,
+		"shared_paths[0].mode must be symlink or copy",
+		`hooks.pre_switch.umask: invalid umask "999"`,
+		"restart.units or restart.reload_units is required",
+		"supervise.command is required",
+		"admin.token is required",
+		`logging.level: unknown log level "verbose"`,
+		"logging.format must be text or json",
+		`logging.max_size: invalid size "lots"`,
+		"rollout.stages[1].percent must be 100 so that every host deploys in the end, got 50",
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("Expected %q in:\n%s", want, problems)
+		}
+	}
+}
+
+func TestLoadConfigPrefixesAppProblems(t *testing.T) {
+	problems := loadProblems(t, `apps:
+  - name: web
+    repo: test/web
+    install_dir: /srv/web
+    current_symlink: current
+  - name: worker
+    install_dir: /srv/worker
+    current_symlink: /srv/worker/current
+`)
+	for _, want := range []string{
+		"app web: current_symlink must be an absolute path",
+		"app worker: repo is required",
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("Expected %q in:\n%s", want, problems)
+		}
+	}
+}
+
+func TestValidateConfigCommand(t *testing.T) {
+	valid := writeConfig(t, validConfig)
+	invalid := writeConfig(t, validConfig+"check_interval_seconds: 0\nlogging:\n  format: xml\n")
+
+	var out bytes.Buffer
+	if err := validateConfigCommand(valid, nil, &out); err != nil {
+		t.Fatalf("Expected %s to be valid, got %v", valid, err)
+	}
+	if out.String() != valid+": OK\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	out.Reset()
+	err := validateConfigCommand("ignored.yaml", []string{valid, invalid}, &out)
+	if !errors.Is(err, errInvalidConfig) {
+		t.Fatalf("Expected errInvalidConfig, got %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || lines[0] != valid+": OK" {
+		t.Fatalf("Expected one line per file and problem, got:\n%s", out.String())
+	}
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, invalid+": ") {
+			t.Errorf("Expected problem to name its file, got %q", line)
+		}
+	}
+}
+
+func TestValidateConfigCommandWarnsAboutUnknownOverrides(t *testing.T) {
+	t.Setenv("DEPLOYER_CHECK_INTERVAL_SECOND", "60")
+	path := writeConfig(t, validConfig)
+
+	var out bytes.Buffer
+	if err := validateConfigCommand(path, nil, &out); err != nil {
+		t.Fatalf("Expected an unknown override not to fail validation, got %v", err)
+	}
+	want := "warning: ignoring DEPLOYER_CHECK_INTERVAL_SECOND: unknown setting\n" + path + ": OK\n"
+	if out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
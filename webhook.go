@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxWebhookPayload bounds the size of a GitHub webhook delivery we read
+const maxWebhookPayload = 5 << 20
+
+// defaultWebhookActions are the release actions that trigger a check.
+// released also covers a prerelease being promoted; prereleased is left
+// out since the latest release is never a prerelease.
+var defaultWebhookActions = []string{"published", "released"}
+
+// releaseEvent is the part of a GitHub release webhook payload we use
+type releaseEvent struct {
+	Action     string `json:"action"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Release struct {
+		TagName string `json:"tag_name"`
+	} `json:"release"`
+}
+
+// startWebhookServer starts the GitHub webhook receiver if it is configured
+func (dm *Daemon) startWebhookServer() (*http.Server, error) {
+	cfg := dm.config.GitHubWebhook
+	if cfg.Listen == "" {
+		return nil, nil
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("github_webhook.secret is required when github_webhook.listen is set")
+	}
+
+	listener, err := listenAddress(cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	path := cfg.Path
+	if path == "" {
+		path = "/webhook"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, dm.webhookHandler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			dm.logger.Error("GitHub webhook receiver stopped", "error", err)
+		}
+	}()
+	dm.logger.Info("Receiving GitHub webhooks", "address", cfg.Listen, "path", path)
+	return srv, nil
+}
+
+// webhookHandler validates GitHub release events and triggers an immediate
+// check of every app deployed from the event's repo
+func (dm *Daemon) webhookHandler() http.Handler {
+	secret := []byte(dm.config.GitHubWebhook.Secret)
+	actions := dm.config.GitHubWebhook.Actions
+	if len(actions) == 0 {
+		actions = defaultWebhookActions
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookPayload+1))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxWebhookPayload {
+			http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !validSignature(secret, body, r.Header.Get("X-Hub-Signature-256")) {
+			dm.logger.Warn("Rejected GitHub webhook with invalid signature", "remote", r.RemoteAddr)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		switch event := r.Header.Get("X-GitHub-Event"); event {
+		case "ping":
+			_, _ = io.WriteString(w, "pong\n")
+			return
+		case "release":
+		default:
+			dm.logger.Debug("Ignoring GitHub webhook", "event", event)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		var payload releaseEvent
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		log := dm.logger.With("action", payload.Action, "repo", payload.Repository.FullName, "tag", payload.Release.TagName)
+		var apps []*Deployer
+		if slices.Contains(actions, payload.Action) {
+			for _, d := range dm.apps {
+				if strings.EqualFold(payload.Repository.FullName, d.currentConfig().Repo) {
+					apps = append(apps, d)
+				}
+			}
+		}
+		if len(apps) == 0 {
+			log.Debug("Ignoring GitHub release event")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		log.Info("GitHub release event received, triggering check")
+		for _, d := range apps {
+			d.triggerCheck()
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// validSignature checks an X-Hub-Signature-256 header against the HMAC of body
+func validSignature(secret, body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// triggerCheck asks the polling loop to check for releases now. Triggers
+// that arrive while one is already pending are merged into it.
+func (d *Deployer) triggerCheck() {
+	select {
+	case d.trigger <- struct{}{}:
+	default:
+	}
+}
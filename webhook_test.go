@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newWebhookTestServer serves the GitHub webhook receiver of a deployer for test/repo
+func newWebhookTestServer(t *testing.T) (*Deployer, *httptest.Server) {
+	t.Helper()
+	releases := newTestReleaseServer(t, "v1.0.0", map[string]string{"app.txt": "v1"})
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.GitHubWebhook = GitHubWebhookConfig{Listen: "127.0.0.1:0", Secret: "hook-secret"}
+	d := newTestDeployer(t, config, releases)
+
+	server := httptest.NewServer(newTestDaemon(d).webhookHandler())
+	t.Cleanup(server.Close)
+	return d, server
+}
+
+// sendWebhook delivers a GitHub event, signed with secret unless it is empty
+func sendWebhook(t *testing.T, server *httptest.Server, event, secret, body string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("X-GitHub-Event", event)
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Webhook request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func releasePayload(action, repo string) string {
+	return `{"action":"` + action + `","repository":{"full_name":"` + repo + `"},"release":{"tag_name":"v2.0.0"}}`
+}
+
+// triggered reports whether a check has been requested, consuming the request
+func triggered(d *Deployer) bool {
+	select {
+	case <-d.trigger:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestWebhookRejectsBadSignatures(t *testing.T) {
+	d, server := newWebhookTestServer(t)
+	body := releasePayload("published", "test/repo")
+
+	if code := sendWebhook(t, server, "release", "", body); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a signature, got %d", code)
+	}
+	if code := sendWebhook(t, server, "release", "wrong-secret", body); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a bad signature, got %d", code)
+	}
+	if triggered(d) {
+		t.Error("Expected no check to be triggered by unsigned deliveries")
+	}
+}
+
+func TestWebhookTriggersCheckForMatchingReleases(t *testing.T) {
+	d, server := newWebhookTestServer(t)
+
+	if code := sendWebhook(t, server, "ping", "hook-secret", `{"zen":"hi"}`); code != http.StatusOK {
+		t.Errorf("Expected 200 for ping, got %d", code)
+	}
+
+	for _, tc := range []struct {
+		event, action, repo string
+		want                bool
+	}{
+		{"release", "published", "test/repo", true},
+		{"release", "released", "Test/Repo", true},
+		{"release", "prereleased", "test/repo", false},
+		{"release", "deleted", "test/repo", false},
+		{"release", "published", "other/repo", false},
+		{"push", "published", "test/repo", false},
+	} {
+		code := sendWebhook(t, server, tc.event, "hook-secret", releasePayload(tc.action, tc.repo))
+		if got := triggered(d); got != tc.want {
+			t.Errorf("%s %s for %s: expected triggered=%v, got %v", tc.event, tc.action, tc.repo, tc.want, got)
+		}
+		if tc.want && code != http.StatusAccepted {
+			t.Errorf("%s %s for %s: expected 202, got %d", tc.event, tc.action, tc.repo, code)
+		} else if !tc.want && code != http.StatusNoContent {
+			t.Errorf("%s %s for %s: expected 204, got %d", tc.event, tc.action, tc.repo, code)
+		}
+	}
+
+	// Deliveries that arrive while a check is pending are merged into it
+	sendWebhook(t, server, "release", "hook-secret", releasePayload("published", "test/repo"))
+	sendWebhook(t, server, "release", "hook-secret", releasePayload("published", "test/repo"))
+	if !triggered(d) || triggered(d) {
+		t.Error("Expected repeated deliveries to queue a single check")
+	}
+}
+
+func TestRunChecksWhenTriggered(t *testing.T) {
+	d, _ := newWebhookTestServer(t)
+	d.config.CheckIntervalSecs = 3600
+	d.config.GitHubWebhook = GitHubWebhookConfig{}
+
+	checks := func() uint64 {
+		d.metrics.mu.Lock()
+		defer d.metrics.mu.Unlock()
+		return d.metrics.checks
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- newTestDaemon(d).Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if !waitFor(t, 5*time.Second, func() bool { return checks() == 1 }) {
+		t.Fatalf("Expected the initial check, got %d checks", checks())
+	}
+	d.triggerCheck()
+	if !waitFor(t, 5*time.Second, func() bool { return checks() == 2 }) {
+		t.Errorf("Expected a triggered check, got %d checks", checks())
+	}
+}
+
+func TestStartWebhookServerRequiresSecret(t *testing.T) {
+	dm := &Daemon{config: &Config{GitHubWebhook: GitHubWebhookConfig{Listen: "127.0.0.1:0"}}}
+	if _, err := dm.startWebhookServer(); err == nil {
+		t.Error("Expected an error without github_webhook.secret")
+	}
+}
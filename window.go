@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errDeployDeferred marks a release that was staged in the inactive slot
+// but not switched to, because deployments are not allowed right now
+var errDeployDeferred = errors.New("switch deferred")
+
+// Names accepted in the month and day-of-week fields of a window
+var (
+	cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// cronSchedule is a parsed cron expression. Each field is a bit set of the
+// values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Like cron, when both day fields are restricted either may match
+	domAny, dowAny bool
+}
+
+// parseCron parses a five-field cron expression: minute, hour, day of
+// month, month and day of week
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+	s := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	for _, f := range []struct {
+		bits     *uint64
+		field    string
+		min, max int
+		names    []string
+	}{
+		{&s.minute, fields[0], 0, 59, nil},
+		{&s.hour, fields[1], 0, 23, nil},
+		{&s.dom, fields[2], 1, 31, nil},
+		{&s.month, fields[3], 1, 12, cronMonths},
+		{&s.dow, fields[4], 0, 7, cronDays},
+	} {
+		if *f.bits, err = parseCronField(f.field, f.min, f.max, f.names); err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+	}
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps
+// such as "*/15", "9-17" or "mon-fri"
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = cronValue(first, min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = cronValue(last, min, max, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("range %q is backwards", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// cronValue parses a single number or name within [min, max]
+func cronValue(s string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("%q is not a value from %d to %d", s, min, max)
+	}
+	return v, nil
+}
+
+// matchesDay reports whether the schedule matches any time on t's day
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	if s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.domAny || s.dowAny:
+		return dom && dow
+	default:
+		return dom || dow
+	}
+}
+
+// matchesHour reports whether the schedule matches any time in t's hour
+func (s *cronSchedule) matchesHour(t time.Time) bool {
+	return s.matchesDay(t) && s.hour&(1<<t.Hour()) != 0
+}
+
+// matches reports whether the schedule matches t's minute
+func (s *cronSchedule) matches(t time.Time) bool {
+	return s.matchesHour(t) && s.minute&(1<<t.Minute()) != 0
+}
+
+// deployWindow decides when automatic deployments may switch slots
+type deployWindow struct {
+	location *time.Location
+	allow    []*cronSchedule
+	// blackouts are inclusive ranges of dates formatted as time.DateOnly
+	blackouts [][2]string
+}
+
+// newDeployWindow parses the deploy_window settings
+func newDeployWindow(cfg DeployWindowConfig) (*deployWindow, error) {
+	w := &deployWindow{location: time.Local}
+	if cfg.Timezone != "" {
+		location, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone: %w", err)
+		}
+		w.location = location
+	}
+	for _, expr := range cfg.Allow {
+		schedule, err := parseCron(expr)
+		if err != nil {
+			return nil, fmt.Errorf("allow: %w", err)
+		}
+		w.allow = append(w.allow, schedule)
+	}
+	for _, dates := range cfg.BlackoutDates {
+		first, last, isRange := strings.Cut(dates, "..")
+		if !isRange {
+			last = first
+		}
+		for _, date := range []string{first, last} {
+			if _, err := time.Parse(time.DateOnly, date); err != nil {
+				return nil, fmt.Errorf("blackout_dates: %q is not a date or a range of dates like 2024-12-24..2024-12-26", dates)
+			}
+		}
+		if first > last {
+			return nil, fmt.Errorf("blackout_dates: range %q is backwards", dates)
+		}
+		w.blackouts = append(w.blackouts, [2]string{first, last})
+	}
+	return w, nil
+}
+
+// blackout reports whether t falls on a blackout date
+func (w *deployWindow) blackout(t time.Time) bool {
+	date := t.In(w.location).Format(time.DateOnly)
+	for _, b := range w.blackouts {
+		if date >= b[0] && date <= b[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether any allowed window matches t, using match to
+// compare at the granularity of a day, an hour or a minute
+func (w *deployWindow) allows(t time.Time, match func(*cronSchedule, time.Time) bool) bool {
+	if w.blackout(t) {
+		return false
+	}
+	if len(w.allow) == 0 {
+		return true
+	}
+	t = t.In(w.location)
+	for _, schedule := range w.allow {
+		if match(schedule, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// open reports whether deployments are allowed at t
+func (w *deployWindow) open(t time.Time) bool {
+	return w.allows(t, (*cronSchedule).matches)
+}
+
+// nextOpen returns when deployments are next allowed at or after t, if
+// they are within the next year
+func (w *deployWindow) nextOpen(t time.Time) (time.Time, bool) {
+	t = t.In(w.location).Truncate(time.Minute)
+	limit := t.AddDate(1, 0, 1)
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		var next time.Time
+		switch {
+		case !w.allows(t, (*cronSchedule).matchesDay):
+			next = time.Date(year, month, day+1, 0, 0, 0, 0, w.location)
+		case !w.allows(t, (*cronSchedule).matchesHour):
+			next = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, w.location)
+		case w.open(t):
+			return t, true
+		default:
+			next = t.Add(time.Minute)
+		}
+		// Daylight saving changes can map a wall clock time backwards
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}, false
+}
+
+// readFreeze reports whether the freeze file at path exists, and the reason
+// written in it. A freeze file that cannot be read counts as a freeze.
+func readFreeze(path string) (frozen bool, reason string) {
+	if path == "" {
+		return false, ""
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, ""
+	}
+	if err != nil {
+		return true, err.Error()
+	}
+	return true, strings.TrimSpace(string(data))
+}
+
+// freezeError describes a freeze as an error
+func freezeError(reason string) error {
+	if reason == "" {
+		return errors.New("deployments are frozen")
+	}
+	return fmt.Errorf("deployments are frozen: %s", reason)
+}
+
+// deferReason explains why an automatic deployment may not switch slots at
+// now, or returns "" if it may
+func (d *Deployer) deferReason(now time.Time) string {
+	if frozen, reason := readFreeze(d.config.FreezeFile); frozen {
+		return freezeError(reason).Error()
+	}
+	window, err := newDeployWindow(d.config.DeployWindow)
+	if err != nil {
+		// Validation rejects this, so it only happens to hand-built configs
+		return fmt.Sprintf("invalid deploy_window: %v", err)
+	}
+	if window.open(now) {
+		return ""
+	}
+	if opens, ok := window.nextOpen(now); ok {
+		return "outside the deploy window, which opens at " + opens.Format(time.RFC3339)
+	}
+	return "outside the deploy window, which does not open within a year"
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeployWindowOpen(t *testing.T) {
+	window, err := newDeployWindow(DeployWindowConfig{
+		Timezone:      "Asia/Tokyo",
+		Allow:         []string{"*/30 18-23 * * mon-fri", "* * * * sat,sun"},
+		BlackoutDates: []string{"2024-12-24..2024-12-26", "2025-01-01"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+
+	for _, tc := range []struct {
+		time string
+		open bool
+	}{
+		{"2024-12-02 18:00", true},  // Monday evening
+		{"2024-12-02 18:15", false}, // not on the half hour
+		{"2024-12-02 17:30", false}, // before the evening window
+		{"2024-12-07 10:07", true},  // Saturday
+		{"2024-12-25 19:00", false}, // blackout
+		{"2025-01-01 12:00", false}, // blackout on a Wednesday anyway
+		{"2024-12-28 12:00", true},  // Saturday after the blackout
+	} {
+		at, _ := time.ParseInLocation("2006-01-02 15:04", tc.time, tokyo)
+		if got := window.open(at); got != tc.open {
+			t.Errorf("open(%s) = %t, want %t", tc.time, got, tc.open)
+		}
+		// The window is evaluated in its own timezone
+		if got := window.open(at.UTC()); got != tc.open {
+			t.Errorf("open(%s in UTC) = %t, want %t", tc.time, got, tc.open)
+		}
+	}
+
+	for _, tc := range []struct{ from, want string }{
+		{"2024-12-02 18:00", "2024-12-02 18:00"},
+		{"2024-12-02 18:01", "2024-12-02 18:30"},
+		{"2024-12-02 23:45", "2024-12-03 18:00"},
+		{"2024-12-24 09:00", "2024-12-27 18:00"},
+		{"2024-12-31 23:59", "2025-01-02 18:00"},
+	} {
+		from, _ := time.ParseInLocation("2006-01-02 15:04", tc.from, tokyo)
+		next, ok := window.nextOpen(from)
+		if got := next.In(tokyo).Format("2006-01-02 15:04"); !ok || got != tc.want {
+			t.Errorf("nextOpen(%s) = %s, want %s", tc.from, got, tc.want)
+		}
+	}
+}
+
+func TestParseCronDayFields(t *testing.T) {
+	// With both day fields restricted, either may match
+	s, err := parseCron("0 9 1 * mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for date, want := range map[string]bool{
+		"2024-07-03": false, // a Wednesday
+		"2024-07-08": true,  // a Monday
+		"2024-08-01": true,  // the first, a Thursday
+	} {
+		at, _ := time.Parse(time.DateOnly, date)
+		if got := s.matches(at.Add(9 * time.Hour)); got != want {
+			t.Errorf("matches(%s) = %t, want %t", date, got, want)
+		}
+	}
+
+	// Sunday is 0 and 7
+	s, err = parseCron("* * * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.matches(time.Date(2024, time.July, 7, 12, 0, 0, 0, time.UTC)) {
+		t.Error("Expected 7 to match Sunday")
+	}
+
+	for _, bad := range []string{"* * * *", "60 * * * *", "* 5-1 * * *", "*/0 * * * *", "* * * foo *"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Errorf("parseCron(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestLoadConfigRejectsInvalidDeployWindow(t *testing.T) {
+	problems := loadProblems(t, validConfig+`deploy_window:
+  timezone: Mars/Olympus_Mons
+  allow: ["* 25 * * *"]
+  blackout_dates: [2024-12-26..2024-12-24]
+`)
+	if !strings.Contains(problems, "deploy_window.timezone") {
+		t.Errorf("Expected the timezone to be rejected, got:\n%s", problems)
+	}
+}
+
+// closedWindow is a deploy window that is closed today and tomorrow
+func closedWindow() DeployWindowConfig {
+	today := time.Now().UTC()
+	return DeployWindowConfig{
+		Timezone:      "UTC",
+		BlackoutDates: []string{today.Format(time.DateOnly) + ".." + today.AddDate(0, 0, 1).Format(time.DateOnly)},
+	}
+}
+
+func TestDeployWaitsForWindow(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.DeployWindow = closedWindow()
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+	deployer := newTestDeployer(t, config, server)
+
+	if err := deployer.checkAndDeploy(context.Background()); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if deployer.getCurrentVersion() != "v1.0.0" || deployer.stagedTag != "v2.0.0" || len(deployer.state.History) != 0 {
+		t.Fatalf("Expected v2.0.0 to be staged but not deployed, got %+v staged %q", deployer.state, deployer.stagedTag)
+	}
+	green := filepath.Join(config.InstallDir, "green")
+	if _, err := os.Stat(filepath.Join(green, "app.py")); err != nil {
+		t.Errorf("Expected the release to be extracted into the green slot: %v", err)
+	}
+	if !strings.Contains(deployer.idleStatus(), "v2.0.0 staged") {
+		t.Errorf("Expected the status to mention the staged release, got %q", deployer.idleStatus())
+	}
+	if err := deployer.Rollback(context.Background()); err == nil {
+		t.Error("Expected rollback onto the staged release to be refused")
+	}
+
+	// Once the window opens the staged release is switched to without
+	// downloading it again
+	downloaded := deployer.metrics.downloadBytes
+	deployer.config.DeployWindow = DeployWindowConfig{}
+	if err := deployer.checkAndDeploy(context.Background()); err != nil {
+		t.Fatalf("Deployment failed: %v", err)
+	}
+	if deployer.getCurrentVersion() != "v2.0.0" || deployer.stagedTag != "" {
+		t.Errorf("Expected v2.0.0 to be deployed, got %+v", deployer.state)
+	}
+	if deployer.metrics.downloadBytes != downloaded {
+		t.Error("Expected the staged release not to be downloaded again")
+	}
+	if target, _ := os.Readlink(config.CurrentSymlink); target != green {
+		t.Errorf("Expected symlink to point at green, got %s", target)
+	}
+}
+
+func TestStagedReleaseSurvivesRestart(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0", GreenVersion: "v0.9.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.DeployWindow = closedWindow()
+	if err := os.Symlink(filepath.Join(config.InstallDir, "blue"), config.CurrentSymlink); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+	if err := newTestDeployer(t, config, server).checkAndDeploy(context.Background()); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	// After a restart the staged release is still known, but never taken
+	// for the release the green slot was deployed with
+	deployer := newTestDeployer(t, config, server)
+	if deployer.stagedTag != "v2.0.0" || deployer.state.GreenVersion != "v0.9.0" {
+		t.Fatalf("Expected v2.0.0 staged and the recorded green version kept, got %+v staged %q",
+			deployer.state, deployer.stagedTag)
+	}
+	err := deployer.Rollback(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "staged release v2.0.0") {
+		t.Errorf("Expected rollback onto the staged release to be refused, got %v", err)
+	}
+	if target, _ := os.Readlink(config.CurrentSymlink); target != filepath.Join(config.InstallDir, "blue") {
+		t.Errorf("Expected symlink to stay on blue, got %s", target)
+	}
+
+	downloaded := deployer.metrics.downloadBytes
+	deployer.config.DeployWindow = DeployWindowConfig{}
+	if err := deployer.checkAndDeploy(context.Background()); err != nil {
+		t.Fatalf("Deployment failed: %v", err)
+	}
+	if deployer.getCurrentVersion() != "v2.0.0" || deployer.metrics.downloadBytes != downloaded {
+		t.Errorf("Expected the staged release to be switched to without downloading it again, got %+v", deployer.state)
+	}
+	manifest, err := readSlotManifest(filepath.Join(config.InstallDir, "green"))
+	if err != nil || manifest.Staged {
+		t.Errorf("Expected the staged mark to be cleared on switching, got %+v, %v", manifest, err)
+	}
+}
+
+func TestFreezeFile(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.FreezeFile = filepath.Join(t.TempDir(), "freeze")
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+	deployer := newTestDeployer(t, config, server)
+
+	var out bytes.Buffer
+	if err := freezeCommand(config, "freeze", []string{"launch", "week"}, &out); err != nil {
+		t.Fatalf("freeze failed: %v", err)
+	}
+	if err := deployer.checkAndDeploy(context.Background()); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if deployer.getCurrentVersion() != "v1.0.0" || deployer.stagedTag != "v2.0.0" {
+		t.Errorf("Expected the release to be staged while frozen, got %+v", deployer.state)
+	}
+	err := deployer.DeployTag(context.Background(), "v2.0.0")
+	if err == nil || !strings.Contains(err.Error(), "frozen: launch week") {
+		t.Errorf("Expected a manual deployment to be refused with the reason, got %v", err)
+	}
+	if status, _ := deployer.status(); !status.Frozen || status.FreezeReason != "launch week" || status.StagedTag != "v2.0.0" {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	if err := freezeCommand(config, "unfreeze", nil, &out); err != nil {
+		t.Fatalf("unfreeze failed: %v", err)
+	}
+	if err := deployer.checkAndDeploy(context.Background()); err != nil {
+		t.Fatalf("Deployment failed: %v", err)
+	}
+	if deployer.getCurrentVersion() != "v2.0.0" {
+		t.Errorf("Expected v2.0.0 to be deployed after unfreezing, got %+v", deployer.state)
+	}
+}
+
+func TestManualDeployIgnoresWindow(t *testing.T) {
+	config := setupSlots(t, &DeploymentState{ActiveSlot: "blue", BlueVersion: "v1.0.0"})
+	config.Repo = "test/repo"
+	config.AssetSuffix = ".tar.gz"
+	config.DeployWindow = closedWindow()
+	server := newTestReleaseServer(t, "v2.0.0", map[string]string{"app.py": "print('hi')\n"})
+	deployer := newTestDeployer(t, config, server)
+
+	if err := deployer.DeployTag(context.Background(), "v2.0.0"); err != nil {
+		t.Fatalf("Deployment failed: %v", err)
+	}
+	if deployer.getCurrentVersion() != "v2.0.0" {
+		t.Errorf("Expected a manual deployment to switch outside the window, got %+v", deployer.state)
+	}
+}